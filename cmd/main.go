@@ -4,11 +4,12 @@ import (
 	"context"
 	"flag"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/theonlyway/avm-module-sync/internal/ado"
 	"github.com/theonlyway/avm-module-sync/internal/avmmodules"
 	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
 	"go.uber.org/zap"
 )
 
@@ -52,6 +53,7 @@ func logFlags(logger *zap.SugaredLogger) {
 	sensitiveFlags := map[string]bool{
 		"ado-session-token": true,
 		"ado-pat":           true,
+		"github-token":      true,
 	}
 
 	logger.Info("Configuration flags:")
@@ -74,7 +76,15 @@ func Main() {
 	flag.BoolVar(&config.ProcessResourceModules, "process-resource", true, "Process resource modules")
 	flag.BoolVar(&config.ProcessPatternModules, "process-pattern", false, "Process pattern modules")
 	flag.BoolVar(&config.ProcessUtilityModules, "process-utility", true, "Process utility modules")
+	flag.BoolVar(&config.FollowParentModules, "follow-parent-modules", true, "Include child resource modules (ParentModule column) whenever their parent is included and sync them after the parent")
+	flag.BoolVar(&config.ProcessBicepResourceModules, "process-bicep-resource", false, "Process Bicep resource modules")
+	flag.BoolVar(&config.ProcessBicepPatternModules, "process-bicep-pattern", false, "Process Bicep pattern modules")
 	flag.BoolVar(&config.CleanTempDirs, "cleanup-temp-dirs", false, "Clean temporary directories before processing")
+	flag.StringVar(&config.RepositoryHost, "repository-host", "ado", "The service hosting the internal module repository, used to open pull requests: ado or github")
+	flag.StringVar(&config.GitHubApiUrl, "github-api-url", "https://api.github.com", "The GitHub REST API URL. For GitHub Enterprise Server use https://<host>/api/v3")
+	flag.StringVar(&config.GitHubOwner, "github-owner", "", "The GitHub owner (organization or user) of the internal module repository")
+	flag.StringVar(&config.GitHubRepo, "github-repo", "", "The GitHub name of the internal module repository")
+	flag.StringVar(&config.GitHubToken, "github-token", "", "The GitHub token used for API calls and, when set, for pushing sync branches")
 	flag.StringVar(&config.AdoOrganization, "ado-organization", "", "The ADO organization")
 	flag.StringVar(&config.AdoProject, "ado-project", "", "The ADO project")
 	flag.StringVar(&config.AdoRepoId, "ado-repo-id", "", "The ADO repository ID")
@@ -85,6 +95,15 @@ func Main() {
 	flag.StringVar(&config.ModuleSyncSourceRepoChildPath, "module-sync-source-repo-child-path", "", "The child path within the source repo where modules are to be copied")
 	flag.BoolVar(&config.UseLocalIdentity, "use-local-identity", false, "Use the local identity")
 	flag.BoolVar(&config.ReadLocalCsvFile, "read-local-csv", false, "Read module CSV files from local disk instead of downloading")
+	flag.StringVar(&config.IndexConfigFile, "index-config", "", "Path to a JSON file listing the module indexes to load, each with a name, url and/or localPath, recordType (resource, pattern, utility, bicep-resource or bicep-pattern) optional nameTemplate and optional origin (avm or private, default avm). Defaults to the AVM Terraform resource, pattern and utility indexes")
+	flag.BoolVar(&config.StrictIndex, "strict-index", false, "Fail the run when module index validation finds errors (missing required columns or fields, duplicate names, malformed repository URLs, module names not matching their index). Without it errors are only logged and reported")
+	flag.StringVar(&config.PrivateIndexFile, "private-index", "", "Path to a CSV or YAML index of private modules to mirror alongside the AVM modules. Uses the resource module columns (ModuleName, ModuleStatus, RepoURL, ...) and may point at any git URL. Names must not collide with AVM modules")
+	flag.StringVar(&config.PrivateIndexNameTemplate, "private-index-name-template", "{{ .ModuleName }}", "Go template for the internal folder name of private modules. Available fields: {{ .ModuleName }}, {{ .ProviderNamespace }}, {{ .ResourceType }}; helpers: trimPrefix, trimSuffix, replace, lower, upper. Can't be combined with --aggregate-pull-requests")
+	flag.StringVar(&config.IndexCacheDir, "index-cache-dir", "./.avm-index-cache", "Directory used to cache downloaded module indexes. The last good copy is used when the remote is unreachable. Empty disables the cache")
+	flag.DurationVar(&config.HttpTimeout, "http-timeout", 30*time.Second, "Timeout for each module index download attempt")
+	flag.IntVar(&config.HttpRetries, "http-retries", 3, "Number of times a failed module index download is retried")
+	flag.DurationVar(&config.HttpRetryDelay, "http-retry-delay", 2*time.Second, "Delay before the first module index download retry, doubled for each subsequent retry")
+	flag.StringVar(&config.HttpProxy, "http-proxy", "", "Proxy URL for module index downloads. Defaults to the HTTPS_PROXY/HTTP_PROXY environment variables")
 	flag.BoolVar(&config.PullRemoteTerraformRepository, "pull-remote-repo", true, "Pull the remote Terraform repository to get existing modules")
 	flag.StringVar(&config.TempAvmModuleRepoPath, "temp-avm-module-repo-path", "./avm_modules", "The temporary path for the AVM module repository")
 	flag.StringVar(&config.SourceRepoPath, "source-repo-path", "", "The path to copy the AVM modules into")
@@ -99,6 +118,42 @@ func Main() {
 	config.ForceUpdateModuleNames = []string{}
 	flag.Var(&stringSliceFlag{target: &config.ForceUpdateModuleNames}, "force-update-modules", "Comma-separated list of AVM module names to force update even if the upstream tag has not advanced since the last sync")
 	flag.StringVar(&config.ArtifactorySourceTemplate, "artifactory-source-template", "", "Go template for the Artifactory module source used to replace public AVM registry references in .tf files (examples folders are skipped). Use {{ .ModuleName }} for the transformed module name, e.g. example.com/some-repo__some-namespace/{{ .ModuleName }}/some-provider")
+	flag.StringVar(&config.ResourceNameTemplate, "resource-name-template", config.DefaultResourceNameTemplate, "Go template for the internal folder name of resource modules. Available fields: {{ .ModuleName }}, {{ .ProviderNamespace }}, {{ .ResourceType }}; helpers: trimPrefix, trimSuffix, replace, lower, upper")
+	flag.StringVar(&config.PatternNameTemplate, "pattern-name-template", config.DefaultPatternNameTemplate, "Go template for the internal folder name of pattern modules. Available fields: {{ .ModuleName }}; helpers: trimPrefix, trimSuffix, replace, lower, upper")
+	flag.StringVar(&config.UtilityNameTemplate, "utility-name-template", config.DefaultUtilityNameTemplate, "Go template for the internal folder name of utility modules. Available fields: {{ .ModuleName }}; helpers: trimPrefix, trimSuffix, replace, lower, upper")
+	flag.StringVar(&config.BicepResourceNameTemplate, "bicep-resource-name-template", config.DefaultBicepResourceNameTemplate, "Go template for the internal folder name of Bicep resource modules. Available fields: {{ .ModuleName }}, {{ .ProviderNamespace }}, {{ .ResourceType }}; helpers: trimPrefix, trimSuffix, replace, lower, upper")
+	flag.StringVar(&config.BicepPatternNameTemplate, "bicep-pattern-name-template", config.DefaultBicepPatternNameTemplate, "Go template for the internal folder name of Bicep pattern modules. Available fields: {{ .ModuleName }}; helpers: trimPrefix, trimSuffix, replace, lower, upper")
+	flag.StringVar(&config.BicepSourceRepoChildPath, "bicep-source-repo-child-path", "", "The child path within the source repo where Bicep modules are to be copied")
+	flag.StringVar(&config.BicepRegistrySourceTemplate, "bicep-registry-source-template", "", "Go template for the private registry reference used to replace public Bicep registry references (br/public:avm/...) in .bicep files (tests folders are skipped). Use {{ .ModuleName }} for the transformed module name; the version is appended, e.g. br:example.azurecr.io/bicep/modules/{{ .ModuleName }}")
+	flag.StringVar(&config.PullRequestDescriptionTemplateFile, "pr-description-template", "", "Path to a Go template file replacing the default sync pull request description. Fields include .ModuleName, .PreviousTag, .LatestTag, .UpstreamLog, .Changelog, .FilesAdded, .FilesChanged, .FilesDeleted, .PatchesApplied, .PatchesFailed and .SourceRewrites; helpers: link, short")
+	flag.StringVar(&config.ReviewerMappingFile, "reviewer-mapping-file", "", "Path to a JSON file of rules assigning pull request reviewers by module name pattern or provider namespace, e.g. [{\"modules\": [\"avm-res-network-*\"], \"providerNamespaces\": [\"Microsoft.Network\"], \"required\": [\"[Platform]\\\\Network Team\"], \"optional\": [\"jane@example.com\"]}]")
+	flag.BoolVar(&config.AutoComplete, "auto-complete", false, "Set auto-complete on sync pull requests for patch and minor version bumps, squash-merging and deleting the source branch. Major bumps are left for a human to complete")
+	flag.StringVar(&config.DefaultBranchName, "target-branch", config.DefaultTargetBranch, "Branch that sync branches are created from and pull requests target, unless --target-branch-mapping-file maps a module elsewhere")
+	flag.StringVar(&config.TargetBranchMappingFile, "target-branch-mapping-file", "", "Path to a JSON file of rules sending the sync pull requests of matching modules to another target branch, e.g. [{\"modules\": [\"avm-res-network-*\"], \"targetBranch\": \"release/network\"}]. The first matching rule wins")
+	flag.StringVar(&config.BranchPrefix, "branch-prefix", config.DefaultBranchPrefix, "Prefix of every branch the sync pushes, available to --sync-branch-template as .BranchPrefix")
+	flag.StringVar(&config.SyncBranchTemplate, "sync-branch-template", config.DefaultSyncBranchTemplate, "Go template for the name of a module's sync branch. Fields: .ModuleName, .UpstreamModuleName, .Origin, .ProviderNamespace, .BranchPrefix, .TargetBranch, .PreviousTag, .LatestTag, .Bump (patch, minor, major) and .Classification; helpers: trimPrefix, trimSuffix, replace, lower, upper")
+	flag.StringVar(&config.CommitMessageTemplate, "commit-message-template", config.DefaultCommitMessageTemplate, "Go template for the commit message of a module sync, with the same fields and helpers as --sync-branch-template plus .CommitType and .Breaking. Can't be combined with --aggregate-pull-requests")
+	flag.BoolVar(&config.SemverCommitTypes, "semver-commit-types", false, "Derive the conventional commit type of sync commits and pull request titles from the upstream version bump, scoped by module name: fix for patch, feat for minor and new modules, feat! with a BREAKING CHANGE footer for major bumps and breaking interface changes. Custom --commit-message-template and --pr-title-template values can use .CommitType and .Breaking instead")
+	flag.StringVar(&config.PullRequestTitleTemplate, "pr-title-template", config.DefaultPullRequestTitleTemplate, "Go template for the title of a module's sync pull request, with the same fields and helpers as --sync-branch-template plus .CommitType and .Breaking")
+	flag.StringVar(&config.CommitSigningFormat, "commit-signing-format", "", "Sign every commit the sync makes with a gpg or ssh key. The key must not be protected by a passphrase")
+	flag.StringVar(&config.CommitSigningKeyFile, "commit-signing-key-file", "", "Path to the GPG secret key (armored or binary) or SSH private key used with --commit-signing-format")
+	flag.StringVar(&config.CommitSigningKeyEnv, "commit-signing-key-env", "AVM_SYNC_SIGNING_KEY", "Name of the environment variable holding the signing key when --commit-signing-key-file isn't set")
+	flag.BoolVar(&config.AggregatePullRequests, "aggregate-pull-requests", false, "Commit all module syncs of the run onto one branch, one commit per module, with a single pull request listing every module instead of one pull request per module")
+	flag.IntVar(&config.AggregateMaxModules, "aggregate-max-modules", config.DefaultAggregateMaxModules, "Maximum number of modules in one aggregate pull request; further modules go to the next one. 0 puts every module in one pull request")
+	flag.StringVar(&config.AutoCompletePolicyFile, "auto-complete-policy-file", "", "Path to a JSON file of auto-complete settings per version bump, replacing the --auto-complete defaults, e.g. {\"patch\": {\"mergeStrategy\": \"squash\", \"deleteSourceBranch\": true, \"transitionWorkItems\": true}}. Merge strategies: squash, rebase, noFastForward; bumps without an entry are not auto-completed")
+	flag.StringVar(&config.ModuleNameMappingFile, "module-name-mapping-file", "", "Path to a JSON file mapping AVM module names to target folder names, e.g. {\"avm-res-keyvault-vault\": \"rvm-res-azurerm-keyvault\"}. Entries take precedence over the name templates")
+	flag.StringVar(&config.PushTransport, "push-transport", "git", "How sync branches are pushed: git, or ado-api to commit through the ADO Pushes API without a git checkout. With ado-api --source-repo-path only needs an export of the default target branch, .gitignore isn't applied and file modes aren't kept")
+	flag.BoolVar(&config.MigrateRenamedModules, "migrate-renamed-modules", false, "Detect internal modules whose upstream AVM module was renamed and open a pull request moving them to the new name")
+	flag.BoolVar(&config.ReconcileSyncBranches, "reconcile-sync-branches", false, "Abandon the sync identity's pull requests and delete its sync branches for modules that are now excluded, no longer have a synced status, were already synced by a merged pull request, or were removed from the index or renamed")
+	flag.BoolVar(&config.TagMergedVersions, "tag-merged-versions", false, "Create an annotated <module>/v<version> tag on the default branch commit that merged each module version, skipping tags that already exist")
+	flag.BoolVar(&config.ProcessLifecycle, "process-lifecycle", false, "Open pull requests marking internal modules whose upstream AVM module has been deprecated or orphaned")
+	config.LifecycleStatuses = []string{"Deprecated", "Orphaned"}
+	flag.Var(&stringSliceFlag{target: &config.LifecycleStatuses}, "lifecycle-statuses", "Comma-separated list of upstream module statuses that trigger the lifecycle phase")
+	config.LifecycleReviewers = []string{}
+	flag.Var(&stringSliceFlag{target: &config.LifecycleReviewers}, "lifecycle-reviewers", "Comma-separated list of reviewers to add on lifecycle pull requests of modules with no reviewer mapping rule: ADO identity IDs or unique names such as email addresses")
+	flag.StringVar(&config.ReportPath, "report-path", "", "Path to write the run report to, as JSON when the path ends in .json and as Markdown otherwise")
+	flag.StringVar(&config.IndexSnapshotPath, "index-snapshot-path", "", "Path of the AVM index snapshot used to report index changes between runs. Relative to the source repo when --index-snapshot-pr is set")
+	flag.BoolVar(&config.IndexSnapshotPullRequest, "index-snapshot-pr", false, "Keep the index snapshot in the source repo and update it through a pull request instead of writing it in place")
 	flag.Parse()
 
 	if config.DebugMode {
@@ -119,7 +174,10 @@ func Main() {
 	logFlags(sugaredLogger)
 	logger.Info("Starting AVM module sync")
 	ctx := context.Background()
-	clients := ado.NewAdoClients(logger, ctx)
+	host, clients, err := repohost.NewFromConfig(ctx, logger)
+	if err != nil {
+		logger.Fatal("Failed to set up repository host", zap.Error(err))
+	}
 	avmmodules.CleanUpTempDirs(logger)
 
 	var repoId uuid.UUID
@@ -133,14 +191,74 @@ func Main() {
 		logger.Fatal("Failed to load modules", zap.Error(err))
 	}
 
+	reviewers, err := avmmodules.LoadReviewerMapping(config.ReviewerMappingFile)
+	if err != nil {
+		logger.Fatal("Failed to load reviewer mapping", zap.Error(err))
+	}
+
+	autoComplete, err := avmmodules.LoadAutoCompletePolicy(config.AutoCompletePolicyFile, config.AutoComplete)
+	if err != nil {
+		logger.Fatal("Failed to load auto-complete policy", zap.Error(err))
+	}
+
+	templates, err := avmmodules.LoadSyncTemplates()
+	if err != nil {
+		logger.Fatal("Failed to parse sync templates", zap.Error(err))
+	}
+
+	targetBranches, err := avmmodules.LoadTargetBranchMapping(config.TargetBranchMappingFile)
+	if err != nil {
+		logger.Fatal("Failed to load target branch mapping", zap.Error(err))
+	}
+
 	processor := avmmodules.ModuleProcessor{
-		Logger:        logger,
-		SugaredLogger: sugaredLogger,
-		Clients:       clients,
-		Context:       ctx,
-		Project:       config.AdoProject,
-		RepoId:        &repoId,
-		Modules:       modules,
+		Logger:         logger,
+		SugaredLogger:  sugaredLogger,
+		Clients:        clients,
+		Context:        ctx,
+		Project:        config.AdoProject,
+		RepoId:         &repoId,
+		Host:           host,
+		Modules:        modules,
+		Reviewers:      reviewers,
+		AutoComplete:   autoComplete,
+		Templates:      templates,
+		TargetBranches: targetBranches,
+		Report:         avmmodules.NewRunReport(),
+	}
+	processor.Pusher, err = avmmodules.ConfigurePushTransport(host, targetBranches)
+	if err != nil {
+		logger.Fatal("Failed to configure push transport", zap.Error(err))
+	}
+	if processor.Pusher == nil {
+		if err := processor.FetchTargetBranches(config.SourceRepoPath); err != nil {
+			logger.Fatal("Failed to fetch target branches", zap.Error(err))
+		}
+	}
+	signing, err := avmmodules.ConfigureCommitSigning(config.SourceRepoPath, logger)
+	if err != nil {
+		logger.Fatal("Failed to configure commit signing", zap.Error(err))
+	}
+	defer signing.Cleanup()
+	processor.Report.SetCommitSigning(signing)
+	processor.Report.SetIndexIssues(modules.Issues)
+
+	if err := processor.DiffIndexSnapshot(); err != nil {
+		logger.Error("error comparing index snapshot:", zap.Error(err))
+	}
+
+	if config.MigrateRenamedModules {
+		sugaredLogger.Infow("Migrating renamed modules")
+		if err := processor.MigrateRenamedModules(); err != nil {
+			logger.Error("error migrating renamed modules:", zap.Error(err))
+		}
+	}
+
+	if config.ProcessLifecycle {
+		sugaredLogger.Infow("Processing module lifecycle")
+		if err := processor.ProcessModuleLifecycle(); err != nil {
+			logger.Error("error processing module lifecycle:", zap.Error(err))
+		}
 	}
 
 	if config.ProcessResourceModules {
@@ -188,5 +306,57 @@ func Main() {
 		}
 	}
 
+	if config.ProcessBicepResourceModules {
+		sugaredLogger.Infow("Processing Bicep resource modules")
+		err := processor.ProcessBicepResourceModules(func(module avmmodules.BicepResourceModulesStruct) {
+			sugaredLogger.Infow(
+				"Processed Bicep resource module",
+				"module", module.ModuleName,
+				"status", module.ModuleStatus,
+				"firstPublishedIn", module.FirstPublishedIn,
+			)
+		})
+		if err != nil {
+			sugaredLogger.Error("error processing Bicep resource modules:", zap.Error(err))
+		}
+	}
+
+	if config.ProcessBicepPatternModules {
+		sugaredLogger.Infow("Processing Bicep pattern modules")
+		err := processor.ProcessBicepPatternModules(func(module avmmodules.BicepPatternModulesStruct) {
+			sugaredLogger.Infow(
+				"Processed Bicep pattern module",
+				"module", module.ModuleName,
+				"status", module.ModuleStatus,
+				"firstPublishedIn", module.FirstPublishedIn,
+			)
+		})
+		if err != nil {
+			sugaredLogger.Error("error processing Bicep pattern modules:", zap.Error(err))
+		}
+	}
+
+	if err := processor.FlushAggregatePullRequest(config.SourceRepoPath); err != nil {
+		logger.Error("error opening aggregate pull request:", zap.Error(err))
+	}
+
+	if err := processor.ReconcileSyncBranches(config.SourceRepoPath); err != nil {
+		logger.Error("error reconciling sync branches:", zap.Error(err))
+	}
+
+	if err := processor.TagMergedVersions(config.SourceRepoPath); err != nil {
+		logger.Error("error tagging merged module versions:", zap.Error(err))
+	}
+
+	if err := processor.UpdateIndexSnapshot(); err != nil {
+		logger.Error("error updating index snapshot:", zap.Error(err))
+	}
+
+	if config.ReportPath != "" {
+		if err := processor.Report.Write(config.ReportPath, logger); err != nil {
+			logger.Error("error writing run report:", zap.Error(err))
+		}
+	}
+
 	logger.Info("AVM module sync complete")
 }
@@ -9,15 +9,17 @@ import (
 	"github.com/microsoft/azure-devops-go-api/azuredevops"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/core"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/git"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/identity"
 	"github.com/theonlyway/avm-module-sync/internal/config"
 	"go.uber.org/zap"
 )
 
 // AdoClients holds the Azure DevOps API clients and authentication token.
 type AdoClients struct {
-	CoreClient core.Client
-	GitClient  git.Client
-	Token      string
+	CoreClient     core.Client
+	GitClient      git.Client
+	IdentityClient identity.Client
+	Token          string
 }
 
 // TokenResponse represents the structure for Azure access token responses.
@@ -68,10 +70,17 @@ func NewAdoClients(logger *zap.Logger, ctx context.Context) *AdoClients {
 		os.Exit(1)
 	}
 
+	identityClient, err := identity.NewClient(ctx, connection)
+	if err != nil {
+		logger.Error("Failed to create identity client", zap.Error(err))
+		os.Exit(1)
+	}
+
 	return &AdoClients{
-		CoreClient: coreClient,
-		GitClient:  gitClient,
-		Token:      token,
+		CoreClient:     coreClient,
+		GitClient:      gitClient,
+		IdentityClient: identityClient,
+		Token:          token,
 	}
 }
 
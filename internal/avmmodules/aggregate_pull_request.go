@@ -0,0 +1,192 @@
+package avmmodules
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+	"go.uber.org/zap"
+)
+
+// aggregateBatch is the aggregate branch being filled with module syncs, one commit per module,
+// in --aggregate-pull-requests mode.
+type aggregateBatch struct {
+	Part         int
+	Branch       string
+	TargetBranch string
+	Modules      []moduleSync
+}
+
+// aggregateDescription is the data available to the aggregate pull request description template.
+type aggregateDescription struct {
+	Part      int
+	Modules   []pullRequestDescription
+	Reviewers []repohost.Reviewer
+}
+
+// checkoutAggregateBranch checks out the aggregate branch of the current batch, starting a new
+// batch fresh from targetBranch when none is open. A batch only holds modules with the same
+// target branch, so the open batch is flushed first when targetBranch differs.
+func (p *ModuleProcessor) checkoutAggregateBranch(localRepoPath string, targetBranch string, moduleName string) error {
+	if p.aggregate != nil {
+		if p.aggregate.TargetBranch == targetBranch {
+			return nil
+		}
+		if err := p.FlushAggregatePullRequest(localRepoPath); err != nil {
+			return err
+		}
+	}
+	part := p.aggregateParts + 1
+	branchName := config.BranchPrefix + config.AggregateBranchName + strconv.Itoa(part)
+	if err := checkoutSyncBranch(localRepoPath, branchName, targetBranch, moduleName, p.Logger); err != nil {
+		return err
+	}
+	p.aggregateParts = part
+	p.aggregate = &aggregateBatch{Part: part, Branch: branchName, TargetBranch: targetBranch}
+	return nil
+}
+
+// discardAggregateChanges resets the aggregate branch to its last module commit after a module
+// failed to sync, so its leftovers aren't swept into the next module's commit.
+func (p *ModuleProcessor) discardAggregateChanges(localRepoPath string, moduleName string) {
+	if p.aggregate == nil {
+		return
+	}
+	if _, err := runGit(localRepoPath, p.Logger, moduleName, "reset", "--hard", "HEAD"); err != nil {
+		return
+	}
+	_, _ = runGit(localRepoPath, p.Logger, moduleName, "clean", "-ffd")
+}
+
+// addToAggregate records a module committed onto the aggregate branch and flushes the batch once
+// it holds config.AggregateMaxModules modules.
+func (p *ModuleProcessor) addToAggregate(localRepoPath string, synced moduleSync) error {
+	p.aggregate.Modules = append(p.aggregate.Modules, synced)
+	p.Logger.Info("Added module to aggregate pull request",
+		zap.String("module", synced.Description.ModuleName),
+		zap.String("branch", p.aggregate.Branch),
+		zap.Int("modules", len(p.aggregate.Modules)))
+	if config.AggregateMaxModules > 0 && len(p.aggregate.Modules) >= config.AggregateMaxModules {
+		return p.FlushAggregatePullRequest(localRepoPath)
+	}
+	return nil
+}
+
+// FlushAggregatePullRequest pushes the open aggregate branch and creates or updates its pull
+// request, listing every module with its tag change and links. Labels and reviewers are the
+// union of the modules', the pull request is a draft when any module's would be, and
+// auto-complete is only set when the policy covers every module. Does nothing outside
+// --aggregate-pull-requests mode or when no module was committed since the last flush.
+func (p *ModuleProcessor) FlushAggregatePullRequest(localRepoPath string) error {
+	batch := p.aggregate
+	p.aggregate = nil
+	if batch == nil || len(batch.Modules) == 0 {
+		return nil
+	}
+	logger := p.Logger
+
+	if err := pushBranch(localRepoPath, batch.Branch, batch.Branch, logger); err != nil {
+		return err
+	}
+	data := aggregateDescription{Part: batch.Part}
+	var labels []string
+	var reviewerLists [][]repohost.Reviewer
+	draft := false
+	for _, synced := range batch.Modules {
+		data.Modules = append(data.Modules, synced.Description)
+		reviewerLists = append(reviewerLists, synced.Description.Reviewers)
+		draft = draft || synced.Draft
+		for _, label := range synced.Labels {
+			if !slices.Contains(labels, label) {
+				labels = append(labels, label)
+			}
+		}
+	}
+	data.Reviewers = mergeReviewers(reviewerLists...)
+
+	description, err := executeDescriptionTemplate(config.AggregatePullRequestDescriptionTemplate, data)
+	if err != nil {
+		logger.Error("Failed to render aggregate pull request description", zap.String("branch", batch.Branch), zap.Error(err))
+		description = "This is an automated pull request to sync " + strconv.Itoa(len(batch.Modules)) + " modules, one commit per module."
+	}
+	pr, err := createOrUpdatePullRequest(p.Host, p.Context, repohost.PullRequestOptions{
+		SourceBranch: batch.Branch,
+		TargetBranch: batch.TargetBranch,
+		Title:        buildAggregatePullRequestTitle(batch.Modules, batch.Part),
+		Description:  strings.TrimSpace(description),
+		Labels:       labels,
+		Reviewers:    data.Reviewers,
+		Draft:        draft,
+	}, "", batch.Branch, logger)
+	if err != nil || pr == nil {
+		return err
+	}
+	logger.Info("Opened aggregate pull request", zap.String("branch", batch.Branch), zap.Int("prId", pr.ID), zap.Int("modules", len(batch.Modules)))
+	p.applyAggregateAutoComplete(pr.ID, batch)
+	return nil
+}
+
+// buildAggregatePullRequestTitle returns the title of an aggregate pull request, numbering the
+// parts after the first when the run was split by --aggregate-max-modules. The conventional
+// commit type is the most significant of its modules', so a squash merge still carries the
+// --semver-commit-types signal.
+func buildAggregatePullRequestTitle(modules []moduleSync, part int) string {
+	commitType, breaking := CommitTypeChore, false
+	for _, synced := range modules {
+		if commitTypeRank(synced.Name.CommitType) > commitTypeRank(commitType) {
+			commitType = synced.Name.CommitType
+		}
+		breaking = breaking || config.SemverCommitTypes && synced.Name.Breaking
+	}
+	title := commitType + "(module)"
+	if breaking {
+		title += "!"
+	}
+	title += ": Synced " + strconv.Itoa(len(modules)) + " AVM modules"
+	if part > 1 {
+		title += " (part " + strconv.Itoa(part) + ")"
+	}
+	return title
+}
+
+// applyAggregateAutoComplete sets auto-complete on an aggregate pull request with the policy of
+// its largest version bump, provided the policy covers every module's classification and no
+// module has failed patches or breaking interface changes. Otherwise auto-complete is cancelled
+// in case an earlier run set it on the same branch.
+func (p *ModuleProcessor) applyAggregateAutoComplete(prID int, batch *aggregateBatch) {
+	if len(p.AutoComplete) == 0 {
+		return
+	}
+	bump := ""
+	for _, synced := range batch.Modules {
+		d := synced.Description
+		if _, ok := p.AutoComplete[d.Classification]; !ok {
+			p.Logger.Info("Not setting auto-complete because the policy doesn't cover a module's sync type",
+				zap.String("branch", batch.Branch), zap.Int("prId", prID), zap.String("module", d.ModuleName), zap.String("classification", d.Classification))
+			p.cancelAutoComplete(prID, batch.Branch)
+			return
+		}
+		if reason := autoCompleteBlocker(d); reason != "" {
+			p.Logger.Info("Not setting auto-complete because "+reason,
+				zap.String("branch", batch.Branch), zap.Int("prId", prID), zap.String("module", d.ModuleName))
+			p.cancelAutoComplete(prID, batch.Branch)
+			return
+		}
+		if bumpRank(d.Classification) > bumpRank(bump) {
+			bump = d.Classification
+		}
+	}
+	p.setAutoComplete(prID, batch.Branch, bump, p.AutoComplete[bump])
+}
+
+// commitTypeRank orders conventional commit types by their effect on the released version.
+func commitTypeRank(commitType string) int {
+	return slices.Index([]string{CommitTypeChore, CommitTypeFix, CommitTypeFeat}, commitType)
+}
+
+// bumpRank orders version bumps from smallest to largest.
+func bumpRank(bump string) int {
+	return slices.Index([]string{BumpPatch, BumpMinor, BumpMajor}, bump)
+}
@@ -0,0 +1,300 @@
+package avmmodules
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+	"go.uber.org/zap"
+)
+
+// Push transports accepted by --push-transport.
+const (
+	PushTransportGit    = "git"
+	PushTransportAdoApi = "ado-api"
+)
+
+// apiPush is a module sync pushed through the host's push API rather than the git CLI. Blobs
+// holds the module folder's files on the commit the push builds on, so the exported module tree
+// can be compared against it. Resync is set when that commit is the head of an existing sync
+// branch rather than of the target branch.
+type apiPush struct {
+	Branch string
+	Base   string
+	Resync bool
+	Blobs  map[string]string
+	Commit repohost.TreeCommit
+}
+
+// ConfigurePushTransport returns the pusher sync branches are pushed with in --push-transport
+// ado-api mode, or nil for the default git transport. The API transport builds commits from the
+// files in SourceRepoPath, so the options that need a git checkout of it are rejected.
+func ConfigurePushTransport(host repohost.RepositoryHost, targetBranches *TargetBranchMapping) (repohost.TreePusher, error) {
+	switch config.PushTransport {
+	case "", PushTransportGit:
+		return nil, nil
+	case PushTransportAdoApi:
+	default:
+		return nil, fmt.Errorf("unknown push transport %q, expected %s or %s", config.PushTransport, PushTransportGit, PushTransportAdoApi)
+	}
+	pusher, ok := host.(repohost.TreePusher)
+	if !ok {
+		return nil, fmt.Errorf("push transport %s needs the ado repository host", PushTransportAdoApi)
+	}
+	var unsupported []string
+	if config.CommitSigningFormat != "" {
+		unsupported = append(unsupported, "--commit-signing-format")
+	}
+	if config.AggregatePullRequests {
+		unsupported = append(unsupported, "--aggregate-pull-requests")
+	}
+	if len(targetBranches.Branches()) > 0 {
+		unsupported = append(unsupported, "--target-branch-mapping-file")
+	}
+	if config.MigrateRenamedModules {
+		unsupported = append(unsupported, "--migrate-renamed-modules")
+	}
+	if config.ProcessLifecycle {
+		unsupported = append(unsupported, "--process-lifecycle")
+	}
+	if config.IndexSnapshotPullRequest {
+		unsupported = append(unsupported, "--index-snapshot-pr")
+	}
+	if config.ReconcileSyncBranches {
+		unsupported = append(unsupported, "--reconcile-sync-branches")
+	}
+	if config.TagMergedVersions {
+		unsupported = append(unsupported, "--tag-merged-versions")
+	}
+	if len(unsupported) > 0 {
+		return nil, fmt.Errorf("push transport %s can't be combined with %v", PushTransportAdoApi, unsupported)
+	}
+	return pusher, nil
+}
+
+// prepareApiPush resolves the commit a module's sync branch will be pushed on top of and lists
+// the module folder's files on it. An existing sync branch is built on, like a re-sync, so its
+// pull request is updated with a new commit; otherwise the branch is created from targetBranch.
+func (p *ModuleProcessor) prepareApiPush(localRepoPath string, branchName string, targetBranch string, moduleDir string, moduleName string) (*apiPush, error) {
+	logger := p.Logger
+	base, err := p.Pusher.BranchHead(p.Context, branchName)
+	if err != nil {
+		logger.Error("Failed to read sync branch", zap.String("module", moduleName), zap.String("branch", branchName), zap.Error(err))
+		return nil, err
+	}
+	resync := base != ""
+	if !resync {
+		if base, err = p.Pusher.BranchHead(p.Context, targetBranch); err != nil {
+			logger.Error("Failed to read target branch", zap.String("module", moduleName), zap.String("branch", targetBranch), zap.Error(err))
+			return nil, err
+		}
+		if base == "" {
+			return nil, fmt.Errorf("target branch %s does not exist", targetBranch)
+		}
+	}
+	path, err := repositoryPath(localRepoPath, moduleDir)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := p.Pusher.ListBlobs(p.Context, base, path)
+	if err != nil {
+		logger.Error("Failed to list module files", zap.String("module", moduleName), zap.String("path", path), zap.Error(err))
+		return nil, err
+	}
+	logger.Info("Prepared push", zap.String("module", moduleName), zap.String("branch", branchName), zap.String("base", base), zap.Int("files", len(blobs)))
+	return &apiPush{
+		Branch: branchName,
+		Base:   base,
+		Resync: resync,
+		Blobs:  blobs,
+		Commit: repohost.TreeCommit{AuthorName: config.ModuleSyncAuthorName, AuthorEmail: config.ModuleSyncAuthorEmail},
+	}, nil
+}
+
+// pushViaApi pushes the commit of a module sync, creating or moving its branch in one call.
+func (p *ModuleProcessor) pushViaApi(push *apiPush, moduleName string) error {
+	logger := p.Logger
+	logger.Info("Pushing branch via API", zap.String("module", moduleName), zap.String("branch", push.Branch), zap.Int("changes", len(push.Commit.Changes)))
+	commit, err := p.Pusher.PushCommits(p.Context, push.Branch, push.Base, []repohost.TreeCommit{push.Commit})
+	if err != nil {
+		logger.Error("Failed to push branch via API", zap.String("module", moduleName), zap.String("branch", push.Branch), zap.Error(err))
+		return err
+	}
+	logger.Info("Pushed branch via API", zap.String("module", moduleName), zap.String("branch", push.Branch), zap.String("commit", commit))
+	return nil
+}
+
+// apiPreviousPush returns the state of an existing sync branch a push builds on. The tag it
+// synced is only known when the push leaves the module's .avm-version file as it was.
+func apiPreviousPush(localRepoPath string, push *apiPush, moduleDir string, latestAvmTag string) *previousPush {
+	previous := &previousPush{Commit: push.Base, Changes: push.Commit.Changes}
+	versionPath, err := repositoryPath(localRepoPath, filepath.Join(moduleDir, config.AvmVersionFileName))
+	if err != nil {
+		return previous
+	}
+	if _, exists := push.Blobs[versionPath]; !exists {
+		return previous
+	}
+	for _, change := range push.Commit.Changes {
+		if change.Path == versionPath {
+			return previous
+		}
+	}
+	previous.Tag = latestAvmTag
+	return previous
+}
+
+// readAvmVersionViaApi reads a module's version file on targetBranch through the push API.
+func (p *ModuleProcessor) readAvmVersionViaApi(childPath string, moduleName string, targetBranch string) (tag string, commit string, backfill bool) {
+	logger := p.Logger
+	path, err := repositoryPath(config.SourceRepoPath, moduleVersionFilePath(childPath, moduleName))
+	if err != nil {
+		logger.Warn("Could not read AVM version file", zap.String("module", moduleName), zap.Error(err))
+		return "", "", false
+	}
+	head, err := p.Pusher.BranchHead(p.Context, targetBranch)
+	if err != nil {
+		logger.Warn("Could not read target branch for AVM version file", zap.String("module", moduleName), zap.String("branch", targetBranch), zap.Error(err))
+		return "", "", false
+	}
+	if head == "" {
+		return "", "", false
+	}
+	data, err := p.Pusher.ReadFile(p.Context, head, path)
+	if err != nil {
+		logger.Warn("Could not read AVM version file", zap.String("module", moduleName), zap.String("path", path), zap.Error(err))
+		return "", "", false
+	}
+	if data == nil {
+		return "", "", false
+	}
+	return parseAvmVersionFile(moduleName, data, logger)
+}
+
+// apiModuleInterface parses the variable and output blocks of the .tf files at the root of the
+// module folder on the commit a push builds on, read through the push API. Returns nil when the
+// folder has no .tf files there.
+func (p *ModuleProcessor) apiModuleInterface(localRepoPath string, push *apiPush, moduleDir string, moduleName string) *moduleInterface {
+	logger := p.Logger
+	dir, err := repositoryPath(localRepoPath, moduleDir)
+	if err != nil {
+		return nil
+	}
+	found := false
+	sources := map[string][]byte{}
+	for path := range push.Blobs {
+		name, ok := strings.CutPrefix(path, dir+"/")
+		if !ok || strings.Contains(name, "/") || !strings.HasSuffix(name, ".tf") {
+			continue
+		}
+		found = true
+		src, err := p.Pusher.ReadFile(p.Context, push.Base, path)
+		if err != nil || src == nil {
+			logger.Warn("Failed to read .tf file for interface diff", zap.String("module", moduleName), zap.String("file", path), zap.Error(err))
+			continue
+		}
+		sources[path] = src
+	}
+	if !found {
+		return nil
+	}
+	return parseModuleInterface(sources, moduleName, logger)
+}
+
+// repositoryPath returns the repository path, with a leading slash, of a path in the local
+// repository.
+func repositoryPath(localRepoPath string, path string) (string, error) {
+	rel, err := filepath.Rel(localRepoPath, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || filepath.IsAbs(rel) || len(rel) > 2 && rel[:3] == ".."+string(filepath.Separator) {
+		return "", fmt.Errorf("%s is outside the source repository %s", path, localRepoPath)
+	}
+	return "/" + filepath.ToSlash(rel), nil
+}
+
+// moduleTreeChanges returns the changes that make the files under moduleDir on the branch, given
+// by their blob IDs, match the module folder on disk. Files whose content is unchanged are left
+// out; files no longer on disk are deleted. .gitignore isn't applied, so the folder must only
+// hold files meant to be committed.
+func moduleTreeChanges(localRepoPath string, moduleDir string, blobs map[string]string) ([]repohost.FileChange, error) {
+	var changes []repohost.FileChange
+	seen := map[string]bool{}
+	err := filepath.WalkDir(moduleDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == moduleDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		repoPath, err := repositoryPath(localRepoPath, path)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		seen[repoPath] = true
+		blob, exists := blobs[repoPath]
+		switch {
+		case !exists:
+			changes = append(changes, repohost.FileChange{Path: repoPath, ChangeType: repohost.ChangeAdd, Content: content})
+		case blob != gitBlobID(content):
+			changes = append(changes, repohost.FileChange{Path: repoPath, ChangeType: repohost.ChangeEdit, Content: content})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for repoPath := range blobs {
+		if !seen[repoPath] {
+			changes = append(changes, repohost.FileChange{Path: repoPath, ChangeType: repohost.ChangeDelete})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// gitBlobID returns the object ID git gives a file with content.
+func gitBlobID(content []byte) string {
+	h := sha1.New()
+	h.Write([]byte("blob " + strconv.Itoa(len(content)) + "\x00"))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// changeCounts returns the number of files added, changed and deleted by changes.
+func changeCounts(changes []repohost.FileChange) (added int, changed int, deleted int) {
+	for _, change := range changes {
+		switch change.ChangeType {
+		case repohost.ChangeAdd:
+			added++
+		case repohost.ChangeEdit:
+			changed++
+		case repohost.ChangeDelete:
+			deleted++
+		}
+	}
+	return added, changed, deleted
+}
@@ -0,0 +1,161 @@
+package avmmodules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+	"go.uber.org/zap"
+	"golang.org/x/mod/semver"
+)
+
+// Version bumps of a sync, from the previously synced tag to the latest upstream tag.
+const (
+	BumpPatch = "patch"
+	BumpMinor = "minor"
+	BumpMajor = "major"
+)
+
+// mergeStrategies are the merge strategies accepted in the auto-complete policy.
+var mergeStrategies = []string{repohost.MergeSquash, repohost.MergeRebase, repohost.MergeNoFastForward}
+
+// semverBump returns the version bump from previousTag to latestTag, or "" when either tag is
+// not a semantic version or the version didn't advance.
+func semverBump(previousTag string, latestTag string) string {
+	previous := ensureSemverPrefix(previousTag)
+	latest := ensureSemverPrefix(latestTag)
+	if !semver.IsValid(previous) || !semver.IsValid(latest) || semver.Compare(latest, previous) <= 0 {
+		return ""
+	}
+	switch {
+	case semver.Major(latest) != semver.Major(previous):
+		return BumpMajor
+	case semver.MajorMinor(latest) != semver.MajorMinor(previous):
+		return BumpMinor
+	default:
+		return BumpPatch
+	}
+}
+
+// autoCompleteRule is the entry of the auto-complete policy file for one version bump.
+type autoCompleteRule struct {
+	MergeStrategy       string `json:"mergeStrategy,omitempty"`
+	DeleteSourceBranch  *bool  `json:"deleteSourceBranch,omitempty"`
+	TransitionWorkItems bool   `json:"transitionWorkItems,omitempty"`
+}
+
+// AutoCompletePolicy maps a version bump to the completion settings of sync pull requests with
+// that bump. Pull requests for bumps without an entry are left for a human to complete.
+type AutoCompletePolicy map[string]repohost.AutoCompleteOptions
+
+// defaultAutoCompletePolicy is used by --auto-complete without a policy file: patch and minor
+// bumps are squash-merged and their branches deleted, major bumps are left alone.
+func defaultAutoCompletePolicy() AutoCompletePolicy {
+	options := repohost.AutoCompleteOptions{MergeStrategy: repohost.MergeSquash, DeleteSourceBranch: true}
+	return AutoCompletePolicy{BumpPatch: options, BumpMinor: options}
+}
+
+// LoadAutoCompletePolicy reads the auto-complete policy file, a JSON object keyed by bump, e.g.
+// {"patch": {"mergeStrategy": "squash", "deleteSourceBranch": true, "transitionWorkItems": true}}.
+// mergeStrategy defaults to squash and deleteSourceBranch to true. Without a file, enabled
+// selects the default policy and otherwise auto-complete is off.
+func LoadAutoCompletePolicy(path string, enabled bool) (AutoCompletePolicy, error) {
+	if path == "" {
+		if enabled {
+			return defaultAutoCompletePolicy(), nil
+		}
+		return AutoCompletePolicy{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading auto-complete policy file: %w", err)
+	}
+	var rules map[string]autoCompleteRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing auto-complete policy file %s: %w", path, err)
+	}
+	policy := AutoCompletePolicy{}
+	for bump, rule := range rules {
+		if !slices.Contains([]string{BumpPatch, BumpMinor, BumpMajor}, bump) {
+			return nil, fmt.Errorf("auto-complete policy has unknown bump %q, expected %s, %s or %s", bump, BumpPatch, BumpMinor, BumpMajor)
+		}
+		options := repohost.AutoCompleteOptions{MergeStrategy: rule.MergeStrategy, DeleteSourceBranch: true, TransitionWorkItems: rule.TransitionWorkItems}
+		if options.MergeStrategy == "" {
+			options.MergeStrategy = repohost.MergeSquash
+		}
+		if !slices.Contains(mergeStrategies, options.MergeStrategy) {
+			return nil, fmt.Errorf("auto-complete policy for %s bumps has unknown merge strategy %q, expected one of %v", bump, options.MergeStrategy, mergeStrategies)
+		}
+		if rule.DeleteSourceBranch != nil {
+			options.DeleteSourceBranch = *rule.DeleteSourceBranch
+		}
+		policy[bump] = options
+	}
+	return policy, nil
+}
+
+// applyAutoComplete sets auto-complete on a sync pull request when the policy covers its
+// classification, which is only the case for version bumps. Pull requests with failed patches
+// or breaking interface changes always wait for a human. Otherwise auto-complete is cancelled,
+// since the pull request may have had it set by an earlier sync of the same branch. Nothing is
+// changed when auto-complete is off.
+func (p *ModuleProcessor) applyAutoComplete(prID int, classification string, description pullRequestDescription) {
+	if len(p.AutoComplete) == 0 {
+		return
+	}
+	moduleName := description.ModuleName
+	options, ok := p.AutoComplete[classification]
+	if !ok {
+		p.Logger.Info("Not setting auto-complete because the policy doesn't cover the sync type",
+			zap.String("module", moduleName), zap.Int("prId", prID), zap.String("classification", classification))
+		p.cancelAutoComplete(prID, moduleName)
+		return
+	}
+	if reason := autoCompleteBlocker(description); reason != "" {
+		p.Logger.Info("Not setting auto-complete because "+reason, zap.String("module", moduleName), zap.Int("prId", prID))
+		p.cancelAutoComplete(prID, moduleName)
+		return
+	}
+	p.setAutoComplete(prID, moduleName, classification, options)
+}
+
+// autoCompleteBlocker returns why a sync must wait for a human regardless of the policy, or ""
+// when nothing blocks auto-complete.
+func autoCompleteBlocker(description pullRequestDescription) string {
+	switch {
+	case len(description.PatchesFailed) > 0:
+		return "patches failed to apply"
+	case description.InterfaceDiff != nil && description.InterfaceDiff.IsBreaking():
+		return "the module interface has breaking changes"
+	}
+	return ""
+}
+
+// setAutoComplete sets auto-complete on a pull request, logged under logName.
+func (p *ModuleProcessor) setAutoComplete(prID int, logName string, bump string, options repohost.AutoCompleteOptions) {
+	if err := p.Host.SetAutoComplete(p.Context, prID, options); err != nil {
+		if errors.Is(err, repohost.ErrNotSupported) {
+			p.Logger.Warn("Repository host does not support auto-complete", zap.String("module", logName), zap.Int("prId", prID))
+			return
+		}
+		p.Logger.Warn("Failed to set auto-complete on pull request", zap.String("module", logName), zap.Int("prId", prID), zap.Error(err))
+		return
+	}
+	p.Logger.Info("Set auto-complete on pull request",
+		zap.String("module", logName),
+		zap.Int("prId", prID),
+		zap.String("bump", bump),
+		zap.String("mergeStrategy", options.MergeStrategy),
+		zap.Bool("deleteSourceBranch", options.DeleteSourceBranch),
+		zap.Bool("transitionWorkItems", options.TransitionWorkItems))
+}
+
+// cancelAutoComplete clears auto-complete from a pull request, logged under logName.
+func (p *ModuleProcessor) cancelAutoComplete(prID int, logName string) {
+	if err := p.Host.CancelAutoComplete(p.Context, prID); err != nil {
+		p.Logger.Warn("Failed to cancel auto-complete on pull request", zap.String("module", logName), zap.Int("prId", prID), zap.Error(err))
+	}
+}
@@ -0,0 +1,165 @@
+package avmmodules
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	cp "github.com/otiai10/copy"
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"go.uber.org/zap"
+)
+
+// bicepRegistryRepoFolderName is the folder inside the temporary AVM path that holds the single
+// clone of the bicep-registry-modules monorepo shared by every Bicep module.
+const bicepRegistryRepoFolderName = ".bicep-registry-modules"
+
+// avmBicepRegistrySourceRe matches references to the public Bicep registry, either through the
+// br/public alias or the full MCR path, e.g. br/public:avm/res/storage/storage-account:0.9.1 or
+// br:mcr.microsoft.com/bicep/avm/res/storage/storage-account:0.9.1. The version is captured so it
+// can be carried over to the rewritten reference.
+var avmBicepRegistrySourceRe = regexp.MustCompile(`(?:br/public:|br:mcr\.microsoft\.com/bicep/)(avm/(?:res|ptn|utl)/[a-z0-9/-]+):([0-9A-Za-z.+-]+)`)
+
+// CloneBicepModules copies Bicep modules out of the bicep-registry-modules monorepo into destDir,
+// one folder per module named by nameTransformer. The monorepo is cloned once and each module is
+// checked out at its own latest tag ("<module path>/<version>") in turn, so modules are processed
+// sequentially. An existing clone has its tags fetched first, and modules without a tag are
+// skipped rather than copied from whatever commit the monorepo is at. The version recorded for
+// a module is the tag without the module path prefix.
+func CloneBicepModules[T Module](modules []T, destDir string, logger *zap.Logger, processor *ModuleProcessor, nameTransformer ModuleNameTransformer) {
+	repoPath := filepath.Join(destDir, bicepRegistryRepoFolderName)
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil {
+		logger.Info("Reusing existing bicep-registry-modules clone", zap.String("path", repoPath))
+		if _, err := runGit(repoPath, logger, "", "fetch", "--quiet", "--tags", "--force", "origin"); err != nil {
+			logger.Warn("Failed to fetch bicep-registry-modules, module tags may be out of date", zap.String("path", repoPath), zap.Error(err))
+		}
+	} else {
+		os.RemoveAll(repoPath)
+		logger.Info("Cloning bicep-registry-modules", zap.String("repoURL", config.BicepRegistryRepoUrl), zap.String("path", repoPath))
+		if err := CloneRepo(config.BicepRegistryRepoUrl, repoPath); err != nil {
+			logger.Error("Failed to clone bicep-registry-modules", zap.String("repoURL", config.BicepRegistryRepoUrl), zap.Error(err))
+			return
+		}
+	}
+
+	for _, module := range modules {
+		newModuleName := nameTransformer(module.GetModuleName())
+		newPath := filepath.Join(destDir, newModuleName)
+		logger.Info("Transformed module name", zap.String("module", newModuleName), zap.String("old", module.GetModuleName()), zap.String("new", newModuleName))
+
+		tagPrefix := module.GetModuleName() + "/"
+		storedTag, storedCommit, backfill := processor.readAvmVersion(module.GetSourceRepoChildPath(), newModuleName, processor.TargetBranches.TargetBranchFor(module))
+		var latestTag, latestCommit string
+		if backfill && storedTag != "" {
+			logger.Info("Backfill mode: checking out stored tag",
+				zap.String("module", newModuleName),
+				zap.String("tag", tagPrefix+storedTag))
+			latestTag = storedTag
+			latestCommit = findTagCommit(repoPath, tagPrefix+storedTag, newModuleName, logger)
+		} else {
+			latestTag, latestCommit = findLatestTagWithPrefix(repoPath, tagPrefix, logger)
+			processor.UpstreamLogMap.Store(newModuleName, upstreamLog(repoPath, storedCommit, latestCommit, module.GetModuleName(), newModuleName, logger))
+		}
+		if latestCommit == "" {
+			logger.Warn("No tag found for Bicep module, skipping it", zap.String("module", newModuleName), zap.String("tagPrefix", tagPrefix))
+			continue
+		}
+		checkoutCommit(repoPath, latestCommit, newModuleName, logger)
+
+		modulePath := filepath.Join(repoPath, filepath.FromSlash(module.GetModuleName()))
+		if _, err := os.Stat(modulePath); err != nil {
+			logger.Error("Module folder not found in bicep-registry-modules", zap.String("module", newModuleName), zap.String("path", modulePath), zap.Error(err))
+			continue
+		}
+		if _, err := os.Stat(newPath); err == nil {
+			logger.Warn("New path already exists from a previous run, removing", zap.String("module", newModuleName), zap.String("path", newPath))
+			os.RemoveAll(newPath)
+		}
+		logger.Info("Copying Bicep module out of monorepo", zap.String("module", newModuleName), zap.String("from", modulePath), zap.String("to", newPath))
+		if err := cp.Copy(modulePath, newPath); err != nil {
+			logger.Error("Error copying Bicep module", zap.String("module", newModuleName), zap.String("from", modulePath), zap.String("to", newPath), zap.Error(err))
+			continue
+		}
+		processor.LatestAvmTagMap.Store(newModuleName, latestTag)
+		processor.LatestAvmCommitMap.Store(newModuleName, latestCommit)
+	}
+}
+
+// rewriteBicepRegistrySources rewrites references to the public Bicep registry in a module's
+// .bicep files so they point at the registry configured by config.BicepRegistrySourceTemplate.
+// The template receives the transformed module name as {{ .ModuleName }} and the original
+// version is appended after a colon. Tests folders are skipped so test deployments keep
+// using the public registry. When no template is configured the function is a no-op. Returns
+// the rewrites made, with files relative to moduleDir.
+func rewriteBicepRegistrySources(moduleName string, moduleDir string, names *ModuleNameIndex, logger *zap.Logger) ([]sourceRewrite, error) {
+	if config.BicepRegistrySourceTemplate == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("bicep-registry-source").Parse(config.BicepRegistrySourceTemplate)
+	if err != nil {
+		logger.Error("Failed to parse Bicep registry source template", zap.String("template", config.BicepRegistrySourceTemplate), zap.Error(err))
+		return nil, err
+	}
+
+	logger.Info("Rewriting public Bicep registry references", zap.String("module", moduleName), zap.String("moduleDir", moduleDir))
+
+	var rewrites []sourceRewrite
+	err = filepath.Walk(moduleDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == config.BicepTestsFolderName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".bicep" {
+			return nil
+		}
+		fileRewrites, err := rewriteBicepFileSources(path, tmpl, names, logger)
+		rewrites = append(rewrites, relativeRewrites(moduleDir, fileRewrites)...)
+		return err
+	})
+	return rewrites, err
+}
+
+// rewriteBicepFileSources rewrites public Bicep registry references in a single .bicep file,
+// writing the file back only when a change is made.
+func rewriteBicepFileSources(path string, tmpl *template.Template, names *ModuleNameIndex, logger *zap.Logger) ([]sourceRewrite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Error("Failed to read .bicep file for source rewrite", zap.String("file", path), zap.Error(err))
+		return nil, err
+	}
+
+	var rewrites []sourceRewrite
+	out := avmBicepRegistrySourceRe.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := avmBicepRegistrySourceRe.FindSubmatch(match)
+		avmName := string(groups[1])
+		version := string(groups[2])
+
+		var sb strings.Builder
+		if execErr := tmpl.Execute(&sb, struct{ ModuleName string }{ModuleName: names.Transform(avmName)}); execErr != nil {
+			logger.Error("Failed to render Bicep registry source template", zap.String("file", path), zap.String("module", avmName), zap.Error(execErr))
+			return match
+		}
+
+		rewrites = append(rewrites, sourceRewrite{File: path, From: avmName, To: sb.String()})
+		logger.Info("Rewriting Bicep registry reference", zap.String("file", path), zap.String("from", avmName), zap.String("to", sb.String()))
+		return []byte(sb.String() + ":" + version)
+	})
+
+	if len(rewrites) == 0 {
+		return nil, nil
+	}
+
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		logger.Error("Failed to write rewritten .bicep file", zap.String("file", path), zap.Error(err))
+		return nil, err
+	}
+	return rewrites, nil
+}
@@ -10,38 +10,50 @@ import (
 	"go.uber.org/zap"
 )
 
-// GetModules loads and returns all module types (resource, pattern, and utility) from their respective sources.
+// GetModules loads and returns all modules from every index in the configured index registry.
 func GetModules(logger *zap.Logger) (*ModulesStruct, error) {
 	logger.Info("Starting to load all module types")
-	resourceModules, err := getResourceModules(logger)
+	definitions, err := LoadIndexDefinitions(config.IndexConfigFile)
 	if err != nil {
-		logger.Error("Failed to get resource modules", zap.Error(err))
+		logger.Error("Failed to load index definitions", zap.Error(err))
 		return nil, err
 	}
 
-	patternModules, err := getPatternModules(logger)
-	if err != nil {
-		logger.Error("Failed to get pattern modules", zap.Error(err))
+	modules := &ModulesStruct{}
+	for _, def := range definitions {
+		if err := recordLoaders[def.RecordType](modules, def, logger); err != nil {
+			logger.Error("Failed to get modules from index", zap.String("index", def.Name), zap.Error(err))
+			return nil, err
+		}
+	}
+
+	if err := checkIndexIssues(modules, definitions, logger); err != nil {
+		logger.Error("Module index failed strict validation", zap.Error(err))
 		return nil, err
 	}
 
-	utilityModules, err := getUtilityModules(logger)
+	if err := checkOriginCollisions(modules); err != nil {
+		logger.Error("Private module index collides with the AVM index", zap.Error(err))
+		return nil, err
+	}
+
+	names, err := NewModuleNameIndex(modules, definitions)
 	if err != nil {
-		logger.Error("Failed to get utility modules", zap.Error(err))
+		logger.Error("Failed to build module name index", zap.Error(err))
 		return nil, err
 	}
+	modules.Names = names
 
 	logger.Info("Successfully loaded all module types",
-		zap.Int("resource_modules", len(resourceModules)),
-		zap.Int("pattern_modules", len(patternModules)),
-		zap.Int("utility_modules", len(utilityModules)),
-		zap.Int("total_modules", len(resourceModules)+len(patternModules)+len(utilityModules)))
-
-	return &ModulesStruct{
-		ResourceModules: resourceModules,
-		PatternModules:  patternModules,
-		UtilityModules:  utilityModules,
-	}, nil
+		zap.Int("indexes", len(definitions)),
+		zap.Int("resource_modules", len(modules.ResourceModules)),
+		zap.Int("pattern_modules", len(modules.PatternModules)),
+		zap.Int("utility_modules", len(modules.UtilityModules)),
+		zap.Int("bicep_resource_modules", len(modules.BicepResourceModules)),
+		zap.Int("bicep_pattern_modules", len(modules.BicepPatternModules)),
+		zap.Int("total_modules", len(modules.allModules())))
+
+	return modules, nil
 }
 
 // batchSlice divides a slice into smaller batches of the specified size.
@@ -122,7 +134,7 @@ func CloneModulesInBatches[T Module](modules []T, destDir string, logger *zap.Lo
 				} else if os.IsNotExist(err) {
 					// Check before cloning whether this module is flagged for backfill so we
 					// can target the stored tag instead of the latest upstream tag.
-					storedTag, _, backfill := readAvmVersionFile(newModuleName, logger)
+					storedTag, storedCommit, backfill := processor.readAvmVersion(module.GetSourceRepoChildPath(), newModuleName, processor.TargetBranches.TargetBranchFor(module))
 					CloneRepo(module.GetRepoURL(), tempPath)
 					var latestTag, latestCommit string
 					if backfill && storedTag != "" {
@@ -142,6 +154,9 @@ func CloneModulesInBatches[T Module](modules []T, destDir string, logger *zap.Lo
 					checkoutCommit(tempPath, latestCommit, newModuleName, logger)
 					processor.LatestAvmTagMap.Store(newModuleName, latestTag)
 					processor.LatestAvmCommitMap.Store(newModuleName, latestCommit)
+					if !backfill {
+						processor.UpstreamLogMap.Store(newModuleName, upstreamLog(tempPath, storedCommit, latestCommit, "", newModuleName, logger))
+					}
 					removeGitFolder(processor, tempPath, newModuleName)
 					renameFolders(processor, tempPath, newPath, newModuleName)
 				} else {
@@ -0,0 +1,205 @@
+package avmmodules
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"go.uber.org/zap"
+)
+
+// Commit signing formats accepted by --commit-signing-format.
+const (
+	SigningFormatGPG = "gpg"
+	SigningFormatSSH = "ssh"
+)
+
+// CommitSigning is the signing configuration applied to the local source repository.
+type CommitSigning struct {
+	Format string `json:"format"`
+	// Key identifies the signing key: the GPG fingerprint or the SSH public key.
+	Key string `json:"key"`
+	// KeySource is where the key was read from: a file path or "env:<variable>".
+	KeySource string `json:"keySource"`
+
+	tempDir  string
+	repoPath string
+	// gitConfig holds the previous local value of every git setting signing changed, "" for
+	// settings that weren't set.
+	gitConfig map[string]string
+	// gnupgHome is the previous GNUPGHOME, restored by Cleanup, and hadGnupgHome whether it
+	// was set.
+	gnupgHome    string
+	hadGnupgHome bool
+}
+
+// CommitSignature records whether a commit made by the run carries a signature.
+type CommitSignature struct {
+	Module string `json:"module"`
+	Commit string `json:"commit"`
+	Signed bool   `json:"signed"`
+}
+
+// ConfigureCommitSigning configures the local source repository to sign every commit with the
+// key from --commit-signing-key-file, or else from the environment variable named by
+// --commit-signing-key-env. GPG keys are imported into a keyring private to the run; SSH keys
+// are used through git's ssh signing, which needs git 2.34 or later. Keys must not be protected
+// by a passphrase. Returns nil when --commit-signing-format isn't set. Call Cleanup once the run
+// is done to remove the key material written to disk and restore the repository's git settings
+// and GNUPGHOME.
+func ConfigureCommitSigning(localRepoPath string, logger *zap.Logger) (*CommitSigning, error) {
+	format := strings.ToLower(config.CommitSigningFormat)
+	if format == "" {
+		return nil, nil
+	}
+	if format != SigningFormatGPG && format != SigningFormatSSH {
+		return nil, fmt.Errorf("unknown commit signing format %q, expected %s or %s", config.CommitSigningFormat, SigningFormatGPG, SigningFormatSSH)
+	}
+	key, source, err := readSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := os.MkdirTemp("", "avm-sync-signing-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating signing key directory: %w", err)
+	}
+	signing := &CommitSigning{Format: format, KeySource: source, tempDir: tempDir, repoPath: localRepoPath, gitConfig: map[string]string{}}
+
+	var gitConfig [][2]string
+	switch format {
+	case SigningFormatGPG:
+		signing.gnupgHome, signing.hadGnupgHome = os.LookupEnv("GNUPGHOME")
+		signing.Key, err = importGPGKey(tempDir, key)
+		gitConfig = [][2]string{{"gpg.format", "openpgp"}, {"user.signingkey", signing.Key}}
+	case SigningFormatSSH:
+		var keyPath string
+		keyPath, signing.Key, err = writeSSHKey(tempDir, key)
+		gitConfig = [][2]string{{"gpg.format", "ssh"}, {"user.signingkey", keyPath}}
+	}
+	if err != nil {
+		signing.Cleanup()
+		return nil, err
+	}
+	gitConfig = append(gitConfig, [2]string{"commit.gpgsign", "true"})
+	for _, kv := range gitConfig {
+		cmd := exec.Command("git", "config", "--local", "--get", kv[0])
+		cmd.Dir = localRepoPath
+		previous, _ := cmd.Output()
+		signing.gitConfig[kv[0]] = strings.TrimSpace(string(previous))
+		if out, err := runGit(localRepoPath, logger, "", "config", kv[0], kv[1]); err != nil {
+			signing.Cleanup()
+			return nil, fmt.Errorf("error configuring %s: %w: %s", kv[0], err, strings.TrimSpace(out))
+		}
+	}
+	logger.Info("Configured commit signing", zap.String("format", format), zap.String("key", signing.Key), zap.String("source", source))
+	return signing, nil
+}
+
+// readSigningKey returns the signing key material and where it came from.
+func readSigningKey() ([]byte, string, error) {
+	if config.CommitSigningKeyFile != "" {
+		key, err := os.ReadFile(config.CommitSigningKeyFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading commit signing key: %w", err)
+		}
+		return key, config.CommitSigningKeyFile, nil
+	}
+	if config.CommitSigningKeyEnv != "" {
+		if key := os.Getenv(config.CommitSigningKeyEnv); key != "" {
+			return []byte(key), "env:" + config.CommitSigningKeyEnv, nil
+		}
+	}
+	return nil, "", errors.New("commit signing is enabled but no key was found in --commit-signing-key-file or the --commit-signing-key-env variable")
+}
+
+// importGPGKey imports a secret GPG key into a keyring in dir and points git's gpg at it via
+// GNUPGHOME. Returns the fingerprint of the imported key.
+func importGPGKey(dir string, key []byte) (string, error) {
+	home := filepath.Join(dir, "gnupg")
+	if err := os.Mkdir(home, 0700); err != nil {
+		return "", fmt.Errorf("error creating GPG home: %w", err)
+	}
+	cmd := exec.Command("gpg", "--homedir", home, "--batch", "--import")
+	cmd.Stdin = strings.NewReader(string(key))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("error importing GPG signing key: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	out, err := exec.Command("gpg", "--homedir", home, "--batch", "--with-colons", "--list-secret-keys").Output()
+	if err != nil {
+		return "", fmt.Errorf("error listing imported GPG key: %w", err)
+	}
+	// The first fpr record follows the sec record of the primary key.
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && len(fields) > 9 {
+			if err := os.Setenv("GNUPGHOME", home); err != nil {
+				return "", err
+			}
+			return fields[9], nil
+		}
+	}
+	return "", errors.New("commit signing key contains no GPG secret key")
+}
+
+// writeSSHKey writes an SSH private key to dir with owner-only permissions, as ssh-keygen
+// requires, and returns its path and public key.
+func writeSSHKey(dir string, key []byte) (string, string, error) {
+	keyPath := filepath.Join(dir, "signing_key")
+	material := strings.TrimSpace(string(key)) + "\n"
+	if err := os.WriteFile(keyPath, []byte(material), 0600); err != nil {
+		return "", "", fmt.Errorf("error writing SSH signing key: %w", err)
+	}
+	out, err := exec.Command("ssh-keygen", "-y", "-P", "", "-f", keyPath).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("commit signing key is not a passphrase-less SSH private key: %w", err)
+	}
+	return keyPath, strings.TrimSpace(string(out)), nil
+}
+
+// Cleanup restores the git settings and GNUPGHOME changed for signing, so nothing is left
+// pointing at the deleted key, stops the gpg-agent of the run's keyring and removes the key
+// material written for the run.
+func (s *CommitSigning) Cleanup() {
+	if s == nil || s.tempDir == "" {
+		return
+	}
+	for key, previous := range s.gitConfig {
+		args := []string{"config", "--local", "--unset", key}
+		if previous != "" {
+			args = []string{"config", "--local", key, previous}
+		}
+		cmd := exec.Command("git", args...)
+		cmd.Dir = s.repoPath
+		_ = cmd.Run()
+	}
+	if s.Format == SigningFormatGPG {
+		if s.hadGnupgHome {
+			_ = os.Setenv("GNUPGHOME", s.gnupgHome)
+		} else {
+			_ = os.Unsetenv("GNUPGHOME")
+		}
+		_ = exec.Command("gpgconf", "--homedir", filepath.Join(s.tempDir, "gnupg"), "--kill", "gpg-agent").Run()
+	}
+	_ = os.RemoveAll(s.tempDir)
+}
+
+// recordCommitSignature records in the run report whether the commit just made at HEAD is
+// signed.
+func (p *ModuleProcessor) recordCommitSignature(localRepoPath string, logName string) {
+	commit, err := runGit(localRepoPath, p.Logger, logName, "rev-parse", "HEAD")
+	if err != nil {
+		return
+	}
+	object, err := runGit(localRepoPath, p.Logger, logName, "cat-file", "commit", "HEAD")
+	if err != nil {
+		return
+	}
+	signed := strings.Contains(object, "\ngpgsig ")
+	if p.Report.CommitSigning != nil && !signed {
+		p.Logger.Warn("Commit was not signed although signing is configured", zap.String("module", logName), zap.String("commit", strings.TrimSpace(commit)))
+	}
+	p.Report.AddCommitSignature(CommitSignature{Module: logName, Commit: strings.TrimSpace(commit), Signed: signed})
+}
@@ -0,0 +1,150 @@
+package avmmodules
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"go.uber.org/zap"
+)
+
+const testSignerEmail = "sync@example.com"
+
+// requireTools skips the test when one of the given programs isn't installed.
+func requireTools(t *testing.T, tools ...string) {
+	t.Helper()
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+}
+
+// run runs a command in dir and fails the test when it fails.
+func run(t *testing.T, dir string, name string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %s: %v: %s", name, strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// newSigningTestRepo returns a new git repository committing as the sync identity.
+func newSigningTestRepo(t *testing.T) string {
+	t.Helper()
+	repo := t.TempDir()
+	run(t, repo, "git", "init", "--quiet")
+	run(t, repo, "git", "config", "user.name", "AVM Sync")
+	run(t, repo, "git", "config", "user.email", testSignerEmail)
+	return repo
+}
+
+// useSigningKey points the signing configuration at keyFile for the duration of the test.
+func useSigningKey(t *testing.T, format string, keyFile string) {
+	t.Helper()
+	format0, file0, env0 := config.CommitSigningFormat, config.CommitSigningKeyFile, config.CommitSigningKeyEnv
+	t.Cleanup(func() {
+		config.CommitSigningFormat, config.CommitSigningKeyFile, config.CommitSigningKeyEnv = format0, file0, env0
+	})
+	config.CommitSigningFormat, config.CommitSigningKeyFile, config.CommitSigningKeyEnv = format, keyFile, ""
+}
+
+// commitAndRecord commits a file to repo and returns the signature the run report records for
+// the commit.
+func commitAndRecord(t *testing.T, repo string, signing *CommitSigning) CommitSignature {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(repo, "main.tf"), []byte("# module\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, repo, "git", "add", "-A")
+	run(t, repo, "git", "commit", "--quiet", "-m", "chore(module): Synced AVM module")
+	p := &ModuleProcessor{Logger: zap.NewNop(), Report: NewRunReport()}
+	p.Report.SetCommitSigning(signing)
+	p.recordCommitSignature(repo, "test-module")
+	if len(p.Report.CommitSignatures) != 1 {
+		t.Fatalf("recorded %d commit signatures, want 1", len(p.Report.CommitSignatures))
+	}
+	return p.Report.CommitSignatures[0]
+}
+
+// assertCleanedUp checks that Cleanup removed the key material and the git settings that
+// pointed at it.
+func assertCleanedUp(t *testing.T, repo string, signing *CommitSigning) {
+	t.Helper()
+	tempDir := signing.tempDir
+	signing.Cleanup()
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Errorf("signing key directory %s still exists after Cleanup", tempDir)
+	}
+	for _, key := range []string{"commit.gpgsign", "user.signingkey", "gpg.format"} {
+		cmd := exec.Command("git", "config", "--local", "--get", key)
+		cmd.Dir = repo
+		if out, err := cmd.Output(); err == nil {
+			t.Errorf("git setting %s is still %q after Cleanup", key, strings.TrimSpace(string(out)))
+		}
+	}
+}
+
+func TestConfigureCommitSigningSSH(t *testing.T) {
+	requireTools(t, "git", "ssh-keygen")
+	keyDir := t.TempDir()
+	keyFile := filepath.Join(keyDir, "id_ed25519")
+	run(t, keyDir, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", testSignerEmail, "-f", keyFile)
+	useSigningKey(t, SigningFormatSSH, keyFile)
+	repo := newSigningTestRepo(t)
+
+	signing, err := ConfigureCommitSigning(repo, zap.NewNop())
+	if err != nil {
+		t.Fatalf("ConfigureCommitSigning: %v", err)
+	}
+	if signature := commitAndRecord(t, repo, signing); !signature.Signed {
+		t.Errorf("commit %s recorded as unsigned", signature.Commit)
+	}
+
+	publicKey, err := os.ReadFile(keyFile + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedSigners := filepath.Join(keyDir, "allowed_signers")
+	if err := os.WriteFile(allowedSigners, []byte(testSignerEmail+" "+string(publicKey)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, repo, "git", "-c", "gpg.ssh.allowedSignersFile="+allowedSigners, "verify-commit", "HEAD")
+
+	assertCleanedUp(t, repo, signing)
+}
+
+func TestConfigureCommitSigningGPG(t *testing.T) {
+	requireTools(t, "git", "gpg", "gpgconf")
+	keyHome := t.TempDir()
+	t.Cleanup(func() { _ = exec.Command("gpgconf", "--homedir", keyHome, "--kill", "gpg-agent").Run() })
+	run(t, keyHome, "gpg", "--homedir", keyHome, "--batch", "--passphrase", "", "--quick-gen-key", "AVM Sync <"+testSignerEmail+">", "ed25519", "sign", "never")
+	secretKey := run(t, keyHome, "gpg", "--homedir", keyHome, "--batch", "--armor", "--export-secret-keys")
+	keyFile := filepath.Join(keyHome, "signing.asc")
+	if err := os.WriteFile(keyFile, []byte(secretKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	useSigningKey(t, SigningFormatGPG, keyFile)
+	t.Setenv("GNUPGHOME", keyHome)
+	repo := newSigningTestRepo(t)
+
+	signing, err := ConfigureCommitSigning(repo, zap.NewNop())
+	if err != nil {
+		t.Fatalf("ConfigureCommitSigning: %v", err)
+	}
+	if signature := commitAndRecord(t, repo, signing); !signature.Signed {
+		t.Errorf("commit %s recorded as unsigned", signature.Commit)
+	}
+	run(t, repo, "git", "verify-commit", "HEAD")
+
+	assertCleanedUp(t, repo, signing)
+	if home := os.Getenv("GNUPGHOME"); home != keyHome {
+		t.Errorf("GNUPGHOME is %q after Cleanup, want it restored to %q", home, keyHome)
+	}
+}
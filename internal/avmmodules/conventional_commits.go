@@ -8,6 +8,7 @@ import (
 
 	"golang.org/x/mod/semver"
 
+	"github.com/theonlyway/avm-module-sync/internal/config"
 	"go.uber.org/zap"
 )
 
@@ -26,10 +27,17 @@ func ensureSemverPrefix(v string) string {
 // git fails.  The published innersource version is kept in lock-step with this upstream tag, so
 // no commit-message analysis is performed.
 func findLatestAvmTag(repoPath string, logger *zap.Logger) (latestTag string, latestTagCommit string) {
+	return findLatestTagWithPrefix(repoPath, "", logger)
+}
+
+// findLatestTagWithPrefix is findLatestAvmTag restricted to tags starting with prefix, which is
+// stripped from the returned tag name. Monorepos such as bicep-registry-modules tag each module
+// as "<module path>/<version>", so the prefix selects one module's tags.
+func findLatestTagWithPrefix(repoPath string, prefix string, logger *zap.Logger) (latestTag string, latestTagCommit string) {
 	// %(*objectname) is the dereferenced commit for annotated tags (empty for lightweight tags).
 	cmd := exec.Command("git", "for-each-ref",
 		"--format=%(refname:short)%09%(objectname)%09%(*objectname)%09%(creatordate:unix)",
-		"refs/tags")
+		"refs/tags/"+prefix)
 	cmd.Dir = repoPath
 	out, err := cmd.CombinedOutput()
 	if err != nil {
@@ -57,11 +65,16 @@ func findLatestAvmTag(repoPath string, logger *zap.Logger) (latestTag string, la
 			commit = fields[1] // lightweight tag points straight at the commit
 		}
 		when, _ := strconv.ParseInt(fields[3], 10, 64)
-		tags = append(tags, tagInfo{name: fields[0], commit: commit, when: when})
+		name := strings.TrimPrefix(fields[0], prefix)
+		if prefix != "" && (name == fields[0] || strings.Contains(name, "/")) {
+			// for-each-ref matches on path components; skip tags of nested modules.
+			continue
+		}
+		tags = append(tags, tagInfo{name: name, commit: commit, when: when})
 	}
 
 	if len(tags) == 0 {
-		logger.Info("No tags found in AVM repo", zap.String("path", repoPath))
+		logger.Info("No tags found in AVM repo", zap.String("path", repoPath), zap.String("prefix", prefix))
 		return "", ""
 	}
 
@@ -76,7 +89,62 @@ func findLatestAvmTag(repoPath string, logger *zap.Logger) (latestTag string, la
 
 	logger.Info("Found latest AVM tag",
 		zap.String("path", repoPath),
+		zap.String("prefix", prefix),
 		zap.String("tag", tags[0].name),
 		zap.String("commit", tags[0].commit))
 	return tags[0].name, tags[0].commit
 }
+
+// Conventional commit types of sync commits.
+const (
+	CommitTypeChore = "chore"
+	CommitTypeFix   = "fix"
+	CommitTypeFeat  = "feat"
+)
+
+// conventionalCommitType returns the conventional commit type of a sync. With
+// --semver-commit-types it follows the upstream version bump so release tooling downstream can
+// derive the next version: fix for a patch, feat for a minor bump or a new module, and feat for a
+// breaking sync, which the templates mark with "!". Otherwise, and for syncs that aren't version
+// bumps, it is chore.
+func conventionalCommitType(classification string, breaking bool) string {
+	if !config.SemverCommitTypes {
+		return CommitTypeChore
+	}
+	switch {
+	case breaking, classification == SyncMinor, classification == SyncNewModule:
+		return CommitTypeFeat
+	case classification == SyncPatch:
+		return CommitTypeFix
+	}
+	return CommitTypeChore
+}
+
+// breakingChangeFooter returns the BREAKING CHANGE footer of a breaking sync commit, naming the
+// major version bump and the breaking interface changes.
+func breakingChangeFooter(data SyncNameData, diff *InterfaceDiff) string {
+	var reasons []string
+	if data.Classification == SyncMajor {
+		reasons = append(reasons, "upstream major version bump from "+data.PreviousTag+" to "+data.LatestTag)
+	}
+	if diff != nil {
+		describe := func(what string, names []string) {
+			if len(names) > 0 {
+				reasons = append(reasons, what+" "+strings.Join(names, ", "))
+			}
+		}
+		changeNames := func(changes []InterfaceChange) []string {
+			names := make([]string, 0, len(changes))
+			for _, c := range changes {
+				names = append(names, c.Name)
+			}
+			return names
+		}
+		describe("removed variables", diff.RemovedVariables)
+		describe("new required variables", diff.AddedRequiredVariables)
+		describe("changed variable types", changeNames(diff.TypeChanged))
+		describe("changed variable defaults", changeNames(diff.DefaultChanged))
+		describe("removed outputs", diff.RemovedOutputs)
+	}
+	return "BREAKING CHANGE: " + data.ModuleName + ": " + strings.Join(reasons, "; ")
+}
@@ -2,6 +2,7 @@ package avmmodules
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -17,12 +18,14 @@ func removeGitFolder(p *ModuleProcessor, path string, moduleName string) {
 }
 
 // renameFolders renames a folder from oldPath to newPath, removing the newPath if it already exists.
+// Name collisions between modules are rejected by NewModuleNameIndex and transformModuleSet
+// before cloning, so an existing newPath is this module's own output from a previous run.
 func renameFolders(p *ModuleProcessor, oldPath string, newPath string, moduleName string) {
 	if oldPath == newPath {
 		return
 	}
 	if _, err := os.Stat(newPath); err == nil {
-		p.Logger.Warn("New path already exists, removing", zap.String("module", moduleName), zap.String("path", newPath))
+		p.Logger.Warn("New path already exists from a previous run, removing", zap.String("module", moduleName), zap.String("path", newPath))
 		os.RemoveAll(newPath)
 	}
 	p.Logger.Info("Renaming folder", zap.String("module", moduleName), zap.String("old", oldPath), zap.String("new", newPath))
@@ -42,13 +45,66 @@ func CleanUpTempDirs(logger *zap.Logger) {
 	os.RemoveAll(config.SourceRepoPath)
 }
 
+// moduleDirPath returns the path of a module folder inside a checkout of the ADO source
+// repository, below the given child path.
+func moduleDirPath(localRepoPath string, childPath string, moduleName string) string {
+	return filepath.Join(moduleRootPath(localRepoPath, childPath), moduleName)
+}
+
+// moduleRootPath returns the folder inside a checkout of the ADO source repository that holds
+// the synced modules of one family, i.e. the repository root joined with its child path.
+func moduleRootPath(localRepoPath string, childPath string) string {
+	if childPath != "" {
+		return filepath.Join(localRepoPath, childPath)
+	}
+	return localRepoPath
+}
+
+// sourceRepoChildPaths returns the child paths of the ADO source repository that hold synced
+// modules, the Terraform one first and without duplicates.
+func sourceRepoChildPaths() []string {
+	paths := []string{config.ModuleSyncSourceRepoChildPath}
+	if config.BicepSourceRepoChildPath != config.ModuleSyncSourceRepoChildPath {
+		paths = append(paths, config.BicepSourceRepoChildPath)
+	}
+	return paths
+}
+
+// walkModuleRoots walks the module root of every child path in a checkout of the ADO source
+// repository, calling fn with the child path a file was found under. Roots that don't exist are
+// skipped, as are .git folders and, when one root contains another, the nested root, so every
+// file is visited once.
+func walkModuleRoots(localRepoPath string, fn func(childPath string, root string, path string, info os.FileInfo) error) error {
+	childPaths := sourceRepoChildPaths()
+	roots := map[string]bool{}
+	for _, childPath := range childPaths {
+		roots[moduleRootPath(localRepoPath, childPath)] = true
+	}
+	for _, childPath := range childPaths {
+		root := moduleRootPath(localRepoPath, childPath)
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if info.IsDir() && (info.Name() == ".git" || path != root && roots[path]) {
+				return filepath.SkipDir
+			}
+			return fn(childPath, root, path, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // moduleVersionFilePath returns the absolute path of the .avm-version file for a module
 // inside the ADO source repository.
-func moduleVersionFilePath(moduleName string) string {
-	if config.ModuleSyncSourceRepoChildPath != "" {
-		return filepath.Join(config.SourceRepoPath, config.ModuleSyncSourceRepoChildPath, moduleName, config.AvmVersionFileName)
-	}
-	return filepath.Join(config.SourceRepoPath, moduleName, config.AvmVersionFileName)
+func moduleVersionFilePath(childPath string, moduleName string) string {
+	return filepath.Join(moduleDirPath(config.SourceRepoPath, childPath, moduleName), config.AvmVersionFileName)
 }
 
 // parseAvmVersionTag extracts the tag value from the contents of a .avm-version file.
@@ -90,18 +146,53 @@ func parseAvmVersionBackfill(content string) bool {
 	return false
 }
 
+// parseAvmVersionValue extracts the value of an arbitrary "key=value" line from the contents of
+// a .avm-version file. Returns an empty string when the key is not present.
+func parseAvmVersionValue(content string, key string) string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if val, ok := strings.CutPrefix(line, key+"="); ok {
+			return strings.TrimSpace(val)
+		}
+	}
+	return ""
+}
+
 // readAvmVersionFile reads the last-synced AVM tag, commit hash, and backfill flag from the
-// module's version file. Returns empty strings and false if the file does not exist or cannot
-// be read; the commit is empty for older files that stored only the bare tag.
-func readAvmVersionFile(moduleName string, logger *zap.Logger) (tag string, commit string, backfill bool) {
-	path := moduleVersionFilePath(moduleName)
-	data, err := os.ReadFile(path)
+// module's version file on targetBranch. The working tree is read for the default branch, which
+// it is based on; other target branches are read from their remote-tracking ref. Returns empty
+// strings and false if the file does not exist or cannot be read; the commit is empty for older
+// files that stored only the bare tag.
+func readAvmVersionFile(childPath string, moduleName string, targetBranch string, logger *zap.Logger) (tag string, commit string, backfill bool) {
+	path := moduleVersionFilePath(childPath, moduleName)
+	var data []byte
+	var err error
+	if targetBranch == config.DefaultBranchName {
+		data, err = os.ReadFile(path)
+	} else {
+		data, err = readFileAtRef(config.SourceRepoPath, "origin/"+targetBranch, path)
+	}
 	if err != nil {
 		if !os.IsNotExist(err) {
 			logger.Warn("Could not read AVM version file", zap.String("module", moduleName), zap.String("path", path), zap.Error(err))
 		}
 		return "", "", false
 	}
+	return parseAvmVersionFile(moduleName, data, logger)
+}
+
+// readAvmVersion reads the last-synced AVM tag, commit hash, and backfill flag of a module from
+// its version file on targetBranch. In --push-transport ado-api mode the target branches aren't
+// fetched, so the file is read through the push API instead.
+func (p *ModuleProcessor) readAvmVersion(childPath string, moduleName string, targetBranch string) (tag string, commit string, backfill bool) {
+	if p.Pusher != nil {
+		return p.readAvmVersionViaApi(childPath, moduleName, targetBranch)
+	}
+	return readAvmVersionFile(childPath, moduleName, targetBranch, p.Logger)
+}
+
+// parseAvmVersionFile returns the tag, commit hash and backfill flag of a module's version file.
+func parseAvmVersionFile(moduleName string, data []byte, logger *zap.Logger) (tag string, commit string, backfill bool) {
 	tag = parseAvmVersionTag(string(data))
 	commit = parseAvmVersionCommit(string(data))
 	backfill = parseAvmVersionBackfill(string(data))
@@ -113,21 +204,33 @@ func readAvmVersionFile(moduleName string, logger *zap.Logger) (tag string, comm
 	return tag, commit, backfill
 }
 
+// readFileAtRef returns the content of the file at path inside the repository at localRepoPath
+// as of ref. A file missing at ref is reported as os.ErrNotExist.
+func readFileAtRef(localRepoPath string, ref string, path string) ([]byte, error) {
+	rel, err := filepath.Rel(localRepoPath, path)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "show", ref+":"+filepath.ToSlash(rel))
+	cmd.Dir = localRepoPath
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
 // writeAvmVersionFile writes the latest AVM tag and the commit it points to to the module's
 // version file so subsequent runs know which tag was last synced and a downstream pipeline
-// can package the module from that exact commit.
-func writeAvmVersionFile(moduleName string, localRepoPath string, latestAvmTag string, latestAvmCommit string, logger *zap.Logger) {
+// can package the module from that exact commit. The upstream AVM module name and repository
+// URL are recorded alongside so an upstream rename can be traced back to the internal folder.
+func writeAvmVersionFile(moduleName string, moduleDir string, avmModuleName string, repoURL string, latestAvmTag string, latestAvmCommit string, logger *zap.Logger) {
 	if latestAvmTag == "" {
 		logger.Warn("No AVM tag available to write to version file, skipping", zap.String("module", moduleName))
 		return
 	}
-	var versionFilePath string
-	if config.ModuleSyncSourceRepoChildPath != "" {
-		versionFilePath = filepath.Join(localRepoPath, config.ModuleSyncSourceRepoChildPath, moduleName, config.AvmVersionFileName)
-	} else {
-		versionFilePath = filepath.Join(localRepoPath, moduleName, config.AvmVersionFileName)
-	}
-	content := "tag=" + latestAvmTag + "\ncommit=" + latestAvmCommit + "\n"
+	versionFilePath := filepath.Join(moduleDir, config.AvmVersionFileName)
+	content := "tag=" + latestAvmTag + "\ncommit=" + latestAvmCommit + "\nmodule=" + avmModuleName + "\nrepo=" + repoURL + "\n"
 	err := os.WriteFile(versionFilePath, []byte(content), 0644)
 	if err != nil {
 		logger.Error("Failed to write AVM version file", zap.String("module", moduleName), zap.String("path", versionFilePath), zap.Error(err))
@@ -1,8 +1,8 @@
 package avmmodules
 
 import (
-	"context"
 	"encoding/base64"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -10,26 +10,20 @@ import (
 	"strings"
 	"text/template"
 
-	"github.com/google/uuid"
-	adogit "github.com/microsoft/azure-devops-go-api/azuredevops/git"
 	cp "github.com/otiai10/copy"
-	"github.com/theonlyway/avm-module-sync/internal/ado"
 	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
 	"go.uber.org/zap"
 	"golang.org/x/mod/semver"
 )
 
-// copyModuleToBranch copies a module from the temporary clone location to the target repository branch.
-// Backs up the patches directory, does a clean copy, then restores patches to ensure files deleted from source are removed.
-func copyModuleToBranch[T Module](module T, localRepoPath string, nameTransformer ModuleNameTransformer, logger *zap.Logger) {
-	var sourcePath string
+// copyModuleToBranch copies a module from the temporary clone location to the module folder
+// moduleDir in the target repository branch. Backs up the patches directory, does a clean
+// copy, then restores patches to ensure files deleted from source are removed.
+func copyModuleToBranch[T Module](module T, moduleDir string, nameTransformer ModuleNameTransformer, logger *zap.Logger) {
 	moduleName := nameTransformer(module.GetModuleName())
 	modulePath := config.TempAvmModuleRepoPath + "/" + moduleName
-	if config.ModuleSyncSourceRepoChildPath != "" {
-		sourcePath = localRepoPath + "/" + config.ModuleSyncSourceRepoChildPath + "/" + moduleName
-	} else {
-		sourcePath = localRepoPath + "/" + moduleName
-	}
+	sourcePath := moduleDir
 
 	// Backup patches directory if it exists
 	patchesPath := filepath.Join(sourcePath, config.PatchesFolderName)
@@ -76,27 +70,23 @@ func copyModuleToBranch[T Module](module T, localRepoPath string, nameTransforme
 }
 
 // applyPatchesIfExist searches for and applies any .patch files found in the module's patches directory.
-// Patches are applied recursively from subdirectories using git apply.
-func applyPatchesIfExist(moduleName string, localRepoPath string, logger *zap.Logger) error {
+// Patches are applied recursively from subdirectories using git apply from the repository root.
+// Returns the applied and failed patch files relative to the patches directory.
+func applyPatchesIfExist(moduleName string, localRepoPath string, moduleDir string, logger *zap.Logger) (applied []string, failed []string, err error) {
 	// Construct the patch folder path
-	var patchFolderPath string
-	if config.ModuleSyncSourceRepoChildPath != "" {
-		patchFolderPath = filepath.Join(localRepoPath, config.ModuleSyncSourceRepoChildPath, moduleName, config.PatchesFolderName)
-	} else {
-		patchFolderPath = filepath.Join(localRepoPath, moduleName, config.PatchesFolderName)
-	}
+	patchFolderPath := filepath.Join(moduleDir, config.PatchesFolderName)
 
 	// Check if the patch folder exists
 	if _, err := os.Stat(patchFolderPath); os.IsNotExist(err) {
 		logger.Info("No patches folder found for module", zap.String("module", moduleName), zap.String("patchFolder", patchFolderPath))
-		return nil
+		return nil, nil, nil
 	}
 
 	logger.Info("Found patches folder, searching for patch files", zap.String("module", moduleName), zap.String("patchFolder", patchFolderPath))
 
 	// Walk through the patch folder and subdirectories to find all .patch files
 	var patchFiles []string
-	err := filepath.Walk(patchFolderPath, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(patchFolderPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -108,19 +98,23 @@ func applyPatchesIfExist(moduleName string, localRepoPath string, logger *zap.Lo
 
 	if err != nil {
 		logger.Error("Error walking patch folder", zap.String("module", moduleName), zap.String("patchFolder", patchFolderPath), zap.Error(err))
-		return err
+		return nil, nil, err
 	}
 
 	if len(patchFiles) == 0 {
 		logger.Info("No patch files found in patches folder", zap.String("module", moduleName), zap.String("patchFolder", patchFolderPath))
-		return nil
+		return nil, nil, nil
 	}
 
 	logger.Info("Found patch files to apply", zap.String("module", moduleName), zap.Int("count", len(patchFiles)))
 
 	// Apply each patch file
-	failedPatches := 0
 	for _, patchFile := range patchFiles {
+		patchName, relErr := filepath.Rel(patchFolderPath, patchFile)
+		if relErr != nil {
+			patchName = filepath.Base(patchFile)
+		}
+		patchName = filepath.ToSlash(patchName)
 		logger.Info("Applying patch file", zap.String("module", moduleName), zap.String("patchFile", patchFile))
 
 		// Use git apply to apply the patch
@@ -130,18 +124,19 @@ func applyPatchesIfExist(moduleName string, localRepoPath string, logger *zap.Lo
 
 		if err != nil {
 			logger.Error("Failed to apply patch, continuing with remaining patches", zap.String("module", moduleName), zap.String("patchFile", patchFile), zap.String("output", string(output)), zap.Error(err))
-			failedPatches++
+			failed = append(failed, patchName)
 			continue
 		}
+		applied = append(applied, patchName)
 
 		logger.Info("Successfully applied patch", zap.String("module", moduleName), zap.String("patchFile", patchFile))
 	}
 
-	if failedPatches > 0 {
-		logger.Warn("Some patches failed to apply, but continuing with commit", zap.String("module", moduleName), zap.Int("failedCount", failedPatches), zap.Int("totalCount", len(patchFiles)))
+	if len(failed) > 0 {
+		logger.Warn("Some patches failed to apply, but continuing with commit", zap.String("module", moduleName), zap.Int("failedCount", len(failed)), zap.Int("totalCount", len(patchFiles)))
 	}
 
-	return nil
+	return applied, failed, nil
 }
 
 // avmRegistrySourceRe matches a Terraform/OpenTofu `source` argument that references a module on
@@ -156,29 +151,24 @@ var avmRegistrySourceRe = regexp.MustCompile(`(source\s*=\s*")(?:registry\.(?:te
 // the public AVM Terraform registry so they instead point at the configured Artifactory path.
 // Every .tf file under the module directory is processed except those inside an examples folder.
 // The Artifactory source is produced by executing config.ArtifactorySourceTemplate with the
-// transformed (RVM) module name available as {{ .ModuleName }}. The version argument is left
-// untouched. When no template is configured the function is a no-op.
-func rewriteRegistrySourcesToArtifactory(moduleName string, localRepoPath string, logger *zap.Logger) error {
+// transformed module name, resolved through the module name index, available as
+// {{ .ModuleName }}. The version argument is left untouched. When no template is configured
+// the function is a no-op. Returns the rewrites made, with files relative to moduleDir.
+func rewriteRegistrySourcesToArtifactory(moduleName string, moduleDir string, names *ModuleNameIndex, logger *zap.Logger) ([]sourceRewrite, error) {
 	if config.ArtifactorySourceTemplate == "" {
-		return nil
+		return nil, nil
 	}
 
 	tmpl, err := template.New("artifactory-source").Parse(config.ArtifactorySourceTemplate)
 	if err != nil {
 		logger.Error("Failed to parse Artifactory source template", zap.String("template", config.ArtifactorySourceTemplate), zap.Error(err))
-		return err
-	}
-
-	var moduleDir string
-	if config.ModuleSyncSourceRepoChildPath != "" {
-		moduleDir = filepath.Join(localRepoPath, config.ModuleSyncSourceRepoChildPath, moduleName)
-	} else {
-		moduleDir = filepath.Join(localRepoPath, moduleName)
+		return nil, err
 	}
 
 	logger.Info("Rewriting public registry module sources to Artifactory", zap.String("module", moduleName), zap.String("moduleDir", moduleDir))
 
-	return filepath.Walk(moduleDir, func(path string, info os.FileInfo, err error) error {
+	var rewrites []sourceRewrite
+	err = filepath.Walk(moduleDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -192,20 +182,23 @@ func rewriteRegistrySourcesToArtifactory(moduleName string, localRepoPath string
 		if filepath.Ext(path) != ".tf" {
 			return nil
 		}
-		return rewriteTfFileSources(path, tmpl, logger)
+		fileRewrites, err := rewriteTfFileSources(path, tmpl, names, logger)
+		rewrites = append(rewrites, relativeRewrites(moduleDir, fileRewrites)...)
+		return err
 	})
+	return rewrites, err
 }
 
 // rewriteTfFileSources rewrites public AVM registry `source` references in a single .tf file
 // to the Artifactory equivalent, writing the file back only when a change is made.
-func rewriteTfFileSources(path string, tmpl *template.Template, logger *zap.Logger) error {
+func rewriteTfFileSources(path string, tmpl *template.Template, names *ModuleNameIndex, logger *zap.Logger) ([]sourceRewrite, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		logger.Error("Failed to read .tf file for source rewrite", zap.String("file", path), zap.Error(err))
-		return err
+		return nil, err
 	}
 
-	changed := false
+	var rewrites []sourceRewrite
 	out := avmRegistrySourceRe.ReplaceAllFunc(data, func(match []byte) []byte {
 		groups := avmRegistrySourceRe.FindSubmatch(match)
 		prefix := string(groups[1])
@@ -214,51 +207,58 @@ func rewriteTfFileSources(path string, tmpl *template.Template, logger *zap.Logg
 		suffix := string(groups[4])
 
 		var sb strings.Builder
-		if execErr := tmpl.Execute(&sb, struct{ ModuleName string }{ModuleName: transformAvmModuleName(avmName)}); execErr != nil {
+		if execErr := tmpl.Execute(&sb, struct{ ModuleName string }{ModuleName: names.Transform(avmName)}); execErr != nil {
 			logger.Error("Failed to render Artifactory source template", zap.String("file", path), zap.String("module", avmName), zap.Error(execErr))
 			return match
 		}
 
-		changed = true
+		rewrites = append(rewrites, sourceRewrite{File: path, From: avmName, To: sb.String()})
 		logger.Info("Rewriting registry source to Artifactory", zap.String("file", path), zap.String("from", avmName), zap.String("to", sb.String()))
 		return []byte(prefix + sb.String() + subpath + suffix)
 	})
 
-	if !changed {
-		return nil
+	if len(rewrites) == 0 {
+		return nil, nil
 	}
 
 	if err := os.WriteFile(path, out, 0644); err != nil {
 		logger.Error("Failed to write rewritten .tf file", zap.String("file", path), zap.Error(err))
-		return err
+		return nil, err
 	}
-	return nil
+	return rewrites, nil
+}
+
+// sourceRewrite is a public registry reference replaced by its internal equivalent.
+type sourceRewrite struct {
+	File string
+	From string
+	To   string
 }
 
-// buildCommitMessage constructs the conventional commit message used for a module sync.
-// The innersource version is kept in lock-step with the upstream tag rather than being
-// derived from the commit type, so a fixed "chore" type is used; it only needs to satisfy
-// the pipeline's conventional-commit validation.
-func buildCommitMessage(moduleName string) string {
-	return "chore(module): Synced AVM module " + moduleName
+// relativeRewrites returns rewrites with their files made relative to moduleDir.
+func relativeRewrites(moduleDir string, rewrites []sourceRewrite) []sourceRewrite {
+	for i := range rewrites {
+		if rel, err := filepath.Rel(moduleDir, rewrites[i].File); err == nil {
+			rewrites[i].File = filepath.ToSlash(rel)
+		}
+	}
+	return rewrites
 }
 
-// CommitAndPushModulesToGit handles the complete Git workflow for syncing a module.
-// It creates a feature branch, copies the module, applies patches, commits changes,
-// pushes to remote, and creates a pull request in Azure DevOps.
-// latestAvmTag is the most recent tag from the upstream AVM repo and latestAvmCommit is the
-// commit hash that tag points to; both are written to .avm-version inside the module folder
-// so the next run knows where to start from and a downstream pipeline can package the module.
-func CommitAndPushModulesToGit[T Module](clients *ado.AdoClients, ctx context.Context, project string, repoId *uuid.UUID, module T, localRepoPath string, nameTransformer ModuleNameTransformer, latestAvmTag string, latestAvmCommit string, logger *zap.Logger) error {
-	branchName := "feat/avm-module-sync/" + nameTransformer(module.GetModuleName())
-	authorName := config.ModuleSyncAuthorName
-	authorEmail := config.ModuleSyncAuthorEmail
+// CommitAndPushModulesToGit syncs a module onto its sync branch, pushes it and creates or
+// refreshes its pull request. Modules whose upstream tag hasn't advanced are skipped.
+func CommitAndPushModulesToGit[T Module](p *ModuleProcessor, module T, localRepoPath string, nameTransformer ModuleNameTransformer) error {
+	host, ctx, logger := p.Host, p.Context, p.Logger
 	moduleName := nameTransformer(module.GetModuleName())
+	latestAvmTag, latestAvmCommit, upstreamCommits := p.upstreamState(moduleName)
+	reviewers := p.Reviewers.ReviewersFor(module)
+	targetBranch := p.TargetBranches.TargetBranchFor(module)
 
 	// Skip if the upstream tag hasn't advanced since the last sync, unless this module is
 	// force-updated via the force-update-all or force-update-modules flags. When the tag name
 	// is unchanged but the commit it points to has moved, the module is re-synced.
-	lastSyncedTag, lastSyncedCommit, backfill := readAvmVersionFile(moduleName, logger)
+	moduleDir := moduleDirPath(localRepoPath, module.GetSourceRepoChildPath(), moduleName)
+	lastSyncedTag, lastSyncedCommit, backfill := p.readAvmVersion(module.GetSourceRepoChildPath(), moduleName, targetBranch)
 	if isModuleForced(module.GetModuleName()) || backfill {
 		switch {
 		case backfill:
@@ -283,6 +283,7 @@ func CommitAndPushModulesToGit[T Module](clients *ado.AdoClients, ctx context.Co
 					zap.String("module", moduleName),
 					zap.String("lastSyncedTag", lastSyncedTag),
 					zap.String("latestAvmTag", latestAvmTag))
+				p.recordUpToDateSync(module, moduleName, targetBranch, lastSyncedTag, latestAvmTag)
 				return nil
 			case cmp == 0:
 				// Same tag name: skip only when the commit also matches (or either commit is
@@ -292,6 +293,7 @@ func CommitAndPushModulesToGit[T Module](clients *ado.AdoClients, ctx context.Co
 						zap.String("module", moduleName),
 						zap.String("lastSyncedTag", lastSyncedTag),
 						zap.String("latestAvmTag", latestAvmTag))
+					p.recordUpToDateSync(module, moduleName, targetBranch, lastSyncedTag, latestAvmTag)
 					return nil
 				}
 				logger.Info("Upstream tag unchanged but commit moved, re-syncing",
@@ -302,101 +304,307 @@ func CommitAndPushModulesToGit[T Module](clients *ado.AdoClients, ctx context.Co
 			}
 		}
 	}
-	commitMsg := buildCommitMessage(moduleName)
-	defaultBranch := config.DefaultBranchName
-	baseRef := "origin/" + defaultBranch
-	logger.Info("Starting git operations", zap.String("module", moduleName), zap.String("path", localRepoPath))
+	classification := classifySync(lastSyncedTag, latestAvmTag, backfill, isModuleForced(module.GetModuleName()))
+	nameData := syncNameData(module, moduleName, targetBranch)
+	nameData.PreviousTag, nameData.LatestTag = lastSyncedTag, latestAvmTag
+	nameData.Bump = semverBump(lastSyncedTag, latestAvmTag)
+	nameData.Classification = classification
+	branchName, err := p.Templates.BranchName(nameData)
+	if err != nil {
+		logger.Error("Failed to render sync branch name", zap.String("module", moduleName), zap.Error(err))
+		return err
+	}
+	if config.AggregatePullRequests {
+		// The module is committed onto the aggregate branch, so its own sync branch is superseded.
+		p.recordSyncBranch(nameData, moduleName+" is synced through an aggregate pull request")
+	} else {
+		p.recordSyncBranch(nameData, "")
+	}
+	logger.Info("Starting git operations", zap.String("module", moduleName), zap.String("path", localRepoPath), zap.String("targetBranch", targetBranch))
 
-	// Configure the commit identity (CI checkouts often have none set).
-	_, _ = runGit(localRepoPath, logger, moduleName, "config", "user.name", authorName)
-	_, _ = runGit(localRepoPath, logger, moduleName, "config", "user.email", authorEmail)
+	var push *apiPush
+	switch {
+	case config.PushTransport == PushTransportAdoApi:
+		if push, err = p.prepareApiPush(localRepoPath, branchName, targetBranch, moduleDir, moduleName); err != nil {
+			return err
+		}
+	case config.AggregatePullRequests:
+		if err := p.checkoutAggregateBranch(localRepoPath, targetBranch, moduleName); err != nil {
+			return err
+		}
+	default:
+		if err := checkoutSyncBranch(localRepoPath, branchName, targetBranch, moduleName, logger); err != nil {
+			return err
+		}
+	}
 
-	// Create (or reset) the module branch fresh from the default branch using a single raw-git
-	// checkout -B. This guarantees the branch contains only the default branch plus this one
-	// module, eliminating the index desync that previously let other modules' files leak into
-	// the commit. -f discards any local modifications left from the prior module.
-	logger.Info("Creating module branch from default branch", zap.String("module", moduleName), zap.String("branch", branchName), zap.String("base", baseRef))
-	if out, err := runGit(localRepoPath, logger, moduleName, "checkout", "-f", "-B", branchName, baseRef); err != nil {
-		logger.Error("Failed to create module branch", zap.String("module", moduleName), zap.String("branch", branchName), zap.String("output", out), zap.Error(err))
+	description := pullRequestDescription{
+		ModuleName:         moduleName,
+		UpstreamModuleName: module.GetModuleName(),
+		Origin:             module.GetOrigin(),
+		RepoURL:            module.GetRepoURL(),
+		PreviousTag:        lastSyncedTag,
+		PreviousCommit:     lastSyncedCommit,
+		LatestTag:          latestAvmTag,
+		LatestCommit:       latestAvmCommit,
+		UpstreamLog:        upstreamCommits,
+		UpstreamOwners:     module.GetOwners(),
+		Reviewers:          reviewers,
+		Classification:     classification,
+	}
+	synced, err := commitModuleSync(p, module, localRepoPath, moduleDir, nameTransformer, description, nameData, push)
+	if config.AggregatePullRequests {
+		if err != nil {
+			p.discardAggregateChanges(localRepoPath, moduleName)
+			return err
+		}
+		if synced == nil {
+			return nil
+		}
+		return p.addToAggregate(localRepoPath, *synced)
+	}
+	if err != nil || synced == nil {
 		return err
 	}
 
-	// Remove any untracked files/directories left over from a previously synced module so they
-	// are not swept into this module's commit by `git add -A`.
-	if out, err := runGit(localRepoPath, logger, moduleName, "clean", "-ffd"); err != nil {
-		logger.Error("Failed to clean working tree", zap.String("module", moduleName), zap.String("output", out), zap.Error(err))
+	// Capture what the branch held before the force-push so an existing PR can be told what changed.
+	var previous *previousPush
+	if push != nil {
+		if push.Resync {
+			previous = apiPreviousPush(localRepoPath, push, moduleDir, latestAvmTag)
+		}
+		if err := p.pushViaApi(push, moduleName); err != nil {
+			return err
+		}
+	} else {
+		previous = fetchPreviousPush(localRepoPath, branchName, moduleDir, moduleName, logger)
+		if err := pushBranch(localRepoPath, branchName, moduleName, logger); err != nil {
+			return err
+		}
+	}
+	// Create the pull request, or refresh the one the force-push above already updated.
+	title, err := p.Templates.PullRequestTitle(synced.Name)
+	if err != nil {
+		logger.Error("Failed to render pull request title", zap.String("module", moduleName), zap.Error(err))
+		return err
+	}
+	comment := ""
+	if previous != nil {
+		comment = resyncComment(localRepoPath, previous, moduleDir, moduleName, latestAvmTag, latestAvmCommit, logger)
+	}
+	pr, err := createOrUpdatePullRequest(host, ctx, repohost.PullRequestOptions{
+		SourceBranch: branchName,
+		TargetBranch: targetBranch,
+		Title:        title,
+		Description:  renderPullRequestDescription(synced.Description, logger),
+		Labels:       synced.Labels,
+		Reviewers:    reviewers,
+		Draft:        synced.Draft,
+	}, comment, moduleName, logger)
+	if err != nil || pr == nil {
 		return err
 	}
+	p.applyAutoComplete(pr.ID, synced.Description.Classification, synced.Description)
+	return nil
+}
 
-	copyModuleToBranch(module, localRepoPath, nameTransformer, logger)
+// moduleSync is a module sync committed onto the checked-out branch, with what its pull
+// request needs.
+type moduleSync struct {
+	Description pullRequestDescription
+	Name        SyncNameData
+	Labels      []string
+	Draft       bool
+}
+
+// commitModuleSync copies a module onto the checked-out branch, writes its version file, applies
+// patches and source rewrites, and commits the result. description carries the module, version
+// and classification fields and is completed with links, the changelog excerpt, patches,
+// rewrites, the interface diff and file counts. nameData is completed with the conventional
+// commit type once the interface diff is known and renders the commit message. With push set,
+// the commit is instead recorded in push as the changes between the module folder and the
+// branch it is pushed to, ready for the push API. Returns nil when nothing changed.
+func commitModuleSync[T Module](p *ModuleProcessor, module T, localRepoPath string, moduleDir string, nameTransformer ModuleNameTransformer, description pullRequestDescription, nameData SyncNameData, push *apiPush) (*moduleSync, error) {
+	names, logger := p.Modules.Names, p.Logger
+	moduleName := description.ModuleName
+	lastSyncedTag, latestAvmTag := description.PreviousTag, description.LatestTag
+
+	// Read the interface of the previously synced version before it is overwritten, from the
+	// commit the push builds on when the module folder isn't a checkout of the branch.
+	var previousInterface *moduleInterface
+	if push != nil {
+		previousInterface = p.apiModuleInterface(localRepoPath, push, moduleDir, moduleName)
+	} else {
+		previousInterface = readModuleInterface(moduleDir, moduleName, logger)
+	}
+	copyModuleToBranch(module, moduleDir, nameTransformer, logger)
 
 	// Write the version file so the next sync knows which AVM tag was last applied
-	writeAvmVersionFile(moduleName, localRepoPath, latestAvmTag, latestAvmCommit, logger)
+	writeAvmVersionFile(moduleName, moduleDir, module.GetModuleName(), module.GetRepoURL(), latestAvmTag, description.LatestCommit, logger)
+
+	if len(description.UpstreamLog) > maxUpstreamLogEntries {
+		description.UpstreamLog = description.UpstreamLog[:maxUpstreamLogEntries]
+		description.UpstreamLogTruncated = true
+	}
+	description.setUpstreamLinks(module)
+	description.Changelog = changelogExcerpt(moduleDir, lastSyncedTag, latestAvmTag)
 
 	// Apply patches if they exist
-	if err := applyPatchesIfExist(moduleName, localRepoPath, logger); err != nil {
+	applied, failed, err := applyPatchesIfExist(moduleName, localRepoPath, moduleDir, logger)
+	if err != nil {
 		logger.Warn("Errors occurred while applying patches, but continuing with commit", zap.String("module", moduleName), zap.Error(err))
 	}
+	description.PatchesApplied, description.PatchesFailed = applied, failed
 
 	// Rewrite public AVM registry module sources to Artifactory if a template is configured
-	if err := rewriteRegistrySourcesToArtifactory(moduleName, localRepoPath, logger); err != nil {
+	rewrites, err := rewriteRegistrySourcesToArtifactory(moduleName, moduleDir, names, logger)
+	if err != nil {
 		logger.Warn("Errors occurred while rewriting registry sources, but continuing with commit", zap.String("module", moduleName), zap.Error(err))
 	}
+	description.SourceRewrites = append(description.SourceRewrites, rewrites...)
 
-	// Stage all module files (respecting .gitattributes/line endings) including deletions.
-	logger.Info("Staging changes", zap.String("module", moduleName))
-	if out, err := runGit(localRepoPath, logger, moduleName, "add", "-A", "."); err != nil {
-		logger.Error("Failed to stage changes", zap.String("module", moduleName), zap.String("output", out), zap.Error(err))
-		return err
+	// Rewrite public Bicep registry references if a template is configured
+	rewrites, err = rewriteBicepRegistrySources(moduleName, moduleDir, names, logger)
+	if err != nil {
+		logger.Warn("Errors occurred while rewriting Bicep registry references, but continuing with commit", zap.String("module", moduleName), zap.Error(err))
 	}
+	description.SourceRewrites = append(description.SourceRewrites, rewrites...)
 
-	// Skip the commit/PR when nothing actually changed for this module.
-	statusOut, err := runGit(localRepoPath, logger, moduleName, "status", "--porcelain")
+	patchesFailed := len(description.PatchesFailed) > 0
+	labels := classificationLabels(description.Classification, patchesFailed)
+	if diff := diffModuleInterfaces(moduleName, previousInterface, readModuleInterface(moduleDir, moduleName, logger)); diff != nil && !diff.IsEmpty() {
+		logger.Info("Module interface changed",
+			zap.String("module", moduleName),
+			zap.Bool("breaking", diff.IsBreaking()),
+			zap.Int("removedVariables", len(diff.RemovedVariables)),
+			zap.Int("removedOutputs", len(diff.RemovedOutputs)))
+		description.InterfaceDiff = diff
+		p.Report.AddInterfaceDiff(diff)
+		if diff.IsBreaking() {
+			labels = append(labels, config.BreakingChangeLabel)
+		}
+	}
+
+	if push != nil {
+		changes, err := moduleTreeChanges(localRepoPath, moduleDir, push.Blobs)
+		if err != nil {
+			logger.Error("Failed to compare module with branch", zap.String("module", moduleName), zap.Error(err))
+			return nil, err
+		}
+		if len(changes) == 0 {
+			logger.Info("No changes to push", zap.String("module", moduleName))
+			return nil, nil
+		}
+		push.Commit.Changes = changes
+		description.FilesAdded, description.FilesChanged, description.FilesDeleted = changeCounts(changes)
+	} else {
+		// Stage all module files (respecting .gitattributes/line endings) including deletions.
+		logger.Info("Staging changes", zap.String("module", moduleName))
+		if out, err := runGit(localRepoPath, logger, moduleName, "add", "-A", "."); err != nil {
+			logger.Error("Failed to stage changes", zap.String("module", moduleName), zap.String("output", out), zap.Error(err))
+			return nil, err
+		}
+
+		// Skip the commit/PR when nothing actually changed for this module.
+		statusOut, err := runGit(localRepoPath, logger, moduleName, "status", "--porcelain")
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(statusOut) == "" {
+			logger.Info("No staged changes to commit", zap.String("module", moduleName))
+			return nil, nil
+		}
+		description.FilesAdded, description.FilesChanged, description.FilesDeleted = stagedFileCounts(localRepoPath, moduleDir, moduleName, logger)
+	}
+
+	nameData.Breaking = nameData.Classification == SyncMajor || description.InterfaceDiff != nil && description.InterfaceDiff.IsBreaking()
+	nameData.CommitType = conventionalCommitType(nameData.Classification, nameData.Breaking)
+	commitMsg, err := p.Templates.CommitMessage(nameData)
 	if err != nil {
-		return err
+		logger.Error("Failed to render commit message", zap.String("module", moduleName), zap.Error(err))
+		return nil, err
 	}
-	if strings.TrimSpace(statusOut) == "" {
-		logger.Info("No staged changes to commit", zap.String("module", moduleName))
-		return nil
+	if config.SemverCommitTypes && nameData.Breaking {
+		commitMsg += "\n\n" + breakingChangeFooter(nameData, description.InterfaceDiff)
+	}
+
+	synced := &moduleSync{Description: description, Name: nameData, Labels: labels, Draft: isDraftSync(description.Classification, patchesFailed)}
+	if push != nil {
+		push.Commit.Message = commitMsg
+		return synced, nil
 	}
 
 	logger.Info("Committing changes", zap.String("module", moduleName), zap.String("commit_msg", commitMsg))
 	if out, err := runGit(localRepoPath, logger, moduleName, "commit", "-m", commitMsg); err != nil {
 		logger.Error("Failed to commit changes", zap.String("module", moduleName), zap.String("output", out), zap.Error(err))
+		return nil, err
+	}
+	p.recordCommitSignature(localRepoPath, moduleName)
+	return synced, nil
+}
+
+// checkoutSyncBranch creates (or resets) a sync branch fresh from the target branch it will be
+// merged into and configures the commit identity (CI checkouts often have none set).
+func checkoutSyncBranch(localRepoPath string, branchName string, targetBranch string, moduleName string, logger *zap.Logger) error {
+	baseRef := "origin/" + targetBranch
+	_, _ = runGit(localRepoPath, logger, moduleName, "config", "user.name", config.ModuleSyncAuthorName)
+	_, _ = runGit(localRepoPath, logger, moduleName, "config", "user.email", config.ModuleSyncAuthorEmail)
+
+	// Create (or reset) the module branch fresh from the target branch using a single raw-git
+	// checkout -B. This guarantees the branch contains only the target branch plus this one
+	// module, eliminating the index desync that previously let other modules' files leak into
+	// the commit. -f discards any local modifications left from the prior module.
+	logger.Info("Creating module branch from target branch", zap.String("module", moduleName), zap.String("branch", branchName), zap.String("base", baseRef))
+	if out, err := runGit(localRepoPath, logger, moduleName, "checkout", "-f", "-B", branchName, baseRef); err != nil {
+		logger.Error("Failed to create module branch", zap.String("module", moduleName), zap.String("branch", branchName), zap.String("output", out), zap.Error(err))
 		return err
 	}
 
-	// Force-push so the remote branch always reflects exactly this module's state, healing any
-	// previously polluted branch. In an ADO pipeline persistCredentials handles auth; for local
-	// runs a PAT is injected via an http extra header.
-	logger.Info("Pushing changes to origin", zap.String("module", moduleName), zap.String("branch", branchName))
-	pushArgs := []string{}
-	if config.AdoPat != "" {
-		authHeader := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(":"+config.AdoPat))
-		pushArgs = append(pushArgs, "-c", "http.extraheader="+authHeader)
+	// Remove any untracked files/directories left over from a previously synced module so they
+	// are not swept into this module's commit by `git add -A`.
+	if out, err := runGit(localRepoPath, logger, moduleName, "clean", "-ffd"); err != nil {
+		logger.Error("Failed to clean working tree", zap.String("module", moduleName), zap.String("output", out), zap.Error(err))
+		return err
 	}
-	pushArgs = append(pushArgs, "push", "-f", "origin", "HEAD:refs/heads/"+branchName)
+	return nil
+}
+
+// checkoutDefaultBranch detaches the working tree at the remote default branch, discarding any
+// sync branch left checked out by an earlier phase.
+func checkoutDefaultBranch(localRepoPath string, logger *zap.Logger) error {
+	if out, err := runGit(localRepoPath, logger, "", "checkout", "-f", "--detach", "origin/"+config.DefaultBranchName); err != nil {
+		logger.Error("Failed to check out default branch", zap.String("output", out), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// pushBranch force-pushes HEAD to the given branch so the remote branch always reflects exactly
+// this module's state, healing any previously polluted branch. In an ADO pipeline
+// persistCredentials handles auth; for local runs a PAT is injected via an http extra header,
+// or the GitHub token when the repository is hosted on GitHub.
+func pushBranch(localRepoPath string, branchName string, moduleName string, logger *zap.Logger) error {
+	logger.Info("Pushing changes to origin", zap.String("module", moduleName), zap.String("branch", branchName))
+	pushArgs := append(gitAuthArgs(), "push", "-f", "origin", "HEAD:refs/heads/"+branchName)
 	if out, err := runGit(localRepoPath, logger, moduleName, pushArgs...); err != nil {
 		logger.Error("Failed to push changes to origin", zap.String("module", moduleName), zap.String("output", out), zap.Error(err))
 		return err
 	}
-	// Create pull request
-	title := buildCommitMessage(moduleName)
-	description := "This is an automated pull request to sync the " + moduleName + " module from the source AVM repository " + module.GetRepoURL()
-	sourceRef := "refs/heads/" + branchName
-	targetRef := "refs/heads/" + config.DefaultBranchName
-	pr, err := createPullRequest(clients.GitClient, ctx, repoId, project, sourceRef, targetRef, title, description)
-	if err != nil {
-		// An active PR for this branch already exists (e.g. on a re-run); the force-push above
-		// already updated it, so treat this as success rather than failing the module.
-		if strings.Contains(strings.ToLower(err.Error()), "active pull request") {
-			logger.Info("Pull request already exists for branch, skipping creation", zap.String("module", moduleName), zap.String("branch", branchName))
-			return nil
-		}
-		logger.Error("Failed to create pull request", zap.String("module", moduleName), zap.Error(err))
-		return err
+	return nil
+}
+
+// gitAuthArgs returns the git options that authenticate against origin for local runs: the
+// GitHub token when the repository is hosted on GitHub, otherwise the ADO PAT. Empty when
+// credentials come from the environment.
+func gitAuthArgs() []string {
+	if config.RepositoryHost == repohost.HostGitHub && config.GitHubToken != "" {
+		authHeader := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:"+config.GitHubToken))
+		return []string{"-c", "http.extraheader=" + authHeader}
+	}
+	if config.AdoPat != "" {
+		authHeader := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(":"+config.AdoPat))
+		return []string{"-c", "http.extraheader=" + authHeader}
 	}
-	logger.Info("Created pull request", zap.String("module", moduleName), zap.Int("prId", *pr.PullRequestId))
 	return nil
 }
 
@@ -415,19 +623,8 @@ func runGit(dir string, logger *zap.Logger, moduleName string, args ...string) (
 	return string(out), err
 }
 
-// createPullRequest creates a new pull request in Azure DevOps using the provided parameters.
-func createPullRequest(client adogit.Client, ctx context.Context, repoId *uuid.UUID, project string, sourceBranch, targetBranch, title, description string) (*adogit.GitPullRequest, error) {
-	repoIdStr := repoId.String()
-	pr := adogit.GitPullRequest{
-		Title:         &title,
-		Description:   &description,
-		SourceRefName: &sourceBranch,
-		TargetRefName: &targetBranch,
-	}
-	args := adogit.CreatePullRequestArgs{
-		GitPullRequestToCreate: &pr,
-		RepositoryId:           &repoIdStr,
-		Project:                &project,
-	}
-	return client.CreatePullRequest(ctx, args)
+// isActivePullRequestError reports whether a pull request creation failed because an active
+// pull request already exists for the source branch, e.g. on a re-run.
+func isActivePullRequestError(err error) bool {
+	return errors.Is(err, repohost.ErrPullRequestExists)
 }
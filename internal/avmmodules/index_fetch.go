@@ -0,0 +1,189 @@
+package avmmodules
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"go.uber.org/zap"
+)
+
+// indexCacheMeta is stored next to each cached index and holds the validators used to make
+// conditional requests for it.
+type indexCacheMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+// newIndexHTTPClient returns the HTTP client used to download module indexes. Requests time
+// out after config.HttpTimeout and go through the proxy from --http-proxy, or the standard
+// HTTPS_PROXY/HTTP_PROXY/NO_PROXY environment variables when no proxy flag is given.
+func newIndexHTTPClient() (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.HttpProxy != "" {
+		proxyURL, err := url.Parse(config.HttpProxy)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing HTTP proxy URL: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return &http.Client{Timeout: config.HttpTimeout, Transport: transport}, nil
+}
+
+// indexCachePaths returns the paths of the cached body and metadata for an index.
+func indexCachePaths(def IndexDefinition) (string, string) {
+	base := filepath.Join(config.IndexCacheDir, def.Name)
+	return base + ".csv", base + ".meta.json"
+}
+
+// readIndexCache returns the cached body and metadata for an index, or nil when the index has
+// not been cached yet.
+func readIndexCache(def IndexDefinition) ([]byte, *indexCacheMeta) {
+	if config.IndexCacheDir == "" {
+		return nil, nil
+	}
+	bodyPath, metaPath := indexCachePaths(def)
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return nil, nil
+	}
+	var meta indexCacheMeta
+	if data, err := os.ReadFile(metaPath); err == nil {
+		_ = json.Unmarshal(data, &meta)
+	}
+	return body, &meta
+}
+
+// writeIndexCache stores a freshly downloaded index and its validators.
+func writeIndexCache(def IndexDefinition, body []byte, meta indexCacheMeta) error {
+	if config.IndexCacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(config.IndexCacheDir, 0755); err != nil {
+		return err
+	}
+	bodyPath, metaPath := indexCachePaths(def)
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(bodyPath, body, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath, metaData, 0644)
+}
+
+// isRetryableStatus reports whether a response status is worth retrying.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// fetchIndex downloads a module index. Cached copies are revalidated with If-None-Match and
+// If-Modified-Since so an unchanged index is not downloaded again. Network errors, 429 and 5xx
+// responses are retried with exponential backoff; when every attempt fails the last good cached
+// copy is returned with a warning. Any other status, such as a 404 for a moved index, is an
+// error even when a cached copy exists.
+func fetchIndex(def IndexDefinition, logger *zap.Logger) ([]byte, error) {
+	client, err := newIndexHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	cached, meta := readIndexCache(def)
+	if meta != nil && meta.URL != def.URL {
+		// The definition now points somewhere else; the cached copy doesn't apply.
+		cached, meta = nil, nil
+	}
+
+	var lastErr error
+	delay := config.HttpRetryDelay
+	attempts := max(config.HttpRetries, 0) + 1
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			logger.Warn("Retrying module index download",
+				zap.String("index", def.Name),
+				zap.Int("attempt", attempt),
+				zap.Duration("delay", delay),
+				zap.Error(lastErr))
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodGet, def.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error building request for %s: %w", def.Name, err)
+		}
+		if cached != nil {
+			if meta.ETag != "" {
+				req.Header.Set("If-None-Match", meta.ETag)
+			}
+			if meta.LastModified != "" {
+				req.Header.Set("If-Modified-Since", meta.LastModified)
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("error fetching %s: %w", def.Name, err)
+			continue
+		}
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		logger.Info("Received HTTP response for module index",
+			zap.String("index", def.Name),
+			zap.Int("status_code", resp.StatusCode))
+
+		switch {
+		case resp.StatusCode == http.StatusNotModified && cached != nil:
+			logger.Info("Module index unchanged, using cached copy", zap.String("index", def.Name))
+			return cached, nil
+		case resp.StatusCode == http.StatusOK && readErr == nil:
+			newMeta := indexCacheMeta{
+				URL:          def.URL,
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+				FetchedAt:    time.Now().UTC(),
+			}
+			if err := writeIndexCache(def, body, newMeta); err != nil {
+				logger.Warn("Failed to cache module index", zap.String("index", def.Name), zap.Error(err))
+			}
+			return body, nil
+		case readErr != nil:
+			lastErr = fmt.Errorf("error reading %s response: %w", def.Name, readErr)
+		case isRetryableStatus(resp.StatusCode):
+			lastErr = fmt.Errorf("received retryable response for %s: %d", def.Name, resp.StatusCode)
+		default:
+			// The URL itself is wrong or refused, e.g. a moved index or a typo; the cached copy
+			// would hide that, so fail instead of falling back to it.
+			err := fmt.Errorf("received non-200 response for %s: %d", def.Name, resp.StatusCode)
+			logger.Error("Module index URL returned a non-retryable status",
+				zap.String("index", def.Name),
+				zap.String("url", def.URL),
+				zap.Int("status_code", resp.StatusCode))
+			return nil, err
+		}
+	}
+
+	if cached != nil {
+		logger.Warn("Module index remote is unreachable, falling back to last good cached copy",
+			zap.String("index", def.Name),
+			zap.String("url", def.URL),
+			zap.Time("fetchedAt", meta.FetchedAt),
+			zap.Error(lastErr))
+		return cached, nil
+	}
+	logger.Error("Failed to fetch module index from remote URL",
+		zap.String("index", def.Name),
+		zap.String("url", def.URL),
+		zap.Error(lastErr))
+	return nil, lastErr
+}
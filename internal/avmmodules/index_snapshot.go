@@ -0,0 +1,270 @@
+package avmmodules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+	"go.uber.org/zap"
+)
+
+// IndexSnapshot is the persisted form of the parsed index, used to work out how the AVM index
+// changed between runs.
+type IndexSnapshot struct {
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Modules     *ModulesStruct `json:"modules"`
+}
+
+// IndexFieldChange records a single field of a module that changed between snapshots.
+type IndexFieldChange struct {
+	Module string `json:"module"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+// IndexDiff is the difference between the previous index snapshot and the current index.
+type IndexDiff struct {
+	PreviousSnapshotAt time.Time          `json:"previousSnapshotAt"`
+	Added              []string           `json:"added,omitempty"`
+	Removed            []string           `json:"removed,omitempty"`
+	StatusChanged      []IndexFieldChange `json:"statusChanged,omitempty"`
+	RepoURLChanged     []IndexFieldChange `json:"repoUrlChanged,omitempty"`
+	OwnersChanged      []IndexFieldChange `json:"ownersChanged,omitempty"`
+}
+
+// IsEmpty reports whether the index is unchanged since the previous snapshot.
+func (d *IndexDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.StatusChanged) == 0 &&
+		len(d.RepoURLChanged) == 0 && len(d.OwnersChanged) == 0
+}
+
+// Markdown renders the diff as a Markdown section body.
+func (d *IndexDiff) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("Previous snapshot taken " + d.PreviousSnapshotAt.Format(time.RFC3339) + "\n\n")
+	if d.IsEmpty() {
+		sb.WriteString("No changes.\n")
+		return sb.String()
+	}
+	writeList := func(title string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		sb.WriteString("### " + title + "\n\n")
+		for _, name := range names {
+			sb.WriteString("- " + name + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	writeChanges := func(title string, changes []IndexFieldChange) {
+		if len(changes) == 0 {
+			return
+		}
+		sb.WriteString("### " + title + "\n\n| Module | Old | New |\n| --- | --- | --- |\n")
+		for _, change := range changes {
+			sb.WriteString("| " + change.Module + " | " + change.Old + " | " + change.New + " |\n")
+		}
+		sb.WriteString("\n")
+	}
+	writeList("Added", d.Added)
+	writeList("Removed", d.Removed)
+	writeChanges("Status changed", d.StatusChanged)
+	writeChanges("Repository URL changed", d.RepoURLChanged)
+	writeChanges("Owners changed", d.OwnersChanged)
+	return sb.String()
+}
+
+// indexSnapshotPath returns where the snapshot is read from and written to. When the snapshot
+// is maintained through a pull request it lives inside the ADO source repository.
+func indexSnapshotPath() string {
+	if config.IndexSnapshotPullRequest {
+		return filepath.Join(config.SourceRepoPath, config.IndexSnapshotPath)
+	}
+	return config.IndexSnapshotPath
+}
+
+// readIndexSnapshot loads the previous snapshot. Returns nil without an error when no snapshot
+// has been written yet.
+func readIndexSnapshot(path string) (*IndexSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading index snapshot: %w", err)
+	}
+	var snapshot IndexSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("error parsing index snapshot %s: %w", path, err)
+	}
+	if snapshot.Modules == nil {
+		snapshot.Modules = &ModulesStruct{}
+	}
+	return &snapshot, nil
+}
+
+// writeIndexSnapshot saves the current index as the snapshot for the next run.
+func writeIndexSnapshot(path string, modules *ModulesStruct) error {
+	data, err := json.MarshalIndent(IndexSnapshot{GeneratedAt: time.Now().UTC(), Modules: modules}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding index snapshot: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating index snapshot directory: %w", err)
+		}
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// diffIndex compares the modules of the previous snapshot against the current index.
+func diffIndex(previous *IndexSnapshot, current *ModulesStruct) *IndexDiff {
+	diff := &IndexDiff{PreviousSnapshotAt: previous.GeneratedAt}
+	before := map[string]Module{}
+	for _, module := range previous.Modules.allModules() {
+		before[module.GetModuleName()] = module
+	}
+	after := map[string]Module{}
+	for _, module := range current.allModules() {
+		after[module.GetModuleName()] = module
+	}
+
+	for name, module := range after {
+		old, ok := before[name]
+		if !ok {
+			diff.Added = append(diff.Added, name)
+			continue
+		}
+		if old.GetModuleStatus() != module.GetModuleStatus() {
+			diff.StatusChanged = append(diff.StatusChanged, IndexFieldChange{Module: name, Old: old.GetModuleStatus(), New: module.GetModuleStatus()})
+		}
+		if old.GetRepoURL() != module.GetRepoURL() {
+			diff.RepoURLChanged = append(diff.RepoURLChanged, IndexFieldChange{Module: name, Old: old.GetRepoURL(), New: module.GetRepoURL()})
+		}
+		oldOwners := strings.Join(old.GetOwners(), ", ")
+		newOwners := strings.Join(module.GetOwners(), ", ")
+		if oldOwners != newOwners {
+			diff.OwnersChanged = append(diff.OwnersChanged, IndexFieldChange{Module: name, Old: oldOwners, New: newOwners})
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	for _, changes := range [][]IndexFieldChange{diff.StatusChanged, diff.RepoURLChanged, diff.OwnersChanged} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Module < changes[j].Module })
+	}
+	return diff
+}
+
+// DiffIndexSnapshot compares the current index against the snapshot persisted by the previous
+// run and records the result in the run report. It is a no-op when no snapshot path is
+// configured, and records nothing on the first run when no snapshot exists yet.
+func (p *ModuleProcessor) DiffIndexSnapshot() error {
+	if config.IndexSnapshotPath == "" {
+		return nil
+	}
+	path := indexSnapshotPath()
+	previous, err := readIndexSnapshot(path)
+	if err != nil {
+		p.Logger.Error("Failed to read index snapshot", zap.String("path", path), zap.Error(err))
+		return err
+	}
+	if previous == nil {
+		p.Logger.Info("No previous index snapshot found, skipping index diff", zap.String("path", path))
+		return nil
+	}
+	diff := diffIndex(previous, p.Modules)
+	p.Logger.Info("Compared index against previous snapshot",
+		zap.String("path", path),
+		zap.Time("previousSnapshotAt", previous.GeneratedAt),
+		zap.Strings("added", diff.Added),
+		zap.Strings("removed", diff.Removed),
+		zap.Int("statusChanged", len(diff.StatusChanged)),
+		zap.Int("repoUrlChanged", len(diff.RepoURLChanged)),
+		zap.Int("ownersChanged", len(diff.OwnersChanged)))
+	p.Report.SetIndexDiff(diff)
+	return nil
+}
+
+// UpdateIndexSnapshot persists the current index as the snapshot for the next run. When the
+// snapshot is kept in the ADO source repository the update is committed to a dedicated branch
+// and proposed through a pull request; otherwise the file is written in place.
+func (p *ModuleProcessor) UpdateIndexSnapshot() error {
+	if config.IndexSnapshotPath == "" {
+		return nil
+	}
+	path := indexSnapshotPath()
+	if !config.IndexSnapshotPullRequest {
+		if err := writeIndexSnapshot(path, p.Modules); err != nil {
+			p.Logger.Error("Failed to write index snapshot", zap.String("path", path), zap.Error(err))
+			return err
+		}
+		p.Logger.Info("Wrote index snapshot", zap.String("path", path))
+		return nil
+	}
+
+	if p.Report.IndexDiff != nil && p.Report.IndexDiff.IsEmpty() {
+		p.Logger.Info("Index unchanged since last snapshot, skipping snapshot pull request")
+		return nil
+	}
+	localRepoPath := config.SourceRepoPath
+	logName := "index-snapshot"
+	branchName := config.BranchPrefix + "index-snapshot"
+	if err := checkoutSyncBranch(localRepoPath, branchName, config.DefaultBranchName, logName, p.Logger); err != nil {
+		return err
+	}
+	if err := writeIndexSnapshot(path, p.Modules); err != nil {
+		p.Logger.Error("Failed to write index snapshot", zap.String("path", path), zap.Error(err))
+		return err
+	}
+	if out, err := runGit(localRepoPath, p.Logger, logName, "add", "-A", "."); err != nil {
+		p.Logger.Error("Failed to stage changes", zap.String("output", out), zap.Error(err))
+		return err
+	}
+	statusOut, err := runGit(localRepoPath, p.Logger, logName, "status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(statusOut) == "" {
+		p.Logger.Info("Index snapshot unchanged, skipping snapshot pull request")
+		return checkoutDefaultBranch(localRepoPath, p.Logger)
+	}
+	commitMsg := "chore(index): Updated AVM index snapshot"
+	if out, err := runGit(localRepoPath, p.Logger, logName, "commit", "-m", commitMsg); err != nil {
+		p.Logger.Error("Failed to commit changes", zap.String("output", out), zap.Error(err))
+		return err
+	}
+	p.recordCommitSignature(localRepoPath, logName)
+	if err := pushBranch(localRepoPath, branchName, logName, p.Logger); err != nil {
+		return err
+	}
+
+	description := "This is an automated pull request to update the AVM index snapshot used to report index changes between runs."
+	if p.Report.IndexDiff != nil {
+		description += "\n\n" + p.Report.IndexDiff.Markdown()
+	}
+	pr, err := createOrUpdatePullRequest(p.Host, p.Context, repohost.PullRequestOptions{
+		SourceBranch: branchName,
+		TargetBranch: config.DefaultBranchName,
+		Title:        commitMsg,
+		Description:  description,
+	}, "", logName, p.Logger)
+	if err != nil {
+		return err
+	}
+	if pr != nil {
+		p.Logger.Info("Index snapshot pull request ready", zap.Int("prId", pr.ID))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package avmmodules
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"go.uber.org/zap"
+)
+
+// Severities of an index validation issue.
+const (
+	IssueError   = "error"
+	IssueWarning = "warning"
+)
+
+// knownModuleStatuses are the module statuses AVM publishes in its indexes.
+var knownModuleStatuses = []string{"Available", "Proposed", "Orphaned", "Deprecated", "Provisional", "Planned"}
+
+// requiredIndexColumns must be present and non-blank for every row of an index.
+var requiredIndexColumns = []string{"ModuleName", "ModuleStatus", "RepoURL"}
+
+// recordTypeNamePrefixes is the module name prefix expected for each AVM record type.
+var recordTypeNamePrefixes = map[string]string{
+	RecordTypeResource:      "avm-res-",
+	RecordTypePattern:       "avm-ptn-",
+	RecordTypeUtility:       "avm-utl-",
+	RecordTypeBicepResource: "avm/res/",
+	RecordTypeBicepPattern:  "avm/ptn/",
+}
+
+// IndexIssue is a problem found while validating a module index.
+type IndexIssue struct {
+	Severity string `json:"severity"`
+	Index    string `json:"index"`
+	Module   string `json:"module,omitempty"`
+	Message  string `json:"message"`
+}
+
+// String formats the issue for logs and error messages.
+func (i IndexIssue) String() string {
+	if i.Module == "" {
+		return i.Index + ": " + i.Message
+	}
+	return i.Index + " " + i.Module + ": " + i.Message
+}
+
+// missingColumnIssues compares the header of a CSV index against the csv columns of T. Missing
+// required columns are errors; other missing columns are warnings, except for YAML indexes
+// where optional keys are routinely left out.
+func missingColumnIssues[T any](def IndexDefinition, data []byte) []IndexIssue {
+	header, err := csv.NewReader(bytes.NewReader(data)).Read()
+	if err != nil {
+		return []IndexIssue{{Severity: IssueError, Index: def.Name, Message: "index has no header row"}}
+	}
+	present := map[string]bool{}
+	for _, column := range header {
+		present[strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))] = true
+	}
+
+	var issues []IndexIssue
+	recordType := reflect.TypeFor[T]()
+	for i := range recordType.NumField() {
+		column := recordType.Field(i).Tag.Get("csv")
+		if column == "" || column == "-" || present[column] {
+			continue
+		}
+		switch {
+		case slices.Contains(requiredIndexColumns, column):
+			issues = append(issues, IndexIssue{Severity: IssueError, Index: def.Name, Message: "required column " + column + " is missing"})
+		case !isYamlIndex(def):
+			issues = append(issues, IndexIssue{Severity: IssueWarning, Index: def.Name, Message: "column " + column + " is missing"})
+		}
+	}
+	return issues
+}
+
+// isValidRepoURL reports whether a repository URL looks like something git can clone: an
+// http(s), ssh or git URL with a host, or an scp-style git@host:path address.
+func isValidRepoURL(repoURL string) bool {
+	if strings.HasPrefix(repoURL, "git@") {
+		return strings.Contains(repoURL, ":")
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https", "http", "ssh", "git":
+		return true
+	}
+	return false
+}
+
+// firstPublishedIn returns the FirstPublishedIn value of the module record types that carry it.
+func firstPublishedIn(module Module) string {
+	switch m := module.(type) {
+	case ResourceModulesStruct:
+		return m.FirstPublishedIn
+	case PatternModulesStruct:
+		return m.FirstPublishedIn
+	case UtilityModulesStruct:
+		return m.FirstPublishedIn
+	case BicepResourceModulesStruct:
+		return m.FirstPublishedIn
+	case BicepPatternModulesStruct:
+		return m.FirstPublishedIn
+	}
+	return ""
+}
+
+// validateIndex checks the loaded modules for blank required fields, duplicate names, malformed
+// repository URLs, names whose prefix doesn't match the record type of their index, unknown
+// statuses and malformed FirstPublishedIn values. Prefix checks only apply to AVM indexes since
+// private modules may be named freely.
+func validateIndex(modules *ModulesStruct, definitions []IndexDefinition) []IndexIssue {
+	recordTypes := map[string]string{}
+	for _, def := range definitions {
+		recordTypes[def.Name] = def.RecordType
+	}
+
+	var issues []IndexIssue
+	seen := map[string]string{}
+	for _, module := range modules.allModules() {
+		index := module.GetIndexName()
+		name := module.GetModuleName()
+		add := func(severity, message string) {
+			issues = append(issues, IndexIssue{Severity: severity, Index: index, Module: name, Message: message})
+		}
+
+		if strings.TrimSpace(name) == "" {
+			add(IssueError, "ModuleName is blank")
+			continue
+		}
+		if previous, ok := seen[name]; ok {
+			add(IssueError, "duplicate ModuleName, also defined in "+previous)
+		}
+		seen[name] = index
+
+		if strings.TrimSpace(module.GetModuleStatus()) == "" {
+			add(IssueError, "ModuleStatus is blank")
+		} else if !slices.Contains(knownModuleStatuses, module.GetModuleStatus()) {
+			add(IssueWarning, "unknown ModuleStatus "+module.GetModuleStatus())
+		}
+
+		switch repoURL := strings.TrimSpace(module.GetRepoURL()); {
+		case repoURL == "":
+			add(IssueError, "RepoURL is blank")
+		case !isValidRepoURL(repoURL):
+			add(IssueError, "RepoURL "+repoURL+" is not a valid git URL")
+		}
+
+		if prefix, ok := recordTypeNamePrefixes[recordTypes[index]]; ok && module.GetOrigin() == OriginAvm && !strings.HasPrefix(name, prefix) {
+			add(IssueError, fmt.Sprintf("ModuleName does not start with %s expected for %s indexes", prefix, recordTypes[index]))
+		}
+
+		if published := strings.TrimSpace(firstPublishedIn(module)); published != "" {
+			if _, err := time.Parse("2006-01", published); err != nil {
+				add(IssueWarning, "FirstPublishedIn "+published+" is not in YYYY-MM format")
+			}
+		}
+	}
+	return issues
+}
+
+// checkIndexIssues validates the loaded index, appends the issues to those found while loading
+// and logs them. With --strict-index any error fails the run.
+func checkIndexIssues(modules *ModulesStruct, definitions []IndexDefinition, logger *zap.Logger) error {
+	modules.Issues = append(modules.Issues, validateIndex(modules, definitions)...)
+	var errs []string
+	for _, issue := range modules.Issues {
+		if issue.Severity == IssueError {
+			errs = append(errs, issue.String())
+			logger.Error("Module index validation error", zap.String("index", issue.Index), zap.String("module", issue.Module), zap.String("issue", issue.Message))
+		} else {
+			logger.Warn("Module index validation warning", zap.String("index", issue.Index), zap.String("module", issue.Module), zap.String("issue", issue.Message))
+		}
+	}
+	logger.Info("Validated module index",
+		zap.Int("errors", len(errs)),
+		zap.Int("warnings", len(modules.Issues)-len(errs)))
+	if config.StrictIndex && len(errs) > 0 {
+		return fmt.Errorf("module index has %d validation errors:\n  %s", len(errs), strings.Join(errs, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package avmmodules
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"go.uber.org/zap"
+)
+
+// moduleVariable is the part of a Terraform variable declaration that callers depend on.
+type moduleVariable struct {
+	Type       string
+	Default    string
+	HasDefault bool
+}
+
+// moduleInterface is the set of variables and outputs declared by a Terraform module.
+type moduleInterface struct {
+	Variables map[string]moduleVariable
+	Outputs   map[string]bool
+}
+
+// InterfaceChange records a variable whose type or default changed between synced versions.
+type InterfaceChange struct {
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// InterfaceDiff is the difference between the Terraform interface of the previously synced
+// version of a module and the new one.
+type InterfaceDiff struct {
+	Module                 string            `json:"module"`
+	AddedVariables         []string          `json:"addedVariables,omitempty"`
+	AddedRequiredVariables []string          `json:"addedRequiredVariables,omitempty"`
+	RemovedVariables       []string          `json:"removedVariables,omitempty"`
+	TypeChanged            []InterfaceChange `json:"typeChanged,omitempty"`
+	DefaultChanged         []InterfaceChange `json:"defaultChanged,omitempty"`
+	RemovedOutputs         []string          `json:"removedOutputs,omitempty"`
+}
+
+// IsEmpty reports whether the module interface is unchanged.
+func (d *InterfaceDiff) IsEmpty() bool {
+	return len(d.AddedVariables) == 0 && !d.IsBreaking()
+}
+
+// IsBreaking reports whether the diff contains a change that can break callers: anything but
+// new optional variables.
+func (d *InterfaceDiff) IsBreaking() bool {
+	return len(d.AddedRequiredVariables) > 0 || len(d.RemovedVariables) > 0 || len(d.TypeChanged) > 0 ||
+		len(d.DefaultChanged) > 0 || len(d.RemovedOutputs) > 0
+}
+
+// Markdown renders the diff as a Markdown table.
+func (d *InterfaceDiff) Markdown() string {
+	if d.IsEmpty() {
+		return "No interface changes.\n"
+	}
+	var sb strings.Builder
+	sb.WriteString("| Change | Name | Old | New |\n| --- | --- | --- | --- |\n")
+	writeNames := func(change string, names []string) {
+		for _, name := range names {
+			sb.WriteString("| " + change + " | `" + name + "` | | |\n")
+		}
+	}
+	writeChanges := func(change string, changes []InterfaceChange) {
+		for _, c := range changes {
+			sb.WriteString("| " + change + " | `" + c.Name + "` | " + markdownCode(c.Old) + " | " + markdownCode(c.New) + " |\n")
+		}
+	}
+	writeNames("Variable removed", d.RemovedVariables)
+	writeNames("Required variable added", d.AddedRequiredVariables)
+	writeChanges("Variable type changed", d.TypeChanged)
+	writeChanges("Variable default changed", d.DefaultChanged)
+	writeNames("Output removed", d.RemovedOutputs)
+	writeNames("Optional variable added", d.AddedVariables)
+	return sb.String()
+}
+
+// markdownCode renders an HCL expression as inline code for a Markdown table cell.
+func markdownCode(expr string) string {
+	if expr == "" {
+		return "_none_"
+	}
+	return "`" + strings.ReplaceAll(expr, "|", "\\|") + "`"
+}
+
+// readModuleInterface parses the variable and output blocks of the .tf files at the root of a
+// Terraform module. Returns nil when dir has no .tf files, e.g. a Bicep module or a module that
+// has not been synced before. Files that fail to parse are skipped with a warning.
+func readModuleInterface(dir string, moduleName string, logger *zap.Logger) *moduleInterface {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if len(files) == 0 {
+		return nil
+	}
+	sources := map[string][]byte{}
+	for _, path := range files {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			logger.Warn("Failed to read .tf file for interface diff", zap.String("module", moduleName), zap.String("file", path), zap.Error(err))
+			continue
+		}
+		sources[path] = src
+	}
+	return parseModuleInterface(sources, moduleName, logger)
+}
+
+// parseModuleInterface parses the variable and output blocks of the given .tf file sources,
+// keyed by file path.
+func parseModuleInterface(sources map[string][]byte, moduleName string, logger *zap.Logger) *moduleInterface {
+	paths := make([]string, 0, len(sources))
+	for path := range sources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	iface := &moduleInterface{Variables: map[string]moduleVariable{}, Outputs: map[string]bool{}}
+	for _, path := range paths {
+		src := sources[path]
+		file, diags := hclsyntax.ParseConfig(src, path, hcl.InitialPos)
+		if diags.HasErrors() {
+			logger.Warn("Failed to parse .tf file for interface diff", zap.String("module", moduleName), zap.String("file", path), zap.String("error", diags.Error()))
+			continue
+		}
+		body, ok := file.Body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+		for _, block := range body.Blocks {
+			if len(block.Labels) != 1 {
+				continue
+			}
+			switch block.Type {
+			case "variable":
+				variable := moduleVariable{}
+				if attr, ok := block.Body.Attributes["type"]; ok {
+					variable.Type = expressionSource(src, attr.Expr)
+				}
+				if attr, ok := block.Body.Attributes["default"]; ok {
+					variable.Default = expressionSource(src, attr.Expr)
+					variable.HasDefault = true
+				}
+				iface.Variables[block.Labels[0]] = variable
+			case "output":
+				iface.Outputs[block.Labels[0]] = true
+			}
+		}
+	}
+	return iface
+}
+
+// expressionSource returns the source text of an expression with whitespace collapsed, so
+// reformatting alone doesn't register as a change.
+func expressionSource(src []byte, expr hclsyntax.Expression) string {
+	return strings.Join(strings.Fields(string(expr.Range().SliceBytes(src))), " ")
+}
+
+// diffModuleInterfaces compares the previous and new interface of a module. Returns nil when
+// either side is unknown.
+func diffModuleInterfaces(moduleName string, previous *moduleInterface, current *moduleInterface) *InterfaceDiff {
+	if previous == nil || current == nil {
+		return nil
+	}
+	diff := &InterfaceDiff{Module: moduleName}
+	for name, variable := range current.Variables {
+		old, ok := previous.Variables[name]
+		switch {
+		case !ok && variable.HasDefault:
+			diff.AddedVariables = append(diff.AddedVariables, name)
+		case !ok:
+			diff.AddedRequiredVariables = append(diff.AddedRequiredVariables, name)
+		default:
+			if old.Type != variable.Type {
+				diff.TypeChanged = append(diff.TypeChanged, InterfaceChange{Name: name, Old: old.Type, New: variable.Type})
+			}
+			if old.HasDefault != variable.HasDefault || old.Default != variable.Default {
+				diff.DefaultChanged = append(diff.DefaultChanged, InterfaceChange{Name: name, Old: old.Default, New: variable.Default})
+			}
+		}
+	}
+	for name := range previous.Variables {
+		if _, ok := current.Variables[name]; !ok {
+			diff.RemovedVariables = append(diff.RemovedVariables, name)
+		}
+	}
+	for name := range previous.Outputs {
+		if !current.Outputs[name] {
+			diff.RemovedOutputs = append(diff.RemovedOutputs, name)
+		}
+	}
+	sort.Strings(diff.AddedVariables)
+	sort.Strings(diff.AddedRequiredVariables)
+	sort.Strings(diff.RemovedVariables)
+	sort.Strings(diff.RemovedOutputs)
+	sort.Slice(diff.TypeChanged, func(i, j int) bool { return diff.TypeChanged[i].Name < diff.TypeChanged[j].Name })
+	sort.Slice(diff.DefaultChanged, func(i, j int) bool { return diff.DefaultChanged[i].Name < diff.DefaultChanged[j].Name })
+	return diff
+}
@@ -0,0 +1,244 @@
+package avmmodules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+	"go.uber.org/zap"
+)
+
+// lifecycleBannerMarker identifies the README banner added by the lifecycle phase so it is
+// only ever added once.
+const lifecycleBannerMarker = "<!-- avm-module-sync:lifecycle -->"
+
+// isLifecycleStatus checks if the given status is one the lifecycle phase acts on.
+func isLifecycleStatus(status string) bool {
+	for _, lifecycle := range config.LifecycleStatuses {
+		if status == lifecycle {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessModuleLifecycle compares the index against the modules present in the internal
+// repository. For every internal module whose upstream module has moved to a lifecycle status
+// (Deprecated or Orphaned by default) it opens a pull request that marks the module in its
+// .avm-version file and README, requests review from the module's mapped reviewers, and lists
+// its upstream owners and the internal modules that still reference it. Modules whose target
+// branch already carries the marker are skipped.
+func (p *ModuleProcessor) ProcessModuleLifecycle() error {
+	localRepoPath := config.SourceRepoPath
+	p.Logger.Info("[Lifecycle] Comparing index against internal modules")
+	internals, err := listInternalModules(localRepoPath, p.Logger)
+	if err != nil {
+		p.Logger.Error("Failed to list internal modules", zap.Error(err))
+		return err
+	}
+
+	var failed []string
+	for _, internal := range internals {
+		upstream, _ := resolveUpstreamModule(internal, p.Modules)
+		if upstream == nil || !isLifecycleStatus(upstream.GetModuleStatus()) {
+			continue
+		}
+		status := upstream.GetModuleStatus()
+		targetBranch := p.TargetBranches.TargetBranchFor(upstream)
+		versionPath := filepath.Join(moduleDirPath(localRepoPath, internal.ChildPath, internal.Name), config.AvmVersionFileName)
+		data, _ := readFileAtRef(localRepoPath, "origin/"+targetBranch, versionPath)
+		if parseAvmVersionValue(string(data), "lifecycle") == status {
+			p.Logger.Info("Module already marked with upstream lifecycle status, skipping",
+				zap.String("module", internal.Name),
+				zap.String("targetBranch", targetBranch),
+				zap.String("status", status))
+			continue
+		}
+		references, err := findModuleReferences(localRepoPath, internal, upstream.GetModuleName(), p.Logger)
+		if err != nil {
+			p.Logger.Warn("Could not search internal modules for references", zap.String("module", internal.Name), zap.Error(err))
+		}
+		p.Logger.Warn("Upstream module has changed lifecycle status",
+			zap.String("module", internal.Name),
+			zap.String("avmName", upstream.GetModuleName()),
+			zap.String("status", status),
+			zap.Strings("referencedBy", references))
+		if err := p.markModuleLifecycle(localRepoPath, internal, upstream, references); err != nil {
+			failed = append(failed, internal.Name)
+		}
+	}
+
+	if err := checkoutDefaultBranch(localRepoPath, p.Logger); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to mark lifecycle status for modules: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// markModuleLifecycle creates the branch, commit and pull request that mark a single internal
+// module with its upstream lifecycle status.
+func (p *ModuleProcessor) markModuleLifecycle(localRepoPath string, internal internalModule, upstream Module, references []string) error {
+	logger := p.Logger
+	moduleName := internal.Name
+	status := upstream.GetModuleStatus()
+	branchName := config.BranchPrefix + "lifecycle/" + moduleName
+	targetBranch := p.TargetBranches.TargetBranchFor(upstream)
+
+	if err := checkoutSyncBranch(localRepoPath, branchName, targetBranch, moduleName, logger); err != nil {
+		return err
+	}
+
+	moduleDir := moduleDirPath(localRepoPath, internal.ChildPath, moduleName)
+	versionPath := filepath.Join(moduleDir, config.AvmVersionFileName)
+	data, err := os.ReadFile(versionPath)
+	if err != nil {
+		logger.Error("Failed to read AVM version file", zap.String("module", moduleName), zap.Error(err))
+		return err
+	}
+	content := strings.TrimRight(string(data), "\n") + "\n"
+	if existing := parseAvmVersionValue(content, "lifecycle"); existing != "" {
+		content = strings.Replace(content, "lifecycle="+existing+"\n", "", 1)
+	}
+	content += "lifecycle=" + status + "\n"
+	if err := os.WriteFile(versionPath, []byte(content), 0644); err != nil {
+		logger.Error("Failed to write AVM version file", zap.String("module", moduleName), zap.Error(err))
+		return err
+	}
+
+	if err := addLifecycleBanner(filepath.Join(moduleDir, "README.md"), upstream); err != nil {
+		logger.Error("Failed to add lifecycle banner to README", zap.String("module", moduleName), zap.Error(err))
+		return err
+	}
+
+	if out, err := runGit(localRepoPath, logger, moduleName, "add", "-A", "."); err != nil {
+		logger.Error("Failed to stage changes", zap.String("module", moduleName), zap.String("output", out), zap.Error(err))
+		return err
+	}
+	commitMsg := "chore(module): Marked AVM module " + moduleName + " as " + status
+	if out, err := runGit(localRepoPath, logger, moduleName, "commit", "-m", commitMsg); err != nil {
+		logger.Error("Failed to commit changes", zap.String("module", moduleName), zap.String("output", out), zap.Error(err))
+		return err
+	}
+	p.recordCommitSignature(localRepoPath, moduleName)
+	if err := pushBranch(localRepoPath, branchName, moduleName, logger); err != nil {
+		return err
+	}
+
+	description := "The upstream AVM module " + upstream.GetModuleName() + " (" + upstream.GetRepoURL() + ") is now " + status +
+		" and will no longer be synced unless it is explicitly allowed.\n\n" +
+		"This pull request marks " + moduleName + " in its " + config.AvmVersionFileName + " file and README."
+	if owners := upstream.GetOwners(); len(owners) > 0 {
+		description += "\n\nUpstream owners: `" + strings.Join(owners, "`, `") + "`"
+	}
+	if len(references) > 0 {
+		description += "\n\nInternal modules that still reference it:\n- " + strings.Join(references, "\n- ")
+	} else {
+		description += "\n\nNo internal modules reference it."
+	}
+	pr, err := createOrUpdatePullRequest(p.Host, p.Context, repohost.PullRequestOptions{
+		SourceBranch: branchName,
+		TargetBranch: targetBranch,
+		Title:        commitMsg,
+		Description:  description,
+		Reviewers:    lifecycleReviewers(p.Reviewers, upstream),
+	}, "", moduleName, logger)
+	if err != nil {
+		return err
+	}
+	if pr != nil {
+		logger.Info("Lifecycle pull request ready", zap.String("module", moduleName), zap.String("status", status), zap.Int("prId", pr.ID))
+	}
+	return nil
+}
+
+// lifecycleReviewers returns the reviewers of a lifecycle pull request: the module's reviewers
+// from the reviewer mapping, or the --lifecycle-reviewers list when no rule matches it.
+func lifecycleReviewers(mapping *ReviewerMapping, upstream Module) []repohost.Reviewer {
+	if reviewers := mapping.ReviewersFor(upstream); len(reviewers) > 0 {
+		return reviewers
+	}
+	return optionalReviewers(config.LifecycleReviewers)
+}
+
+// addLifecycleBanner prepends a warning banner about the upstream lifecycle status to a module
+// README, creating the README when the module does not ship one.
+func addLifecycleBanner(readmePath string, upstream Module) error {
+	existing, err := os.ReadFile(readmePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if strings.Contains(string(existing), lifecycleBannerMarker) {
+		return nil
+	}
+	banner := lifecycleBannerMarker + "\n" +
+		"> [!WARNING]\n" +
+		"> The upstream AVM module `" + upstream.GetModuleName() + "` is **" + upstream.GetModuleStatus() + "** and is no longer being synced.\n" +
+		"> See " + upstream.GetRepoURL() + " for details.\n\n"
+	return os.WriteFile(readmePath, append([]byte(banner), existing...), 0644)
+}
+
+// findModuleReferences returns the internal modules in every module root, other than the module
+// itself, whose .tf or .bicep files reference the given module either through the public
+// registry or through its rewritten Artifactory or Bicep registry source.
+func findModuleReferences(localRepoPath string, internal internalModule, avmName string, logger *zap.Logger) ([]string, error) {
+	needles := []string{"Azure/" + avmName + "/", "br/public:" + avmName + ":", "br:mcr.microsoft.com/bicep/" + avmName + ":"}
+	for _, source := range []struct{ template, prefix, suffix string }{
+		{config.ArtifactorySourceTemplate, `"`, `"`},
+		{config.ArtifactorySourceTemplate, `"`, `//`},
+		{config.BicepRegistrySourceTemplate, `'`, `:`},
+	} {
+		if source.template == "" {
+			continue
+		}
+		tmpl, err := template.New("module-source").Parse(source.template)
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, struct{ ModuleName string }{ModuleName: internal.Name}); err != nil {
+			return nil, err
+		}
+		needles = append(needles, source.prefix+sb.String()+source.suffix)
+	}
+
+	self := moduleDirPath(localRepoPath, internal.ChildPath, internal.Name)
+	found := map[string]bool{}
+	err := walkModuleRoots(localRepoPath, func(childPath string, root string, path string, info os.FileInfo) error {
+		rel, _ := filepath.Rel(root, path)
+		owner := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+		if info.IsDir() {
+			if path == self {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext != ".tf" && ext != ".bicep" || found[owner] {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logger.Warn("Could not read module file while searching for references", zap.String("file", path), zap.Error(err))
+			return nil
+		}
+		for _, needle := range needles {
+			if strings.Contains(string(data), needle) {
+				found[owner] = true
+				break
+			}
+		}
+		return nil
+	})
+	references := make([]string, 0, len(found))
+	for name := range found {
+		references = append(references, name)
+	}
+	sort.Strings(references)
+	return references, err
+}
@@ -1,169 +1,310 @@
 package avmmodules
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
+	"path"
+	"sort"
+	"strings"
 
 	"github.com/gocarina/gocsv"
 	"github.com/theonlyway/avm-module-sync/internal/config"
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
 )
 
-func getResourceModules(logger *zap.Logger) ([]ResourceModulesStruct, error) {
-	var modules []ResourceModulesStruct
-	if config.ReadLocalCsvFile {
-		filePath := config.LocalCsvPath + "/TerraformResourceModules.csv"
-		logger.Info("Reading resource modules from local CSV",
-			zap.String("file_path", filePath))
-		file, err := openCsvFile(filePath)
-		if err != nil {
-			logger.Error("Failed to open local resource modules CSV",
-				zap.String("file_path", filePath),
-				zap.Error(err))
-			return nil, fmt.Errorf("error opening local resource modules CSV: %w", err)
-		}
-		defer file.Close()
-		if err := gocsv.Unmarshal(file, &modules); err != nil {
-			logger.Error("Failed to parse local resource modules CSV",
-				zap.String("file_path", filePath),
-				zap.Error(err))
-			return nil, fmt.Errorf("error parsing local CSV: %w", err)
+// Record types an index definition can parse its rows into.
+const (
+	RecordTypeResource = "resource"
+	RecordTypePattern  = "pattern"
+	RecordTypeUtility  = "utility"
+
+	RecordTypeBicepResource = "bicep-resource"
+	RecordTypeBicepPattern  = "bicep-pattern"
+)
+
+// Origin labels recorded on every module so upstream AVM modules can be told apart from
+// modules mirrored from a private index.
+const (
+	OriginAvm     = "avm"
+	OriginPrivate = "private"
+)
+
+// privateIndexName is the name of the index definition built from --private-index.
+const privateIndexName = "PrivateModules"
+
+// IndexDefinition describes one module index: where it is loaded from, which record type its
+// rows are parsed into (resource, pattern, utility, bicep-resource or bicep-pattern), and the
+// template used to transform its module names. An empty
+// NameTemplate falls back to the name template configured for the record type. Indexes are
+// CSV unless the path or URL ends in .yaml or .yml. Origin labels the modules of the index and
+// defaults to OriginAvm.
+type IndexDefinition struct {
+	Name         string `json:"name"`
+	URL          string `json:"url,omitempty"`
+	LocalPath    string `json:"localPath,omitempty"`
+	RecordType   string `json:"recordType"`
+	NameTemplate string `json:"nameTemplate,omitempty"`
+	Origin       string `json:"origin,omitempty"`
+}
+
+// recordLoaders maps each record type to the function that loads an index of that type and
+// appends its rows to the matching slice of ModulesStruct.
+var recordLoaders = map[string]func(*ModulesStruct, IndexDefinition, *zap.Logger) error{
+	RecordTypeResource: func(modules *ModulesStruct, def IndexDefinition, logger *zap.Logger) error {
+		return loadRecords(modules, &modules.ResourceModules, def, logger)
+	},
+	RecordTypePattern: func(modules *ModulesStruct, def IndexDefinition, logger *zap.Logger) error {
+		return loadRecords(modules, &modules.PatternModules, def, logger)
+	},
+	RecordTypeUtility: func(modules *ModulesStruct, def IndexDefinition, logger *zap.Logger) error {
+		return loadRecords(modules, &modules.UtilityModules, def, logger)
+	},
+	RecordTypeBicepResource: func(modules *ModulesStruct, def IndexDefinition, logger *zap.Logger) error {
+		return loadRecords(modules, &modules.BicepResourceModules, def, logger)
+	},
+	RecordTypeBicepPattern: func(modules *ModulesStruct, def IndexDefinition, logger *zap.Logger) error {
+		return loadRecords(modules, &modules.BicepPatternModules, def, logger)
+	},
+}
+
+// indexRow is implemented by pointers to the row types of module indexes, so loaded rows can be
+// labelled with the index they came from.
+type indexRow[T any] interface {
+	*T
+	setIndexSource(index string, origin string)
+}
+
+// loadRecords loads the index described by def, labels its rows with the index name and origin,
+// and appends them to dst and their issues to modules.Issues.
+func loadRecords[T any, PT indexRow[T]](modules *ModulesStruct, dst *[]T, def IndexDefinition, logger *zap.Logger) error {
+	rows, issues, err := loadIndex[T](def, logger)
+	for i := range rows {
+		PT(&rows[i]).setIndexSource(def.Name, def.Origin)
+	}
+	modules.Issues = append(modules.Issues, issues...)
+	*dst = append(*dst, rows...)
+	return err
+}
+
+// defaultIndexDefinitions returns the Terraform resource, pattern and utility indexes published
+// by AVM, plus the Bicep resource and pattern indexes when Bicep processing is enabled.
+func defaultIndexDefinitions() []IndexDefinition {
+	definitions := []IndexDefinition{
+		{Name: "TerraformResourceModules", URL: config.ResourceModulesUrl, LocalPath: config.LocalCsvPath + "/TerraformResourceModules.csv", Origin: OriginAvm, RecordType: RecordTypeResource},
+		{Name: "TerraformPatternModules", URL: config.PatternModulesUrl, LocalPath: config.LocalCsvPath + "/TerraformPatternModules.csv", Origin: OriginAvm, RecordType: RecordTypePattern},
+		{Name: "TerraformUtilityModules", URL: config.UtilityModulesUrl, LocalPath: config.LocalCsvPath + "/TerraformUtilityModules.csv", Origin: OriginAvm, RecordType: RecordTypeUtility},
+	}
+	if config.ProcessBicepResourceModules {
+		definitions = append(definitions, IndexDefinition{Name: "BicepResourceModules", URL: config.BicepResourceModulesUrl, LocalPath: config.LocalCsvPath + "/BicepResourceModules.csv", Origin: OriginAvm, RecordType: RecordTypeBicepResource})
+	}
+	if config.ProcessBicepPatternModules {
+		definitions = append(definitions, IndexDefinition{Name: "BicepPatternModules", URL: config.BicepPatternModulesUrl, LocalPath: config.LocalCsvPath + "/BicepPatternModules.csv", Origin: OriginAvm, RecordType: RecordTypeBicepPattern})
+	}
+	return definitions
+}
+
+// privateIndexDefinition returns the definition of the user-supplied private module index. Its
+// rows share the resource module schema but may point at any git URL, and their names are
+// transformed with config.PrivateIndexNameTemplate.
+func privateIndexDefinition(path string) IndexDefinition {
+	return IndexDefinition{
+		Name:         privateIndexName,
+		LocalPath:    path,
+		RecordType:   RecordTypeResource,
+		NameTemplate: config.PrivateIndexNameTemplate,
+		Origin:       OriginPrivate,
+	}
+}
+
+// LoadIndexDefinitions returns the registry of module indexes to load. Without an index config
+// file the default AVM Terraform indexes are used; otherwise the file, a JSON array of index
+// definitions, replaces them. The private index from --private-index is appended to either.
+func LoadIndexDefinitions(path string) ([]IndexDefinition, error) {
+	definitions, err := loadIndexDefinitionsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if config.PrivateIndexFile != "" {
+		for _, def := range definitions {
+			if def.Name == privateIndexName {
+				return nil, fmt.Errorf("index config file %s already defines %s, which is reserved for --private-index", path, privateIndexName)
+			}
 		}
-		logger.Info("Successfully loaded resource modules from local CSV",
-			zap.Int("module_count", len(modules)))
-		return modules, nil
+		definitions = append(definitions, privateIndexDefinition(config.PrivateIndexFile))
+	}
+	return definitions, nil
+}
+
+// loadIndexDefinitionsFile reads and validates the index config file, returning the default AVM
+// indexes when no file is given.
+func loadIndexDefinitionsFile(path string) ([]IndexDefinition, error) {
+	if path == "" {
+		return defaultIndexDefinitions(), nil
 	}
-	logger.Info("Fetching resource modules from remote URL",
-		zap.String("url", config.ResourceModulesUrl))
-	resp, err := http.Get(config.ResourceModulesUrl)
+	data, err := os.ReadFile(path)
 	if err != nil {
-		logger.Error("Failed to fetch resource modules from remote URL",
-			zap.String("url", config.ResourceModulesUrl),
-			zap.Error(err))
-		return nil, fmt.Errorf("error fetching resource modules: %w", err)
-	}
-	defer resp.Body.Close()
-	logger.Info("Received HTTP response for resource modules",
-		zap.Int("status_code", resp.StatusCode))
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("Received non-200 HTTP response for resource modules",
-			zap.Int("status_code", resp.StatusCode))
-		return nil, fmt.Errorf("received non-200 response: %d", resp.StatusCode)
-	}
-	if err := gocsv.Unmarshal(resp.Body, &modules); err != nil {
-		logger.Error("Failed to parse remote resource modules CSV",
-			zap.Error(err))
-		return nil, fmt.Errorf("error parsing CSV: %w", err)
+		return nil, fmt.Errorf("error reading index config file: %w", err)
 	}
-	logger.Info("Successfully loaded resource modules from remote URL",
-		zap.Int("module_count", len(modules)))
-	return modules, nil
+	var definitions []IndexDefinition
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, fmt.Errorf("error parsing index config file %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i, def := range definitions {
+		if def.Name == "" {
+			return nil, fmt.Errorf("index config file %s has a definition without a name", path)
+		}
+		if seen[def.Name] {
+			return nil, fmt.Errorf("index config file %s defines %s more than once", path, def.Name)
+		}
+		seen[def.Name] = true
+		if _, ok := recordLoaders[def.RecordType]; !ok {
+			return nil, fmt.Errorf("index %s has unknown record type %q", def.Name, def.RecordType)
+		}
+		if def.URL == "" && def.LocalPath == "" {
+			return nil, fmt.Errorf("index %s has neither a URL nor a local path", def.Name)
+		}
+		if def.Origin == "" {
+			definitions[i].Origin = OriginAvm
+		}
+	}
+	return definitions, nil
 }
 
-// getPatternModules fetches and parses pattern modules from either a local CSV file or remote URL.
-func getPatternModules(logger *zap.Logger) ([]PatternModulesStruct, error) {
-	var modules []PatternModulesStruct
-	if config.ReadLocalCsvFile {
-		filePath := config.LocalCsvPath + "/TerraformPatternModules.csv"
-		logger.Info("Reading pattern modules from local CSV",
-			zap.String("file_path", filePath))
-		file, err := openCsvFile(filePath)
+// loadIndex fetches and parses a single module index into records of type T. The local copy is
+// read when local CSV files are requested or the definition has no URL; remote indexes are
+// downloaded through fetchIndex, which retries and falls back to the on-disk cache. Columns of
+// T missing from the index header are returned as validation issues.
+func loadIndex[T any](def IndexDefinition, logger *zap.Logger) ([]T, []IndexIssue, error) {
+	var modules []T
+	var source io.Reader
+	if def.URL == "" || (config.ReadLocalCsvFile && def.LocalPath != "") {
+		logger.Info("Reading module index from local CSV",
+			zap.String("index", def.Name),
+			zap.String("file_path", def.LocalPath))
+		file, err := openCsvFile(def.LocalPath)
 		if err != nil {
-			logger.Error("Failed to open local pattern modules CSV",
-				zap.String("file_path", filePath),
+			logger.Error("Failed to open local module index CSV",
+				zap.String("index", def.Name),
+				zap.String("file_path", def.LocalPath),
 				zap.Error(err))
-			return nil, fmt.Errorf("error opening local pattern modules CSV: %w", err)
+			return nil, nil, fmt.Errorf("error opening local %s CSV: %w", def.Name, err)
 		}
 		defer file.Close()
-		if err := gocsv.Unmarshal(file, &modules); err != nil {
-			logger.Error("Failed to parse local pattern modules CSV",
-				zap.String("file_path", filePath),
+		source = file
+	} else {
+		logger.Info("Fetching module index from remote URL",
+			zap.String("index", def.Name),
+			zap.String("url", def.URL))
+		body, err := fetchIndex(def, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		source = bytes.NewReader(body)
+	}
+	if isYamlIndex(def) {
+		converted, err := yamlIndexToCsv(source)
+		if err != nil {
+			logger.Error("Failed to parse module index YAML",
+				zap.String("index", def.Name),
 				zap.Error(err))
-			return nil, fmt.Errorf("error parsing local CSV: %w", err)
+			return nil, nil, fmt.Errorf("error parsing %s YAML: %w", def.Name, err)
 		}
-		logger.Info("Successfully loaded pattern modules from local CSV",
-			zap.Int("module_count", len(modules)))
-		return modules, nil
+		source = converted
 	}
-	logger.Info("Fetching pattern modules from remote URL",
-		zap.String("url", config.PatternModulesUrl))
-	resp, err := http.Get(config.PatternModulesUrl)
+	data, err := io.ReadAll(source)
 	if err != nil {
-		logger.Error("Failed to fetch pattern modules from remote URL",
-			zap.String("url", config.PatternModulesUrl),
-			zap.Error(err))
-		return nil, fmt.Errorf("error fetching pattern modules: %w", err)
-	}
-	defer resp.Body.Close()
-	logger.Info("Received HTTP response for pattern modules",
-		zap.Int("status_code", resp.StatusCode))
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("Received non-200 HTTP response for pattern modules",
-			zap.Int("status_code", resp.StatusCode))
-		return nil, fmt.Errorf("received non-200 response: %d", resp.StatusCode)
-	}
-	if err := gocsv.Unmarshal(resp.Body, &modules); err != nil {
-		logger.Error("Failed to parse remote pattern modules CSV",
+		return nil, nil, fmt.Errorf("error reading %s: %w", def.Name, err)
+	}
+	issues := missingColumnIssues[T](def, data)
+	if err := gocsv.Unmarshal(bytes.NewReader(data), &modules); err != nil {
+		logger.Error("Failed to parse module index CSV",
+			zap.String("index", def.Name),
 			zap.Error(err))
-		return nil, fmt.Errorf("error parsing CSV: %w", err)
+		return nil, nil, fmt.Errorf("error parsing %s CSV: %w", def.Name, err)
 	}
-	logger.Info("Successfully loaded pattern modules from remote URL",
+	logger.Info("Successfully loaded module index",
+		zap.String("index", def.Name),
 		zap.Int("module_count", len(modules)))
-	return modules, nil
+	return modules, issues, nil
 }
 
-// getUtilityModules fetches and parses utility modules from either a local CSV file or remote URL.
-func getUtilityModules(logger *zap.Logger) ([]UtilityModulesStruct, error) {
-	var modules []UtilityModulesStruct
-	if config.ReadLocalCsvFile {
-		filePath := config.LocalCsvPath + "/TerraformUtilityModules.csv"
-		logger.Info("Reading utility modules from local CSV",
-			zap.String("file_path", filePath))
-		file, err := openCsvFile(filePath)
-		if err != nil {
-			logger.Error("Failed to open local utility modules CSV",
-				zap.String("file_path", filePath),
-				zap.Error(err))
-			return nil, fmt.Errorf("error opening local utility modules CSV: %w", err)
-		}
-		defer file.Close()
-		if err := gocsv.Unmarshal(file, &modules); err != nil {
-			logger.Error("Failed to parse local utility modules CSV",
-				zap.String("file_path", filePath),
-				zap.Error(err))
-			return nil, fmt.Errorf("error parsing local CSV: %w", err)
+// isYamlIndex reports whether an index is stored as YAML rather than CSV, based on the
+// extension of the file or URL it is read from.
+func isYamlIndex(def IndexDefinition) bool {
+	location := def.URL
+	if location == "" || (config.ReadLocalCsvFile && def.LocalPath != "") {
+		location = def.LocalPath
+	}
+	ext := strings.ToLower(path.Ext(location))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// yamlIndexToCsv converts a YAML index, a list of mappings keyed by the CSV column names, into
+// CSV so it is parsed by the same gocsv record mapping as CSV indexes.
+func yamlIndexToCsv(source io.Reader) (io.Reader, error) {
+	var rows []map[string]string
+	if err := yaml.NewDecoder(source).Decode(&rows); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var columns []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
 		}
-		logger.Info("Successfully loaded utility modules from local CSV",
-			zap.Int("module_count", len(modules)))
-		return modules, nil
 	}
-	logger.Info("Fetching utility modules from remote URL",
-		zap.String("url", config.UtilityModulesUrl))
-	resp, err := http.Get(config.UtilityModulesUrl)
-	if err != nil {
-		logger.Error("Failed to fetch utility modules from remote URL",
-			zap.String("url", config.UtilityModulesUrl),
-			zap.Error(err))
-		return nil, fmt.Errorf("error fetching utility modules: %w", err)
-	}
-	defer resp.Body.Close()
-	logger.Info("Received HTTP response for utility modules",
-		zap.Int("status_code", resp.StatusCode))
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("Received non-200 HTTP response for utility modules",
-			zap.Int("status_code", resp.StatusCode))
-		return nil, fmt.Errorf("received non-200 response: %d", resp.StatusCode)
-	}
-	if err := gocsv.Unmarshal(resp.Body, &modules); err != nil {
-		logger.Error("Failed to parse remote utility modules CSV",
-			zap.Error(err))
-		return nil, fmt.Errorf("error parsing CSV: %w", err)
+	sort.Strings(columns)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(columns); err != nil {
+		return nil, err
 	}
-	logger.Info("Successfully loaded utility modules from remote URL",
-		zap.Int("module_count", len(modules)))
-	return modules, nil
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = row[column]
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return &buf, w.Error()
+}
 
+// checkOriginCollisions returns an error listing every module from a non-AVM index whose name
+// is already used by an upstream AVM module.
+func checkOriginCollisions(modules *ModulesStruct) error {
+	avmIndexes := map[string]string{}
+	for _, module := range modules.allModules() {
+		if module.GetOrigin() == OriginAvm {
+			avmIndexes[module.GetModuleName()] = module.GetIndexName()
+		}
+	}
+	var problems []string
+	for _, module := range modules.allModules() {
+		if module.GetOrigin() == OriginAvm {
+			continue
+		}
+		if index, ok := avmIndexes[module.GetModuleName()]; ok {
+			problems = append(problems, fmt.Sprintf("%s module %s from index %s collides with the AVM module of the same name from index %s",
+				module.GetOrigin(), module.GetModuleName(), module.GetIndexName(), index))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("module names collide across index origins:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
 }
 
 // openCsvFile opens and returns a file handle for the specified CSV file path.
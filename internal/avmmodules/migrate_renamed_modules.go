@@ -0,0 +1,417 @@
+package avmmodules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+	"go.uber.org/zap"
+)
+
+// MovedNoteFileName is the redirect note left in the old folder of a renamed module.
+const MovedNoteFileName = "MOVED.md"
+
+// moduleRename describes an internal module folder whose upstream module now maps to a
+// different transformed name, either because AVM renamed the module or because the naming
+// templates changed.
+type moduleRename struct {
+	OldName    string
+	NewName    string
+	OldAvmName string
+	NewAvmName string
+	RepoURL    string
+	Reason     string
+	// TargetBranch is the branch the rename is made on, the target branch of the upstream module.
+	TargetBranch string
+	// OldChildPath and NewChildPath are the module roots the folder moves between, which differ
+	// only when the child path configured for the upstream module's family has changed.
+	OldChildPath string
+	NewChildPath string
+}
+
+// internalModule is a module folder found in the ADO source repository together with the
+// upstream details recorded in its .avm-version file. ChildPath is the module root it is in.
+type internalModule struct {
+	Name      string
+	ChildPath string
+	AvmName   string
+	RepoURL   string
+	Tag       string
+}
+
+// allModules returns every module in the index regardless of its kind.
+func (m *ModulesStruct) allModules() []Module {
+	modules := make([]Module, 0, len(m.ResourceModules)+len(m.PatternModules)+len(m.UtilityModules)+len(m.BicepResourceModules)+len(m.BicepPatternModules))
+	for _, module := range m.ResourceModules {
+		modules = append(modules, module)
+	}
+	for _, module := range m.PatternModules {
+		modules = append(modules, module)
+	}
+	for _, module := range m.UtilityModules {
+		modules = append(modules, module)
+	}
+	for _, module := range m.BicepResourceModules {
+		modules = append(modules, module)
+	}
+	for _, module := range m.BicepPatternModules {
+		modules = append(modules, module)
+	}
+	return modules
+}
+
+// alternativeNames returns the AlternativeNames column of a module split into its entries.
+func alternativeNames(module Module) []string {
+	var raw string
+	switch m := module.(type) {
+	case ResourceModulesStruct:
+		raw = m.AlternativeNames
+	case PatternModulesStruct:
+		raw = m.AlternativeNames
+	case UtilityModulesStruct:
+		raw = m.AlternativeNames
+	case BicepResourceModulesStruct:
+		raw = m.AlternativeNames
+	case BicepPatternModulesStruct:
+		raw = m.AlternativeNames
+	}
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// normaliseRepoURL lower-cases a repository URL and strips a trailing slash or .git suffix so
+// URLs recorded by different tools compare equal.
+func normaliseRepoURL(url string) string {
+	url = strings.ToLower(strings.TrimSpace(url))
+	url = strings.TrimSuffix(url, "/")
+	return strings.TrimSuffix(url, ".git")
+}
+
+// listInternalModules returns every folder under the module roots of the ADO source repository,
+// Terraform and Bicep, that contains a .avm-version file, sorted by name. A module root that
+// doesn't exist yet holds no modules.
+func listInternalModules(localRepoPath string, logger *zap.Logger) ([]internalModule, error) {
+	var modules []internalModule
+	for _, childPath := range sourceRepoChildPaths() {
+		root := moduleRootPath(localRepoPath, childPath)
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("error listing internal modules in %s: %w", root, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(root, entry.Name(), config.AvmVersionFileName))
+			if err != nil {
+				if !os.IsNotExist(err) {
+					logger.Warn("Could not read AVM version file", zap.String("module", entry.Name()), zap.Error(err))
+				}
+				continue
+			}
+			modules = append(modules, internalModule{
+				Name:      entry.Name(),
+				ChildPath: childPath,
+				AvmName:   parseAvmVersionValue(string(data), "module"),
+				RepoURL:   parseAvmVersionValue(string(data), "repo"),
+				Tag:       parseAvmVersionTag(string(data)),
+			})
+		}
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Name < modules[j].Name })
+	return modules, nil
+}
+
+// resolveUpstreamModule finds the index module an internal folder was synced from. The
+// recorded AVM name is tried first, then the AlternativeNames column of every module (AVM
+// keeps the old name there after a rename), then the recorded repository URL. Folders written
+// before the upstream name was recorded fall back to a reverse lookup of the folder name.
+func resolveUpstreamModule(internal internalModule, modules *ModulesStruct) (Module, string) {
+	all := modules.allModules()
+	if internal.AvmName == "" && internal.RepoURL == "" {
+		if avmName, ok := modules.Names.Lookup(internal.Name); ok {
+			for _, module := range all {
+				if module.GetModuleName() == avmName {
+					return module, "folder name"
+				}
+			}
+		}
+		return nil, ""
+	}
+	if internal.AvmName != "" {
+		for _, module := range all {
+			if module.GetModuleName() == internal.AvmName {
+				return module, "module name"
+			}
+		}
+		for _, module := range all {
+			for _, alt := range alternativeNames(module) {
+				if alt == internal.AvmName {
+					return module, "alternative name"
+				}
+			}
+		}
+	}
+	if internal.RepoURL != "" {
+		for _, module := range all {
+			if normaliseRepoURL(module.GetRepoURL()) == normaliseRepoURL(internal.RepoURL) {
+				return module, "repository URL"
+			}
+		}
+	}
+	return nil, ""
+}
+
+// findRenamedModules compares every internal module folder against the index and returns the
+// folders whose upstream module now transforms to a different name, each with the target branch
+// of its upstream module.
+func findRenamedModules(localRepoPath string, modules *ModulesStruct, targetBranches *TargetBranchMapping, logger *zap.Logger) ([]moduleRename, error) {
+	internals, err := listInternalModules(localRepoPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, internal := range internals {
+		present[moduleDirPath("", internal.ChildPath, internal.Name)] = true
+	}
+
+	var renames []moduleRename
+	for _, internal := range internals {
+		upstream, matchedBy := resolveUpstreamModule(internal, modules)
+		if upstream == nil {
+			logger.Debug("Internal module has no upstream match in the index", zap.String("module", internal.Name), zap.String("avmName", internal.AvmName))
+			continue
+		}
+		newName := modules.Names.Transform(upstream.GetModuleName())
+		newChildPath := upstream.GetSourceRepoChildPath()
+		if newName == internal.Name && newChildPath == internal.ChildPath {
+			continue
+		}
+		if present[moduleDirPath("", newChildPath, newName)] {
+			logger.Warn("Upstream module was renamed but the new folder already exists, skipping migration",
+				zap.String("old", internal.Name),
+				zap.String("new", newName))
+			continue
+		}
+		renames = append(renames, moduleRename{
+			OldName:      internal.Name,
+			NewName:      newName,
+			OldChildPath: internal.ChildPath,
+			NewChildPath: newChildPath,
+			OldAvmName:   internal.AvmName,
+			NewAvmName:   upstream.GetModuleName(),
+			RepoURL:      upstream.GetRepoURL(),
+			Reason:       "matched by " + matchedBy,
+			TargetBranch: targetBranches.TargetBranchFor(upstream),
+		})
+	}
+	return renames, nil
+}
+
+// MigrateRenamedModules detects internal module folders whose upstream module has been renamed
+// (or whose transformed name changed) and opens one pull request per folder that moves it to
+// the new name with git mv, leaves a redirect note behind, and updates Artifactory references
+// to the old name. Renamed modules are recorded in PendingRenames so the sync phases skip them
+// until the migration has been merged.
+func (p *ModuleProcessor) MigrateRenamedModules() error {
+	localRepoPath := config.SourceRepoPath
+	renames, err := findRenamedModules(localRepoPath, p.Modules, p.TargetBranches, p.Logger)
+	if err != nil {
+		p.Logger.Error("Failed to detect renamed modules", zap.Error(err))
+		return err
+	}
+	p.Logger.Info("Detected renamed modules", zap.Int("count", len(renames)))
+	if p.PendingRenames == nil {
+		p.PendingRenames = map[string]string{}
+	}
+
+	var failed []string
+	for _, rename := range renames {
+		p.PendingRenames[rename.NewName] = rename.OldName
+		if err := p.migrateRenamedModule(localRepoPath, rename); err != nil {
+			failed = append(failed, rename.OldName)
+		}
+	}
+
+	// Leave the working tree on the default branch so the sync phases read the pre-migration
+	// state of every module.
+	if err := checkoutDefaultBranch(localRepoPath, p.Logger); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to migrate renamed modules: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// migrateRenamedModule creates the branch, commit and pull request for a single rename.
+func (p *ModuleProcessor) migrateRenamedModule(localRepoPath string, rename moduleRename) error {
+	logger := p.Logger
+	branchName := config.BranchPrefix + "rename/" + rename.OldName
+	logger.Info("Migrating renamed module",
+		zap.String("old", rename.OldName),
+		zap.String("new", rename.NewName),
+		zap.String("avmName", rename.NewAvmName),
+		zap.String("targetBranch", rename.TargetBranch),
+		zap.String("reason", rename.Reason))
+
+	if err := checkoutSyncBranch(localRepoPath, branchName, rename.TargetBranch, rename.OldName, logger); err != nil {
+		return err
+	}
+
+	oldDir := moduleDirPath(localRepoPath, rename.OldChildPath, rename.OldName)
+	newDir := moduleDirPath(localRepoPath, rename.NewChildPath, rename.NewName)
+	if _, err := os.Stat(oldDir); os.IsNotExist(err) {
+		logger.Warn("Renamed module folder does not exist on its target branch, skipping migration",
+			zap.String("module", rename.OldName),
+			zap.String("targetBranch", rename.TargetBranch))
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		logger.Error("Failed to create module root", zap.String("module", rename.NewName), zap.Error(err))
+		return err
+	}
+	if out, err := runGit(localRepoPath, logger, rename.OldName, "mv", oldDir, newDir); err != nil {
+		logger.Error("Failed to move module folder", zap.String("module", rename.OldName), zap.String("output", out), zap.Error(err))
+		return err
+	}
+
+	// Record the new upstream name so the next sync resolves the folder directly.
+	versionPath := filepath.Join(newDir, config.AvmVersionFileName)
+	if data, err := os.ReadFile(versionPath); err == nil {
+		content := string(data)
+		tag := parseAvmVersionTag(content)
+		commit := parseAvmVersionCommit(content)
+		updated := "tag=" + tag + "\ncommit=" + commit + "\nmodule=" + rename.NewAvmName + "\nrepo=" + rename.RepoURL + "\n"
+		if err := os.WriteFile(versionPath, []byte(updated), 0644); err != nil {
+			logger.Error("Failed to update AVM version file", zap.String("module", rename.NewName), zap.Error(err))
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(oldDir, 0755); err != nil {
+		logger.Error("Failed to recreate old module folder for redirect note", zap.String("module", rename.OldName), zap.Error(err))
+		return err
+	}
+	link, err := filepath.Rel(oldDir, newDir)
+	if err != nil {
+		return err
+	}
+	note := "# Moved\n\nThis module has moved to [" + rename.NewName + "](" + filepath.ToSlash(link) + ").\n\n" +
+		"The upstream AVM module is now `" + rename.NewAvmName + "` (" + rename.RepoURL + ").\n"
+	if err := os.WriteFile(filepath.Join(oldDir, MovedNoteFileName), []byte(note), 0644); err != nil {
+		logger.Error("Failed to write redirect note", zap.String("module", rename.OldName), zap.Error(err))
+		return err
+	}
+
+	rewritten, err := rewriteRenamedModuleReferences(localRepoPath, rename, logger)
+	if err != nil {
+		logger.Warn("Errors occurred while rewriting references to renamed module, but continuing with commit", zap.String("module", rename.OldName), zap.Error(err))
+	}
+
+	if out, err := runGit(localRepoPath, logger, rename.OldName, "add", "-A", "."); err != nil {
+		logger.Error("Failed to stage changes", zap.String("module", rename.OldName), zap.String("output", out), zap.Error(err))
+		return err
+	}
+	commitMsg := "chore(module): Moved AVM module " + rename.OldName + " to " + rename.NewName
+	if out, err := runGit(localRepoPath, logger, rename.OldName, "commit", "-m", commitMsg); err != nil {
+		logger.Error("Failed to commit changes", zap.String("module", rename.OldName), zap.String("output", out), zap.Error(err))
+		return err
+	}
+	p.recordCommitSignature(localRepoPath, rename.OldName)
+	if err := pushBranch(localRepoPath, branchName, rename.OldName, logger); err != nil {
+		return err
+	}
+
+	description := "This is an automated pull request to move the " + rename.OldName + " module to " + rename.NewName +
+		" after the upstream AVM module was renamed to " + rename.NewAvmName + " (" + rename.Reason + ").\n\n" +
+		"A redirect note is left in the old folder."
+	if len(rewritten) > 0 {
+		description += "\n\nReferences updated in:\n- " + strings.Join(rewritten, "\n- ")
+	}
+	pr, err := createOrUpdatePullRequest(p.Host, p.Context, repohost.PullRequestOptions{
+		SourceBranch: branchName,
+		TargetBranch: rename.TargetBranch,
+		Title:        commitMsg,
+		Description:  description,
+	}, "", rename.OldName, logger)
+	if err != nil {
+		return err
+	}
+	if pr != nil {
+		logger.Info("Rename pull request ready", zap.String("module", rename.OldName), zap.Int("prId", pr.ID))
+	}
+	return nil
+}
+
+// rewriteRenamedModuleReferences replaces the source of the old module name with the source of
+// the new name in every module root, returning the files changed: the Artifactory source in .tf
+// files and the Bicep registry source in .bicep files. Without a source template no references
+// to that family were rewritten and nothing needs updating.
+func rewriteRenamedModuleReferences(localRepoPath string, rename moduleRename, logger *zap.Logger) ([]string, error) {
+	// Sources are matched on the opening quote and the following path separator or quote (a
+	// colon and the version for Bicep) so a module whose name is a prefix of another is not
+	// rewritten by mistake.
+	replacements := map[string][]string{}
+	for ext, source := range map[string]string{".tf": config.ArtifactorySourceTemplate, ".bicep": config.BicepRegistrySourceTemplate} {
+		if source == "" {
+			continue
+		}
+		tmpl, err := template.New("module-source").Parse(source)
+		if err != nil {
+			logger.Error("Failed to parse module source template", zap.String("template", source), zap.Error(err))
+			return nil, err
+		}
+		var oldSource, newSource strings.Builder
+		if err := tmpl.Execute(&oldSource, struct{ ModuleName string }{ModuleName: rename.OldName}); err != nil {
+			return nil, err
+		}
+		if err := tmpl.Execute(&newSource, struct{ ModuleName string }{ModuleName: rename.NewName}); err != nil {
+			return nil, err
+		}
+		if ext == ".bicep" {
+			replacements[ext] = []string{"'" + oldSource.String() + ":", "'" + newSource.String() + ":"}
+		} else {
+			oldQuoted, newQuoted := `"`+oldSource.String(), `"`+newSource.String()
+			replacements[ext] = []string{oldQuoted + `"`, newQuoted + `"`, oldQuoted + `//`, newQuoted + `//`}
+		}
+	}
+	if len(replacements) == 0 {
+		return nil, nil
+	}
+
+	var changed []string
+	err := walkModuleRoots(localRepoPath, func(childPath string, root string, path string, info os.FileInfo) error {
+		replacer, ok := replacements[filepath.Ext(path)]
+		if info.IsDir() || !ok {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		content := string(data)
+		updated := strings.NewReplacer(replacer...).Replace(content)
+		if updated == content {
+			return nil
+		}
+		rel, _ := filepath.Rel(localRepoPath, path)
+		logger.Info("Rewriting reference to renamed module", zap.String("file", rel), zap.String("from", rename.OldName), zap.String("to", rename.NewName))
+		changed = append(changed, rel)
+		return os.WriteFile(path, []byte(updated), info.Mode())
+	})
+	return changed, err
+}
@@ -0,0 +1,114 @@
+package avmmodules
+
+import (
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// parentModuleName returns the parent of a resource module, or "" for top-level modules. The
+// index uses "n/a" for modules without a parent.
+func parentModuleName(module ResourceModulesStruct) string {
+	parent := strings.TrimSpace(module.ParentModule)
+	if parent == "" || strings.EqualFold(parent, "n/a") || parent == "-" {
+		return ""
+	}
+	return parent
+}
+
+// includeChildModules adds to selected every module of all whose parent, directly or through
+// another child, is selected, so children are never left behind when their parent is synced.
+// Children follow the same rules as any other module: excluded children stay excluded, and
+// children whose status isn't synced are only included when they are on the override list.
+func includeChildModules(p *ModuleProcessor, all []ResourceModulesStruct, selected []ResourceModulesStruct) []ResourceModulesStruct {
+	included := map[string]bool{}
+	skipped := map[string]bool{}
+	for _, module := range selected {
+		included[module.ModuleName] = true
+	}
+	for added := true; added; {
+		added = false
+		for _, module := range all {
+			parent := parentModuleName(module)
+			if parent == "" || included[module.ModuleName] || skipped[module.ModuleName] || !included[parent] {
+				continue
+			}
+			if isModuleExcluded(module.ModuleName) {
+				skipped[module.ModuleName] = true
+				p.Logger.Warn("Child module excluded via exclusion list although its parent is included",
+					zap.String("module", module.ModuleName),
+					zap.String("parent", parent))
+				continue
+			}
+			if !isStatusAllowed(module.ModuleStatus) && !isModuleOverride(module.ModuleName) {
+				skipped[module.ModuleName] = true
+				p.Logger.Info("Child module not included with its parent due to status",
+					zap.String("module", module.ModuleName),
+					zap.String("parent", parent),
+					zap.String("status", module.ModuleStatus))
+				continue
+			}
+			p.Logger.Info("Module included because its parent module is included",
+				zap.String("module", module.ModuleName),
+				zap.String("parent", parent),
+				zap.String("status", module.ModuleStatus))
+			included[module.ModuleName] = true
+			selected = append(selected, module)
+			added = true
+		}
+	}
+	return selected
+}
+
+// orderParentsFirst returns modules ordered so every parent comes before its children while
+// otherwise keeping the original order. Modules caught in a parent cycle keep their original
+// relative order after the rest.
+func orderParentsFirst(modules []ResourceModulesStruct, logger *zap.Logger) []ResourceModulesStruct {
+	present := map[string]bool{}
+	for _, module := range modules {
+		present[module.ModuleName] = true
+	}
+	placed := map[string]bool{}
+	ordered := make([]ResourceModulesStruct, 0, len(modules))
+	for len(ordered) < len(modules) {
+		progressed := false
+		for _, module := range modules {
+			if placed[module.ModuleName] {
+				continue
+			}
+			if parent := parentModuleName(module); parent != "" && present[parent] && !placed[parent] {
+				continue
+			}
+			placed[module.ModuleName] = true
+			ordered = append(ordered, module)
+			progressed = true
+		}
+		if !progressed {
+			for _, module := range modules {
+				if !placed[module.ModuleName] {
+					logger.Warn("Module is part of a parent module cycle, syncing in index order",
+						zap.String("module", module.ModuleName),
+						zap.String("parent", parentModuleName(module)))
+					placed[module.ModuleName] = true
+					ordered = append(ordered, module)
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// moduleHierarchy maps each parent module to its children among modules, sorted by name.
+func moduleHierarchy(modules []ResourceModulesStruct) map[string][]string {
+	hierarchy := map[string][]string{}
+	for _, module := range modules {
+		if parent := parentModuleName(module); parent != "" {
+			hierarchy[parent] = append(hierarchy[parent], module.ModuleName)
+		}
+	}
+	for _, children := range hierarchy {
+		sort.Strings(children)
+	}
+	return hierarchy
+}
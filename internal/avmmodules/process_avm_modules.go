@@ -53,9 +53,28 @@ func isModuleForced(moduleName string) bool {
 	return false
 }
 
+// withoutPendingRenames drops modules whose folder is being moved by an open rename migration
+// pull request, so the sync does not create the new folder alongside the old one.
+func withoutPendingRenames[T Module](p *ModuleProcessor, modules []T, transformedNames map[string]string) []T {
+	kept := []T{}
+	for _, module := range modules {
+		newName := transformedNames[module.GetModuleName()]
+		if oldName, ok := p.PendingRenames[newName]; ok {
+			p.Logger.Info("Module has a pending rename migration, skipping sync",
+				zap.String("module", module.GetModuleName()),
+				zap.String("old", oldName),
+				zap.String("new", newName))
+			continue
+		}
+		kept = append(kept, module)
+	}
+	return kept
+}
+
 // ProcessResourceModules filters, clones, and processes resource modules based on their status.
 // It applies the given processFunc to each filtered module after cloning and pushing to Git.
-// Modules are filtered by allowed statuses or included via the override list.
+// Modules are filtered by allowed statuses or included via the override list. When parent
+// modules are followed, children of included modules are included too and synced after them.
 func (p *ModuleProcessor) ProcessResourceModules(processFunc func(ResourceModulesStruct)) error {
 	p.Logger.Info("[Resource modules] Phase 1/3: filtering modules",
 		zap.Int("total_modules", len(p.Modules.ResourceModules)))
@@ -66,6 +85,7 @@ func (p *ModuleProcessor) ProcessResourceModules(processFunc func(ResourceModule
 			p.Logger.Info("Module excluded via exclusion list",
 				zap.String("module", module.ModuleName),
 				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.Resource, "is excluded via --excluded-modules")
 			continue
 		}
 		if isStatusAllowed(module.ModuleStatus) {
@@ -79,28 +99,33 @@ func (p *ModuleProcessor) ProcessResourceModules(processFunc func(ResourceModule
 			p.Logger.Info("Module filtered out due to status",
 				zap.String("module", module.ModuleName),
 				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.Resource, "has status "+module.ModuleStatus+", which is not synced")
 		}
 	}
 
+	if config.FollowParentModules {
+		filteredModules = includeChildModules(p, p.Modules.ResourceModules, filteredModules)
+		filteredModules = orderParentsFirst(filteredModules, p.Logger)
+		p.Report.SetModuleHierarchy(moduleHierarchy(filteredModules))
+	}
+
+	transformedNames, err := transformModuleSet(filteredModules, p.Modules.Names.Resource)
+	if err != nil {
+		p.Logger.Error("[Resource modules] Module name collision, aborting before cloning", zap.Error(err))
+		return err
+	}
+	filteredModules = withoutPendingRenames(p, filteredModules, transformedNames)
+
 	p.Logger.Info("[Resource modules] Phase 2/3: cloning repositories",
 		zap.Int("modules_to_process", len(filteredModules)))
 	batches := batchSlice(filteredModules, config.BatchSize)
 	for _, batch := range batches {
-		CloneModulesInBatches(batch, config.TempAvmModuleRepoPath, p.Logger, p, resourceNameTransformer)
+		CloneModulesInBatches(batch, config.TempAvmModuleRepoPath, p.Logger, p, p.Modules.Names.Resource)
 	}
 	p.Logger.Info("[Resource modules] Phase 3/3: syncing to git",
 		zap.Int("modules_to_process", len(filteredModules)))
 	for _, module := range filteredModules {
-		transformedName := resourceNameTransformer(module.GetModuleName())
-		latestAvmTag := ""
-		if v, ok := p.LatestAvmTagMap.Load(transformedName); ok {
-			latestAvmTag = v.(string)
-		}
-		latestAvmCommit := ""
-		if v, ok := p.LatestAvmCommitMap.Load(transformedName); ok {
-			latestAvmCommit = v.(string)
-		}
-		CommitAndPushModulesToGit(p.Clients, p.Context, p.Project, p.RepoId, module, config.SourceRepoPath, resourceNameTransformer, latestAvmTag, latestAvmCommit, p.Logger)
+		CommitAndPushModulesToGit(p, module, config.SourceRepoPath, p.Modules.Names.Resource)
 		processFunc(module)
 	}
 	p.Logger.Info("[Resource modules] All phases complete",
@@ -121,6 +146,7 @@ func (p *ModuleProcessor) ProcessPatternModules(processFunc func(PatternModulesS
 			p.Logger.Info("Module excluded via exclusion list",
 				zap.String("module", module.ModuleName),
 				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.Pattern, "is excluded via --excluded-modules")
 			continue
 		}
 		if isStatusAllowed(module.ModuleStatus) {
@@ -134,28 +160,27 @@ func (p *ModuleProcessor) ProcessPatternModules(processFunc func(PatternModulesS
 			p.Logger.Info("Module filtered out due to status",
 				zap.String("module", module.ModuleName),
 				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.Pattern, "has status "+module.ModuleStatus+", which is not synced")
 		}
 	}
 
+	transformedNames, err := transformModuleSet(filteredModules, p.Modules.Names.Pattern)
+	if err != nil {
+		p.Logger.Error("[Pattern modules] Module name collision, aborting before cloning", zap.Error(err))
+		return err
+	}
+	filteredModules = withoutPendingRenames(p, filteredModules, transformedNames)
+
 	p.Logger.Info("[Pattern modules] Phase 2/3: cloning repositories",
 		zap.Int("modules_to_process", len(filteredModules)))
 	batches := batchSlice(filteredModules, config.BatchSize)
 	for _, batch := range batches {
-		CloneModulesInBatches(batch, config.TempAvmModuleRepoPath, p.Logger, p, patternNameTransformer)
+		CloneModulesInBatches(batch, config.TempAvmModuleRepoPath, p.Logger, p, p.Modules.Names.Pattern)
 	}
 	p.Logger.Info("[Pattern modules] Phase 3/3: syncing to git",
 		zap.Int("modules_to_process", len(filteredModules)))
 	for _, module := range filteredModules {
-		transformedName := patternNameTransformer(module.GetModuleName())
-		latestAvmTag := ""
-		if v, ok := p.LatestAvmTagMap.Load(transformedName); ok {
-			latestAvmTag = v.(string)
-		}
-		latestAvmCommit := ""
-		if v, ok := p.LatestAvmCommitMap.Load(transformedName); ok {
-			latestAvmCommit = v.(string)
-		}
-		CommitAndPushModulesToGit(p.Clients, p.Context, p.Project, p.RepoId, module, config.SourceRepoPath, patternNameTransformer, latestAvmTag, latestAvmCommit, p.Logger)
+		CommitAndPushModulesToGit(p, module, config.SourceRepoPath, p.Modules.Names.Pattern)
 		processFunc(module)
 	}
 	p.Logger.Info("[Pattern modules] All phases complete",
@@ -176,6 +201,7 @@ func (p *ModuleProcessor) ProcessUtilityModules(processFunc func(UtilityModulesS
 			p.Logger.Info("Module excluded via exclusion list",
 				zap.String("module", module.ModuleName),
 				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.Utility, "is excluded via --excluded-modules")
 			continue
 		}
 		if isStatusAllowed(module.ModuleStatus) {
@@ -189,32 +215,135 @@ func (p *ModuleProcessor) ProcessUtilityModules(processFunc func(UtilityModulesS
 			p.Logger.Info("Module filtered out due to status",
 				zap.String("module", module.ModuleName),
 				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.Utility, "has status "+module.ModuleStatus+", which is not synced")
 		}
 	}
 
+	transformedNames, err := transformModuleSet(filteredModules, p.Modules.Names.Utility)
+	if err != nil {
+		p.Logger.Error("[Utility modules] Module name collision, aborting before cloning", zap.Error(err))
+		return err
+	}
+	filteredModules = withoutPendingRenames(p, filteredModules, transformedNames)
+
 	p.Logger.Info("[Utility modules] Phase 2/3: cloning repositories",
 		zap.Int("modules_to_process", len(filteredModules)))
 	batches := batchSlice(filteredModules, config.BatchSize)
 	for _, batch := range batches {
-		CloneModulesInBatches(batch, config.TempAvmModuleRepoPath, p.Logger, p, utilityNameTransformer)
+		CloneModulesInBatches(batch, config.TempAvmModuleRepoPath, p.Logger, p, p.Modules.Names.Utility)
 	}
 	p.Logger.Info("[Utility modules] Phase 3/3: syncing to git",
 		zap.Int("modules_to_process", len(filteredModules)))
 	for _, module := range filteredModules {
-		transformedName := utilityNameTransformer(module.GetModuleName())
-		latestAvmTag := ""
-		if v, ok := p.LatestAvmTagMap.Load(transformedName); ok {
-			latestAvmTag = v.(string)
+		CommitAndPushModulesToGit(p, module, config.SourceRepoPath, p.Modules.Names.Utility)
+		processFunc(module)
+	}
+	p.Logger.Info("[Utility modules] All phases complete",
+		zap.Int("modules_processed", len(filteredModules)))
+
+	return nil
+}
+
+// ProcessBicepResourceModules filters, clones, and processes Bicep resource modules based on their status.
+// It applies the given processFunc to each filtered module after cloning and pushing to Git.
+// Modules are filtered by allowed statuses or included via the override list.
+func (p *ModuleProcessor) ProcessBicepResourceModules(processFunc func(BicepResourceModulesStruct)) error {
+	p.Logger.Info("[Bicep resource modules] Phase 1/3: filtering modules",
+		zap.Int("total_modules", len(p.Modules.BicepResourceModules)))
+	// Filter modules by allowed statuses or override list
+	filteredModules := []BicepResourceModulesStruct{}
+	for _, module := range p.Modules.BicepResourceModules {
+		if isModuleExcluded(module.ModuleName) {
+			p.Logger.Info("Module excluded via exclusion list",
+				zap.String("module", module.ModuleName),
+				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.BicepResource, "is excluded via --excluded-modules")
+			continue
 		}
-		latestAvmCommit := ""
-		if v, ok := p.LatestAvmCommitMap.Load(transformedName); ok {
-			latestAvmCommit = v.(string)
+		if isStatusAllowed(module.ModuleStatus) {
+			filteredModules = append(filteredModules, module)
+		} else if isModuleOverride(module.ModuleName) {
+			p.Logger.Info("Module included via override list",
+				zap.String("module", module.ModuleName),
+				zap.String("status", module.ModuleStatus))
+			filteredModules = append(filteredModules, module)
+		} else {
+			p.Logger.Info("Module filtered out due to status",
+				zap.String("module", module.ModuleName),
+				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.BicepResource, "has status "+module.ModuleStatus+", which is not synced")
 		}
-		CommitAndPushModulesToGit(p.Clients, p.Context, p.Project, p.RepoId, module, config.SourceRepoPath, utilityNameTransformer, latestAvmTag, latestAvmCommit, p.Logger)
+	}
+
+	transformedNames, err := transformModuleSet(filteredModules, p.Modules.Names.BicepResource)
+	if err != nil {
+		p.Logger.Error("[Bicep resource modules] Module name collision, aborting before cloning", zap.Error(err))
+		return err
+	}
+	filteredModules = withoutPendingRenames(p, filteredModules, transformedNames)
+
+	p.Logger.Info("[Bicep resource modules] Phase 2/3: copying modules from bicep-registry-modules",
+		zap.Int("modules_to_process", len(filteredModules)))
+	CloneBicepModules(filteredModules, config.TempAvmModuleRepoPath, p.Logger, p, p.Modules.Names.BicepResource)
+	p.Logger.Info("[Bicep resource modules] Phase 3/3: syncing to git",
+		zap.Int("modules_to_process", len(filteredModules)))
+	for _, module := range filteredModules {
+		CommitAndPushModulesToGit(p, module, config.SourceRepoPath, p.Modules.Names.BicepResource)
 		processFunc(module)
 	}
-	p.Logger.Info("[Utility modules] All phases complete",
+	p.Logger.Info("[Bicep resource modules] All phases complete",
 		zap.Int("modules_processed", len(filteredModules)))
+	return nil
+}
 
+// ProcessBicepPatternModules filters, clones, and processes Bicep pattern modules based on their status.
+// It applies the given processFunc to each filtered module after cloning and pushing to Git.
+// Modules are filtered by allowed statuses or included via the override list.
+func (p *ModuleProcessor) ProcessBicepPatternModules(processFunc func(BicepPatternModulesStruct)) error {
+	p.Logger.Info("[Bicep pattern modules] Phase 1/3: filtering modules",
+		zap.Int("total_modules", len(p.Modules.BicepPatternModules)))
+	// Filter modules by allowed statuses or override list
+	filteredModules := []BicepPatternModulesStruct{}
+	for _, module := range p.Modules.BicepPatternModules {
+		if isModuleExcluded(module.ModuleName) {
+			p.Logger.Info("Module excluded via exclusion list",
+				zap.String("module", module.ModuleName),
+				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.BicepPattern, "is excluded via --excluded-modules")
+			continue
+		}
+		if isStatusAllowed(module.ModuleStatus) {
+			filteredModules = append(filteredModules, module)
+		} else if isModuleOverride(module.ModuleName) {
+			p.Logger.Info("Module included via override list",
+				zap.String("module", module.ModuleName),
+				zap.String("status", module.ModuleStatus))
+			filteredModules = append(filteredModules, module)
+		} else {
+			p.Logger.Info("Module filtered out due to status",
+				zap.String("module", module.ModuleName),
+				zap.String("status", module.ModuleStatus))
+			recordFilteredModule(p, module, p.Modules.Names.BicepPattern, "has status "+module.ModuleStatus+", which is not synced")
+		}
+	}
+
+	transformedNames, err := transformModuleSet(filteredModules, p.Modules.Names.BicepPattern)
+	if err != nil {
+		p.Logger.Error("[Bicep pattern modules] Module name collision, aborting before cloning", zap.Error(err))
+		return err
+	}
+	filteredModules = withoutPendingRenames(p, filteredModules, transformedNames)
+
+	p.Logger.Info("[Bicep pattern modules] Phase 2/3: copying modules from bicep-registry-modules",
+		zap.Int("modules_to_process", len(filteredModules)))
+	CloneBicepModules(filteredModules, config.TempAvmModuleRepoPath, p.Logger, p, p.Modules.Names.BicepPattern)
+	p.Logger.Info("[Bicep pattern modules] Phase 3/3: syncing to git",
+		zap.Int("modules_to_process", len(filteredModules)))
+	for _, module := range filteredModules {
+		CommitAndPushModulesToGit(p, module, config.SourceRepoPath, p.Modules.Names.BicepPattern)
+		processFunc(module)
+	}
+	p.Logger.Info("[Bicep pattern modules] All phases complete",
+		zap.Int("modules_processed", len(filteredModules)))
 	return nil
 }
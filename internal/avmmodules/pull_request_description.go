@@ -0,0 +1,263 @@
+package avmmodules
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+	"go.uber.org/zap"
+)
+
+// maxUpstreamLogEntries caps the upstream commits listed in a pull request description.
+const maxUpstreamLogEntries = 50
+
+// maxChangelogExcerptLines caps the CHANGELOG lines quoted in a pull request description.
+const maxChangelogExcerptLines = 80
+
+// upstreamCommit is one commit of the upstream history between two synced versions.
+type upstreamCommit struct {
+	Commit  string
+	Subject string
+}
+
+// pullRequestDescription is the data available to the pull request description template.
+type pullRequestDescription struct {
+	ModuleName         string
+	UpstreamModuleName string
+	Origin             string
+	RepoURL            string
+	// UpstreamOwners are the owning team and owner handles recorded in the index.
+	UpstreamOwners []string
+	// Reviewers are the reviewers assigned from the reviewer mapping.
+	Reviewers []repohost.Reviewer
+
+	// Classification is how the sync moves the module, e.g. SyncMajor or SyncNewModule.
+	Classification string
+
+	PreviousTag       string
+	PreviousCommit    string
+	LatestTag         string
+	LatestCommit      string
+	PreviousTagURL    string
+	PreviousCommitURL string
+	LatestTagURL      string
+	LatestCommitURL   string
+	CompareURL        string
+
+	// UpstreamLog lists the upstream commits since the previous sync, newest first.
+	UpstreamLog          []upstreamCommit
+	UpstreamLogTruncated bool
+	Changelog            string
+	// InterfaceDiff is the change to the module's Terraform variables and outputs, or nil when
+	// there is none or it could not be worked out.
+	InterfaceDiff *InterfaceDiff
+
+	FilesAdded   int
+	FilesChanged int
+	FilesDeleted int
+
+	PatchesApplied []string
+	PatchesFailed  []string
+	SourceRewrites []sourceRewrite
+}
+
+// upstreamLog returns the subjects of the upstream commits after fromCommit up to toCommit,
+// newest first, limited to pathspec when it is set (e.g. a module folder of a monorepo). At
+// most maxUpstreamLogEntries+1 commits are returned so the description can tell the list was
+// cut. Returns nil when either commit is unknown, they are equal, or fromCommit is not in the
+// clone, e.g. because the upstream history was rewritten. Must run before .git is removed.
+func upstreamLog(repoPath string, fromCommit string, toCommit string, pathspec string, moduleName string, logger *zap.Logger) []upstreamCommit {
+	if fromCommit == "" || toCommit == "" || fromCommit == toCommit {
+		return nil
+	}
+	if _, err := runGit(repoPath, logger, moduleName, "cat-file", "-e", fromCommit+"^{commit}"); err != nil {
+		logger.Info("Previously synced commit not found upstream, skipping upstream log", zap.String("module", moduleName), zap.String("commit", fromCommit))
+		return nil
+	}
+	args := []string{"log", "--no-merges", "--format=%h%x09%s", "-n", strconv.Itoa(maxUpstreamLogEntries + 1), fromCommit + ".." + toCommit}
+	if pathspec != "" {
+		args = append(args, "--", pathspec)
+	}
+	out, err := runGit(repoPath, logger, moduleName, args...)
+	if err != nil {
+		logger.Warn("Failed to read upstream log", zap.String("module", moduleName), zap.String("from", fromCommit), zap.String("to", toCommit), zap.Error(err))
+		return nil
+	}
+	var commits []upstreamCommit
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if commit, subject, ok := strings.Cut(line, "\t"); ok {
+			commits = append(commits, upstreamCommit{Commit: commit, Subject: subject})
+		}
+	}
+	logger.Info("Read upstream log", zap.String("module", moduleName), zap.String("from", fromCommit), zap.String("to", toCommit), zap.Int("commits", len(commits)))
+	return commits
+}
+
+// changelogExcerpt returns the part of the module's CHANGELOG.md describing the versions after
+// previousTag up to latestTag: from the first heading naming latestTag to the first heading
+// naming previousTag. Returns "" when the module has no changelog or it has no heading for
+// latestTag, which is common for modules that publish release notes on GitHub instead.
+func changelogExcerpt(moduleDir string, previousTag string, latestTag string) string {
+	if latestTag == "" {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(moduleDir, "CHANGELOG.md"))
+	if err != nil {
+		return ""
+	}
+	latest := strings.TrimPrefix(latestTag, "v")
+	previous := strings.TrimPrefix(previousTag, "v")
+
+	var excerpt []string
+	for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		isHeading := strings.HasPrefix(line, "#")
+		if excerpt == nil {
+			if isHeading && containsVersion(line, latest) {
+				excerpt = []string{line}
+			}
+			continue
+		}
+		if isHeading && previous != "" && containsVersion(line, previous) {
+			break
+		}
+		if len(excerpt) == maxChangelogExcerptLines {
+			excerpt = append(excerpt, "…")
+			break
+		}
+		excerpt = append(excerpt, line)
+	}
+	return strings.TrimSpace(strings.Join(excerpt, "\n"))
+}
+
+// containsVersion reports whether line mentions version as a whole word, so 1.1.0 doesn't
+// match a heading for 11.1.0 or 1.1.0-beta.
+func containsVersion(line string, version string) bool {
+	for rest := line; ; {
+		i := strings.Index(rest, version)
+		if i < 0 {
+			return false
+		}
+		before := i == 0 || !isVersionChar(rest[i-1])
+		after := i+len(version) == len(rest) || !isVersionChar(rest[i+len(version)])
+		if before && after {
+			return true
+		}
+		rest = rest[i+1:]
+	}
+}
+
+// isVersionChar reports whether c can be part of a semantic version.
+func isVersionChar(c byte) bool {
+	return c >= '0' && c <= '9' || c == '.' || c == '-' || c == '+' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// stagedFileCounts counts the files added, changed and deleted under moduleDir in the index.
+// Renames count as changes.
+func stagedFileCounts(localRepoPath string, moduleDir string, moduleName string, logger *zap.Logger) (added int, changed int, deleted int) {
+	out, err := runGit(localRepoPath, logger, moduleName, "diff", "--cached", "--name-status", "--", moduleDir)
+	if err != nil {
+		logger.Warn("Failed to count staged files", zap.String("module", moduleName), zap.Error(err))
+		return 0, 0, 0
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "A"):
+			added++
+		case strings.HasPrefix(line, "D"):
+			deleted++
+		case line != "":
+			changed++
+		}
+	}
+	return added, changed, deleted
+}
+
+// upstreamRepoAndTag returns the web URL of the repository a module is synced from and the
+// name of tag there. Bicep modules live in the bicep-registry-modules monorepo under
+// "<module path>/<version>" tags.
+func upstreamRepoAndTag(module Module, tag string) (string, string) {
+	switch module.(type) {
+	case BicepResourceModulesStruct, BicepPatternModulesStruct:
+		return config.BicepRegistryRepoUrl, module.GetModuleName() + "/" + tag
+	}
+	return strings.TrimSuffix(module.GetRepoURL(), ".git"), tag
+}
+
+// setUpstreamLinks fills in the tag, commit and compare links of d. Links are only produced
+// for GitHub repositories, whose URL layout is known.
+func (d *pullRequestDescription) setUpstreamLinks(module Module) {
+	repo, latestTag := upstreamRepoAndTag(module, d.LatestTag)
+	_, previousTag := upstreamRepoAndTag(module, d.PreviousTag)
+	if !strings.HasPrefix(repo, "https://github.com/") {
+		return
+	}
+	if d.LatestTag != "" {
+		d.LatestTagURL = repo + "/releases/tag/" + latestTag
+	}
+	if d.PreviousTag != "" {
+		d.PreviousTagURL = repo + "/releases/tag/" + previousTag
+	}
+	if d.LatestCommit != "" {
+		d.LatestCommitURL = repo + "/commit/" + d.LatestCommit
+	}
+	if d.PreviousCommit != "" {
+		d.PreviousCommitURL = repo + "/commit/" + d.PreviousCommit
+	}
+	if d.PreviousCommit != "" && d.LatestCommit != "" && d.PreviousCommit != d.LatestCommit {
+		d.CompareURL = repo + "/compare/" + d.PreviousCommit + "..." + d.LatestCommit
+	}
+}
+
+// descriptionTemplateFuncs are the functions available to the pull request description template.
+var descriptionTemplateFuncs = template.FuncMap{
+	"short": shortCommit,
+	// link renders text as inline code, linked to url when one is known.
+	"link": func(text string, url string) string {
+		if url == "" {
+			return "`" + text + "`"
+		}
+		return "[`" + text + "`](" + url + ")"
+	},
+}
+
+// renderPullRequestDescription renders d with the template from config.PullRequestDescriptionTemplateFile,
+// or config.DefaultPullRequestDescriptionTemplate when no file is configured. A template that
+// fails to load or render falls back to the default template so the pull request is still created.
+func renderPullRequestDescription(d pullRequestDescription, logger *zap.Logger) string {
+	text := config.DefaultPullRequestDescriptionTemplate
+	if config.PullRequestDescriptionTemplateFile != "" {
+		data, err := os.ReadFile(config.PullRequestDescriptionTemplateFile)
+		if err != nil {
+			logger.Error("Failed to read pull request description template, using the default", zap.String("file", config.PullRequestDescriptionTemplateFile), zap.Error(err))
+		} else {
+			text = string(data)
+		}
+	}
+	description, err := executeDescriptionTemplate(text, d)
+	if err != nil && text != config.DefaultPullRequestDescriptionTemplate {
+		logger.Error("Failed to render pull request description template, using the default", zap.String("file", config.PullRequestDescriptionTemplateFile), zap.Error(err))
+		description, err = executeDescriptionTemplate(config.DefaultPullRequestDescriptionTemplate, d)
+	}
+	if err != nil {
+		logger.Error("Failed to render default pull request description template", zap.String("module", d.ModuleName), zap.Error(err))
+		return "This is an automated pull request to sync the " + d.ModuleName + " module from " + d.RepoURL
+	}
+	return strings.TrimSpace(description)
+}
+
+// executeDescriptionTemplate parses and executes a pull request description template.
+func executeDescriptionTemplate(text string, d any) (string, error) {
+	tmpl, err := template.New("pull-request-description").Funcs(descriptionTemplateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, d); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
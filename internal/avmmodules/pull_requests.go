@@ -0,0 +1,149 @@
+package avmmodules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+	"go.uber.org/zap"
+)
+
+// previousPush is the state of a sync branch on origin before it is force-pushed again.
+// Changes is set instead of a local history to diff against when the push went through the
+// host's push API, and holds the changes made on top of Commit.
+type previousPush struct {
+	Commit  string
+	Tag     string
+	Changes []repohost.FileChange
+}
+
+// fetchPreviousPush fetches the sync branch from origin and returns its head commit and the
+// upstream tag recorded in the module's .avm-version file. Returns nil when the branch has not
+// been pushed before.
+func fetchPreviousPush(localRepoPath string, branchName string, moduleDir string, moduleName string, logger *zap.Logger) *previousPush {
+	remoteRef := "refs/remotes/origin/" + branchName
+	args := append(gitAuthArgs(), "fetch", "--quiet", "origin", "+refs/heads/"+branchName+":"+remoteRef)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = localRepoPath
+	if out, err := cmd.CombinedOutput(); err != nil {
+		// The branch doesn't exist on origin yet, which is the normal case for a first sync.
+		logger.Debug("No previous push of sync branch", zap.String("module", moduleName), zap.String("branch", branchName), zap.String("output", string(out)))
+		return nil
+	}
+	commit, err := runGit(localRepoPath, logger, moduleName, "rev-parse", remoteRef)
+	if err != nil {
+		return nil
+	}
+	previous := &previousPush{Commit: strings.TrimSpace(commit)}
+	if rel, err := filepath.Rel(localRepoPath, filepath.Join(moduleDir, config.AvmVersionFileName)); err == nil {
+		cmd := exec.Command("git", "show", previous.Commit+":"+filepath.ToSlash(rel))
+		cmd.Dir = localRepoPath
+		if content, err := cmd.Output(); err == nil {
+			previous.Tag = parseAvmVersionTag(string(content))
+		}
+	}
+	return previous
+}
+
+// resyncComment summarises what changed in a module since the previous push of its sync
+// branch, for posting on the pull request that the force-push updated.
+func resyncComment(localRepoPath string, previous *previousPush, moduleDir string, moduleName string, latestAvmTag string, latestAvmCommit string, logger *zap.Logger) string {
+	var sb strings.Builder
+	if previous.Changes != nil {
+		sb.WriteString("The sync branch was updated with upstream tag `" + latestAvmTag + "`")
+	} else {
+		sb.WriteString("The sync branch was force-pushed with upstream tag `" + latestAvmTag + "`")
+	}
+	if latestAvmCommit != "" {
+		sb.WriteString(" (commit `" + shortCommit(latestAvmCommit) + "`)")
+	}
+	sb.WriteString(".\n\n")
+	if previous.Tag != "" && previous.Tag != latestAvmTag {
+		sb.WriteString("The previous push synced tag `" + previous.Tag + "`.\n\n")
+	} else if previous.Tag == latestAvmTag {
+		sb.WriteString("The upstream tag is unchanged since the previous push.\n\n")
+	}
+	if previous.Changes != nil {
+		added, changed, deleted := changeCounts(previous.Changes)
+		sb.WriteString(fmt.Sprintf("Changes since the previous push: %d files added, %d changed, %d deleted\n", added, changed, deleted))
+		return sb.String()
+	}
+	stat, err := runGit(localRepoPath, logger, moduleName, "diff", "--shortstat", previous.Commit, "HEAD", "--", moduleDir)
+	if err == nil && strings.TrimSpace(stat) != "" {
+		sb.WriteString("Changes since the previous push: " + strings.TrimSpace(stat) + "\n")
+	} else if err == nil {
+		sb.WriteString("The module content is unchanged since the previous push.\n")
+	}
+	return sb.String()
+}
+
+// shortCommit abbreviates a commit hash for display.
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// createOrUpdatePullRequest opens a pull request for opts. When an active pull request already
+// exists for the source branch, e.g. on a re-run after a force-push, that pull request's title
+// and description are replaced, comment (if any) is posted, its reviewers are re-applied, its
+// classification labels are replaced with the current ones and its draft state is updated. Returns the created or updated pull request, or nil when the existing pull
+// request could not be found.
+func createOrUpdatePullRequest(host repohost.RepositoryHost, ctx context.Context, opts repohost.PullRequestOptions, comment string, logName string, logger *zap.Logger) (*repohost.PullRequest, error) {
+	pr, err := host.CreatePullRequest(ctx, opts)
+	if err == nil {
+		logger.Info("Created pull request", zap.String("module", logName), zap.Int("prId", pr.ID))
+		return pr, nil
+	}
+	if !isActivePullRequestError(err) {
+		logger.Error("Failed to create pull request", zap.String("module", logName), zap.Error(err))
+		return nil, err
+	}
+
+	existing, err := host.FindPullRequest(ctx, opts.SourceBranch, opts.TargetBranch)
+	if err != nil {
+		logger.Error("Failed to look up existing pull request", zap.String("module", logName), zap.String("branch", opts.SourceBranch), zap.Error(err))
+		return nil, err
+	}
+	if existing == nil {
+		logger.Warn("Pull request reported as existing but could not be found", zap.String("module", logName), zap.String("branch", opts.SourceBranch))
+		return nil, nil
+	}
+	logger.Info("Pull request already exists for branch, updating it", zap.String("module", logName), zap.String("branch", opts.SourceBranch), zap.Int("prId", existing.ID))
+
+	updated, err := host.UpdatePullRequest(ctx, existing.ID, opts.Title, opts.Description)
+	if err != nil {
+		logger.Error("Failed to update pull request", zap.String("module", logName), zap.Int("prId", existing.ID), zap.Error(err))
+		return nil, err
+	}
+	if comment != "" {
+		if err := host.AddComment(ctx, existing.ID, comment); err != nil {
+			logger.Warn("Failed to comment on pull request", zap.String("module", logName), zap.Int("prId", existing.ID), zap.Error(err))
+		}
+	}
+	if err := host.AddReviewers(ctx, existing.ID, opts.Reviewers); err != nil {
+		logger.Warn("Failed to re-apply pull request reviewers", zap.String("module", logName), zap.Int("prId", existing.ID), zap.Error(err))
+	}
+	if err := host.SetLabels(ctx, existing.ID, opts.Labels, config.ClassificationLabelPrefix); err != nil {
+		logger.Warn("Failed to re-apply pull request labels", zap.String("module", logName), zap.Int("prId", existing.ID), zap.Error(err))
+	}
+	if existing.IsDraft != opts.Draft {
+		if err := host.SetDraft(ctx, existing.ID, opts.Draft); errors.Is(err, repohost.ErrNotSupported) {
+			logger.Warn("Repository host cannot change the draft state of pull request", zap.String("module", logName), zap.Int("prId", existing.ID), zap.Bool("draft", opts.Draft))
+		} else if err != nil {
+			logger.Warn("Failed to update pull request draft state", zap.String("module", logName), zap.Int("prId", existing.ID), zap.Bool("draft", opts.Draft), zap.Error(err))
+		} else {
+			updated.IsDraft = opts.Draft
+		}
+	}
+	if updated.ID == 0 {
+		updated.ID = existing.ID
+	}
+	return updated, nil
+}
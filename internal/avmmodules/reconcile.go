@@ -0,0 +1,256 @@
+package avmmodules
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+	"go.uber.org/zap"
+)
+
+// ReconciledBranch records a stale sync branch cleaned up by --reconcile-sync-branches.
+type ReconciledBranch struct {
+	Branch string `json:"branch"`
+	Module string `json:"module"`
+	Reason string `json:"reason"`
+	// PullRequestID is the abandoned pull request of the branch, 0 when it had none.
+	PullRequestID int  `json:"pullRequestId,omitempty"`
+	Deleted       bool `json:"deleted"`
+}
+
+// syncBranchState is what the run found out about a module's sync branch. Reason explains why
+// the branch no longer corresponds to a pending change, and is "" when it does or may.
+type syncBranchState struct {
+	Module string
+	Reason string
+}
+
+// syncNameData returns the naming data of a module's sync that is known before its version
+// state is read.
+func syncNameData[T Module](module T, moduleName string, targetBranch string) SyncNameData {
+	return SyncNameData{
+		ModuleName:         moduleName,
+		UpstreamModuleName: module.GetModuleName(),
+		Origin:             module.GetOrigin(),
+		ProviderNamespace:  module.GetNameTemplateData().ProviderNamespace,
+		BranchPrefix:       config.BranchPrefix,
+		TargetBranch:       targetBranch,
+	}
+}
+
+// recordSyncBranch records the state of the sync branch data renders to. A branch that any
+// module of the run still needs is never marked stale by another.
+func (p *ModuleProcessor) recordSyncBranch(data SyncNameData, reason string) {
+	branch, err := p.Templates.BranchName(data)
+	if err != nil {
+		p.Logger.Debug("Sync branch name unknown, not reconciling it", zap.String("module", data.ModuleName), zap.Error(err))
+		return
+	}
+	if p.syncBranches == nil {
+		p.syncBranches = map[string]syncBranchState{}
+	}
+	if previous, ok := p.syncBranches[branch]; ok && previous.Reason == "" {
+		return
+	}
+	p.syncBranches[branch] = syncBranchState{Module: data.ModuleName, Reason: reason}
+}
+
+// recordUpToDateSync marks the sync branch of a module skipped because its target branch is
+// already at or past the upstream tag as stale.
+func (p *ModuleProcessor) recordUpToDateSync(module Module, moduleName string, targetBranch string, lastSyncedTag string, latestAvmTag string) {
+	data := syncNameData(module, moduleName, targetBranch)
+	data.PreviousTag, data.LatestTag = lastSyncedTag, latestAvmTag
+	p.recordSyncBranch(data, fmt.Sprintf("%s already has %s at %s, so a newer sync has been merged", targetBranch, moduleName, lastSyncedTag))
+}
+
+// recordFilteredModule marks the sync branch of a module left out of the run by the exclusion
+// list or its status as stale.
+func recordFilteredModule[T Module](p *ModuleProcessor, module T, nameTransformer ModuleNameTransformer, reason string) {
+	moduleName := nameTransformer(module.GetModuleName())
+	p.recordSyncBranch(syncNameData(module, moduleName, p.TargetBranches.TargetBranchFor(module)), moduleName+" "+reason)
+}
+
+// ReconcileSyncBranches abandons the active sync pull requests and deletes the sync branches
+// that no longer correspond to a pending change. Every pull request of the sync identity and
+// every branch under --branch-prefix is checked: those of modules that were excluded or whose
+// status is no longer synced, those whose target branch already has the upstream tag because a
+// newer sync was merged, and those no module in the index syncs to any more, because the module
+// was removed from the index or renamed, are stale, as are aggregate branches the run didn't
+// push and, in --aggregate-pull-requests mode, the sync branches of modules committed onto an
+// aggregate branch. Each abandoned pull request gets a comment explaining why. Only pull
+// requests created by the sync identity and branches whose last commit is authored by
+// --module-sync-author-email are touched; branches of modules still in the index that the run
+// didn't look at are left alone. Does nothing unless --reconcile-sync-branches is set.
+func (p *ModuleProcessor) ReconcileSyncBranches(localRepoPath string) error {
+	if !config.ReconcileSyncBranches {
+		return nil
+	}
+	logger := p.Logger
+	prs, err := p.Host.ListPullRequests(p.Context, config.BranchPrefix)
+	if err != nil {
+		logger.Error("Failed to list sync pull requests", zap.Error(err))
+		return err
+	}
+	pullRequests := map[string]repohost.PullRequest{}
+	for _, pr := range prs {
+		pullRequests[pr.SourceBranch] = pr
+	}
+	branches, err := listRemoteBranches(localRepoPath, config.BranchPrefix, logger)
+	if err != nil {
+		return err
+	}
+
+	var candidates []string
+	for branch := range pullRequests {
+		candidates = append(candidates, branch)
+	}
+	for branch := range branches {
+		if _, ok := pullRequests[branch]; !ok {
+			candidates = append(candidates, branch)
+		}
+	}
+	sort.Strings(candidates)
+	indexBranches, mapped := p.indexSyncBranches()
+	for _, branch := range candidates {
+		state, ok := p.syncBranches[branch]
+		switch {
+		case ok && state.Reason == "":
+			continue
+		case ok:
+		case p.isReservedSyncBranch(branch):
+			continue
+		case strings.HasPrefix(branch, config.BranchPrefix+config.AggregateBranchName):
+			state = syncBranchState{Reason: fmt.Sprintf("the run pushed %d aggregate branches and %s isn't one of them", p.aggregateParts, branch)}
+		case !mapped:
+			logger.Debug("Sync branch template uses the module version, not reconciling branch the run didn't look at", zap.String("branch", branch))
+			continue
+		case indexBranches[branch] != "":
+			continue
+		default:
+			state = syncBranchState{Reason: "no module in the index syncs to " + branch + " any more, as its module was removed from the index or renamed"}
+		}
+		p.reconcileSyncBranch(localRepoPath, branch, state, pullRequests, branches)
+	}
+	return nil
+}
+
+// reconcileSyncBranch deletes a stale sync branch and abandons its pull request, commenting
+// why. The branch is deleted first so the comment can say whether it was.
+func (p *ModuleProcessor) reconcileSyncBranch(localRepoPath string, branch string, state syncBranchState, pullRequests map[string]repohost.PullRequest, branches map[string]string) {
+	logger := p.Logger
+	pr, hasPullRequest := pullRequests[branch]
+	commit, hasBranch := branches[branch]
+	if hasBranch && !isSyncAuthoredCommit(localRepoPath, branch, commit, logger) {
+		logger.Info("Stale sync branch has commits from someone else, leaving it", zap.String("branch", branch), zap.String("reason", state.Reason))
+		return
+	}
+	reconciled := ReconciledBranch{Branch: branch, Module: state.Module, Reason: state.Reason}
+	if hasBranch {
+		args := append(gitAuthArgs(), "push", "origin", "--delete", branch)
+		if _, err := runGit(localRepoPath, logger, state.Module, args...); err != nil {
+			logger.Error("Failed to delete stale sync branch", zap.String("branch", branch), zap.String("reason", state.Reason), zap.Error(err))
+		} else {
+			reconciled.Deleted = true
+			logger.Info("Deleted stale sync branch", zap.String("branch", branch), zap.String("reason", state.Reason))
+		}
+	}
+	if hasPullRequest {
+		comment := fmt.Sprintf("This pull request was abandoned by the AVM module sync because %s, so it no longer corresponds to a pending change.", state.Reason)
+		if reconciled.Deleted {
+			comment += " Its source branch has been deleted."
+		}
+		if err := p.Host.AddComment(p.Context, pr.ID, comment); err != nil {
+			logger.Warn("Failed to comment on stale pull request", zap.String("branch", branch), zap.Int("prId", pr.ID), zap.Error(err))
+		}
+		if err := p.Host.AbandonPullRequest(p.Context, pr.ID); err != nil {
+			logger.Error("Failed to abandon stale pull request", zap.String("branch", branch), zap.Int("prId", pr.ID), zap.Error(err))
+		} else {
+			reconciled.PullRequestID = pr.ID
+			logger.Info("Abandoned stale sync pull request", zap.String("branch", branch), zap.Int("prId", pr.ID), zap.String("reason", state.Reason))
+		}
+	}
+	if reconciled.Deleted || reconciled.PullRequestID != 0 {
+		p.Report.AddReconciledBranch(reconciled)
+	}
+}
+
+// indexSyncBranches returns the sync branch of every module in the index, mapped to its module
+// name. Returns false when the branch template depends on a module's version, as a branch then
+// can't be mapped back to its module without knowing the version it was pushed for.
+func (p *ModuleProcessor) indexSyncBranches() (map[string]string, bool) {
+	if p.Modules == nil || p.Modules.Names == nil {
+		return nil, false
+	}
+	branches := map[string]string{}
+	for _, module := range p.Modules.allModules() {
+		moduleName := p.Modules.Names.Transform(module.GetModuleName())
+		data := syncNameData(module, moduleName, p.TargetBranches.TargetBranchFor(module))
+		branch, err := p.Templates.BranchName(data)
+		if err != nil {
+			return nil, false
+		}
+		data.PreviousTag, data.LatestTag, data.Bump, data.Classification = "v0.0.0", "v1.0.0", "major", "forced"
+		if versioned, err := p.Templates.BranchName(data); err != nil || versioned != branch {
+			return nil, false
+		}
+		branches[branch] = moduleName
+	}
+	return branches, true
+}
+
+// isReservedSyncBranch reports whether a branch under --branch-prefix is one of the branches the
+// sync pushes for something other than a single module sync: one of the aggregate branches this
+// run pushed, or a rename, lifecycle or index snapshot branch. Aggregate branches of parts the
+// run didn't reach, e.g. after --aggregate-max-modules was raised, aren't reserved.
+func (p *ModuleProcessor) isReservedSyncBranch(branch string) bool {
+	if part, ok := strings.CutPrefix(branch, config.BranchPrefix+config.AggregateBranchName); ok {
+		n, err := strconv.Atoi(part)
+		return err == nil && n >= 1 && n <= p.aggregateParts
+	}
+	for _, prefix := range []string{"rename/", "lifecycle/", "index-snapshot"} {
+		if strings.HasPrefix(branch, config.BranchPrefix+prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listRemoteBranches returns the branches on origin starting with prefix, mapped to the commit
+// they point to.
+func listRemoteBranches(localRepoPath string, prefix string, logger *zap.Logger) (map[string]string, error) {
+	args := append(gitAuthArgs(), "ls-remote", "--heads", "origin", "refs/heads/"+prefix+"*")
+	out, err := runGit(localRepoPath, logger, "", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing sync branches: %w", err)
+	}
+	branches := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		commit, ref, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if ok && strings.HasPrefix(ref, "refs/heads/"+prefix) {
+			branches[strings.TrimPrefix(ref, "refs/heads/")] = commit
+		}
+	}
+	return branches, nil
+}
+
+// isSyncAuthoredCommit reports whether the commit at the tip of a remote branch was authored by
+// the sync identity, fetching it first when it isn't available locally.
+func isSyncAuthoredCommit(localRepoPath string, branch string, commit string, logger *zap.Logger) bool {
+	cmd := exec.Command("git", "cat-file", "-e", commit+"^{commit}")
+	cmd.Dir = localRepoPath
+	if err := cmd.Run(); err != nil {
+		args := append(gitAuthArgs(), "fetch", "--quiet", "origin", "+refs/heads/"+branch+":refs/remotes/origin/"+branch)
+		if _, err := runGit(localRepoPath, logger, branch, args...); err != nil {
+			return false
+		}
+	}
+	author, err := runGit(localRepoPath, logger, branch, "log", "-1", "--format=%ae", commit)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(author), config.ModuleSyncAuthorEmail)
+}
@@ -0,0 +1,217 @@
+package avmmodules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RunReport collects what happened during a run so it can be written out once the run is
+// complete. It is safe for concurrent use.
+type RunReport struct {
+	mu        sync.Mutex
+	StartedAt time.Time  `json:"startedAt"`
+	IndexDiff *IndexDiff `json:"indexDiff,omitempty"`
+	// IndexIssues lists the problems found while validating the module indexes.
+	IndexIssues []IndexIssue `json:"indexIssues,omitempty"`
+	// ModuleHierarchy maps each synced parent resource module to its child modules.
+	ModuleHierarchy map[string][]string `json:"moduleHierarchy,omitempty"`
+	// InterfaceDiffs lists the Terraform interface changes of the modules synced in this run.
+	InterfaceDiffs []*InterfaceDiff `json:"interfaceDiffs,omitempty"`
+	// CommitSigning is the signing configuration of the run, nil when commits aren't signed.
+	CommitSigning *CommitSigning `json:"commitSigning,omitempty"`
+	// CommitSignatures records whether each commit made by the run is signed.
+	CommitSignatures []CommitSignature `json:"commitSignatures,omitempty"`
+	// ReconciledBranches lists the stale sync branches and pull requests cleaned up by the run.
+	ReconciledBranches []ReconciledBranch `json:"reconciledBranches,omitempty"`
+	// VersionTags lists the module version tags created by the run.
+	VersionTags []VersionTag `json:"versionTags,omitempty"`
+}
+
+// NewRunReport returns an empty report for a run starting now.
+func NewRunReport() *RunReport {
+	return &RunReport{StartedAt: time.Now().UTC()}
+}
+
+// SetIndexDiff records the difference between the previous index snapshot and this run's index.
+func (r *RunReport) SetIndexDiff(diff *IndexDiff) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.IndexDiff = diff
+}
+
+// SetIndexIssues records the problems found while validating the module indexes.
+func (r *RunReport) SetIndexIssues(issues []IndexIssue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.IndexIssues = issues
+}
+
+// SetModuleHierarchy records the parent/child relationships of the synced resource modules.
+func (r *RunReport) SetModuleHierarchy(hierarchy map[string][]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ModuleHierarchy = hierarchy
+}
+
+// AddInterfaceDiff records the Terraform interface changes of a synced module.
+func (r *RunReport) AddInterfaceDiff(diff *InterfaceDiff) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.InterfaceDiffs = append(r.InterfaceDiffs, diff)
+}
+
+// SetCommitSigning records the signing configuration of the run.
+func (r *RunReport) SetCommitSigning(signing *CommitSigning) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.CommitSigning = signing
+}
+
+// AddCommitSignature records whether a commit made by the run is signed.
+func (r *RunReport) AddCommitSignature(signature CommitSignature) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.CommitSignatures = append(r.CommitSignatures, signature)
+}
+
+// AddReconciledBranch records a stale sync branch cleaned up by the run.
+func (r *RunReport) AddReconciledBranch(branch ReconciledBranch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ReconciledBranches = append(r.ReconciledBranches, branch)
+}
+
+// AddVersionTag records a module version tag created by the run.
+func (r *RunReport) AddVersionTag(tag VersionTag) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.VersionTags = append(r.VersionTags, tag)
+}
+
+// writeHierarchy renders module and its descendants as a nested Markdown list.
+func writeHierarchy(sb *strings.Builder, hierarchy map[string][]string, module string, depth int, seen map[string]bool) {
+	sb.WriteString(strings.Repeat("  ", depth) + "- " + module + "\n")
+	if seen[module] {
+		return
+	}
+	seen[module] = true
+	for _, child := range hierarchy[module] {
+		writeHierarchy(sb, hierarchy, child, depth+1, seen)
+	}
+}
+
+// Markdown renders the report as a Markdown document.
+func (r *RunReport) Markdown() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sb strings.Builder
+	sb.WriteString("# AVM module sync report\n\n")
+	sb.WriteString("Run started " + r.StartedAt.Format(time.RFC3339) + "\n")
+	if r.IndexDiff != nil {
+		sb.WriteString("\n## Index changes since last snapshot\n\n")
+		sb.WriteString(r.IndexDiff.Markdown())
+	}
+	if len(r.ModuleHierarchy) > 0 {
+		sb.WriteString("\n## Module hierarchy\n\n")
+		isChild := map[string]bool{}
+		var parents []string
+		for parent, children := range r.ModuleHierarchy {
+			parents = append(parents, parent)
+			for _, child := range children {
+				isChild[child] = true
+			}
+		}
+		sort.Strings(parents)
+		seen := map[string]bool{}
+		for _, parent := range parents {
+			if !isChild[parent] {
+				writeHierarchy(&sb, r.ModuleHierarchy, parent, 0, seen)
+			}
+		}
+	}
+	if len(r.InterfaceDiffs) > 0 {
+		sb.WriteString("\n## Interface changes\n")
+		for _, diff := range r.InterfaceDiffs {
+			heading := diff.Module
+			if diff.IsBreaking() {
+				heading += " (breaking)"
+			}
+			sb.WriteString("\n### " + heading + "\n\n" + diff.Markdown())
+		}
+	}
+	if r.CommitSigning != nil || len(r.CommitSignatures) > 0 {
+		sb.WriteString("\n## Commit signing\n\n")
+		if r.CommitSigning != nil {
+			sb.WriteString("Commits are signed with the " + r.CommitSigning.Format + " key `" + r.CommitSigning.Key + "` from " + r.CommitSigning.KeySource + ".\n")
+		} else {
+			sb.WriteString("Commit signing is not configured.\n")
+		}
+		if len(r.CommitSignatures) > 0 {
+			sb.WriteString("\n| Module | Commit | Signed |\n| --- | --- | --- |\n")
+			for _, signature := range r.CommitSignatures {
+				signed := "no"
+				if signature.Signed {
+					signed = "yes"
+				}
+				sb.WriteString("| " + signature.Module + " | `" + shortCommit(signature.Commit) + "` | " + signed + " |\n")
+			}
+		}
+	}
+	if len(r.ReconciledBranches) > 0 {
+		sb.WriteString("\n## Reconciled sync branches\n\n| Branch | Pull request | Branch deleted | Reason |\n| --- | --- | --- | --- |\n")
+		for _, branch := range r.ReconciledBranches {
+			pr, deleted := "-", "no"
+			if branch.PullRequestID != 0 {
+				pr = "abandoned #" + strconv.Itoa(branch.PullRequestID)
+			}
+			if branch.Deleted {
+				deleted = "yes"
+			}
+			sb.WriteString("| " + branch.Branch + " | " + pr + " | " + deleted + " | " + branch.Reason + " |\n")
+		}
+	}
+	if len(r.VersionTags) > 0 {
+		sb.WriteString("\n## Version tags\n\n| Tag | Commit |\n| --- | --- |\n")
+		for _, tag := range r.VersionTags {
+			sb.WriteString("| " + tag.Name + " | `" + shortCommit(tag.Commit) + "` |\n")
+		}
+	}
+	if len(r.IndexIssues) > 0 {
+		sb.WriteString("\n## Index validation\n\n| Severity | Index | Module | Issue |\n| --- | --- | --- | --- |\n")
+		for _, issue := range r.IndexIssues {
+			sb.WriteString("| " + issue.Severity + " | " + issue.Index + " | " + issue.Module + " | " + issue.Message + " |\n")
+		}
+	}
+	return sb.String()
+}
+
+// Write saves the report to path, as JSON when the path ends in .json and as Markdown otherwise.
+func (r *RunReport) Write(path string, logger *zap.Logger) error {
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		r.mu.Lock()
+		var err error
+		data, err = json.MarshalIndent(r, "", "  ")
+		r.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("error encoding run report: %w", err)
+		}
+	} else {
+		data = []byte(r.Markdown())
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		logger.Error("Failed to write run report", zap.String("path", path), zap.Error(err))
+		return err
+	}
+	logger.Info("Wrote run report", zap.String("path", path))
+	return nil
+}
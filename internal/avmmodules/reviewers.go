@@ -0,0 +1,121 @@
+package avmmodules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
+)
+
+// ReviewerRule assigns reviewers to the modules it matches. A module matches when its AVM name
+// matches one of Modules, glob patterns such as "avm-res-network-*" (a * doesn't cross a / in
+// Bicep module paths), or when its provider namespace is one of ProviderNamespaces. Reviewers
+// are identities as accepted by the repository host, e.g. ADO email addresses or
+// "[Project]\Team" names.
+type ReviewerRule struct {
+	Modules            []string `json:"modules,omitempty"`
+	ProviderNamespaces []string `json:"providerNamespaces,omitempty"`
+	Required           []string `json:"required,omitempty"`
+	Optional           []string `json:"optional,omitempty"`
+}
+
+// ReviewerMapping is the set of rules loaded from --reviewer-mapping-file.
+type ReviewerMapping struct {
+	Rules []ReviewerRule
+}
+
+// LoadReviewerMapping reads the optional reviewer mapping file, a JSON array of rules, e.g.
+// [{"modules": ["avm-res-network-*"], "providerNamespaces": ["Microsoft.Network"],
+// "required": ["[Platform]\\Network Team"], "optional": ["jane@example.com"]}]. Returns an
+// empty mapping when no file is configured.
+func LoadReviewerMapping(filePath string) (*ReviewerMapping, error) {
+	mapping := &ReviewerMapping{}
+	if filePath == "" {
+		return mapping, nil
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading reviewer mapping file: %w", err)
+	}
+	if err := json.Unmarshal(data, &mapping.Rules); err != nil {
+		return nil, fmt.Errorf("error parsing reviewer mapping file %s: %w", filePath, err)
+	}
+	for i, rule := range mapping.Rules {
+		for _, pattern := range rule.Modules {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("reviewer mapping rule %d has invalid module pattern %q: %w", i, pattern, err)
+			}
+		}
+		if len(rule.Required) == 0 && len(rule.Optional) == 0 {
+			return nil, fmt.Errorf("reviewer mapping rule %d has no required or optional reviewers", i)
+		}
+	}
+	return mapping, nil
+}
+
+// matches reports whether the rule applies to module.
+func (r ReviewerRule) matches(module Module) bool {
+	for _, pattern := range r.Modules {
+		if ok, _ := path.Match(pattern, module.GetModuleName()); ok {
+			return true
+		}
+	}
+	namespace := module.GetNameTemplateData().ProviderNamespace
+	for _, ns := range r.ProviderNamespaces {
+		if namespace != "" && strings.EqualFold(ns, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReviewersFor returns the reviewers of every rule matching module, in rule order. An identity
+// that is required by any matching rule is required even if another rule lists it as optional.
+func (m *ReviewerMapping) ReviewersFor(module Module) []repohost.Reviewer {
+	if m == nil {
+		return nil
+	}
+	var lists [][]repohost.Reviewer
+	for _, rule := range m.Rules {
+		if !rule.matches(module) {
+			continue
+		}
+		reviewers := make([]repohost.Reviewer, 0, len(rule.Required)+len(rule.Optional))
+		for _, identity := range rule.Required {
+			reviewers = append(reviewers, repohost.Reviewer{Identity: identity, Required: true})
+		}
+		lists = append(lists, append(reviewers, optionalReviewers(rule.Optional)...))
+	}
+	return mergeReviewers(lists...)
+}
+
+// mergeReviewers returns the union of reviewer lists in order of first appearance. An identity
+// required in any list is required.
+func mergeReviewers(lists ...[]repohost.Reviewer) []repohost.Reviewer {
+	var reviewers []repohost.Reviewer
+	index := map[string]int{}
+	for _, list := range lists {
+		for _, reviewer := range list {
+			key := strings.ToLower(reviewer.Identity)
+			if i, ok := index[key]; ok {
+				reviewers[i].Required = reviewers[i].Required || reviewer.Required
+				continue
+			}
+			index[key] = len(reviewers)
+			reviewers = append(reviewers, reviewer)
+		}
+	}
+	return reviewers
+}
+
+// optionalReviewers returns identities as optional reviewers.
+func optionalReviewers(identities []string) []repohost.Reviewer {
+	reviewers := make([]repohost.Reviewer, 0, len(identities))
+	for _, identity := range identities {
+		reviewers = append(reviewers, repohost.Reviewer{Identity: identity})
+	}
+	return reviewers
+}
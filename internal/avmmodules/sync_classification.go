@@ -0,0 +1,56 @@
+package avmmodules
+
+import (
+	"github.com/theonlyway/avm-module-sync/internal/config"
+)
+
+// Classifications of a module sync, applied to its pull request as labels prefixed with
+// config.ClassificationLabelPrefix.
+const (
+	SyncNewModule    = "new-module"
+	SyncPatch        = BumpPatch
+	SyncMinor        = BumpMinor
+	SyncMajor        = BumpMajor
+	SyncBackfill     = "backfill"
+	SyncForced       = "forced-resync"
+	SyncPatchFailure = "patch-failure"
+)
+
+// classifySync returns how a module sync moves it: a backfill at a stored tag, a first sync of
+// a new module, a patch, minor or major version bump, or a forced re-sync of an unchanged tag.
+// Returns "" for other re-syncs, e.g. a tag that moved to another commit or tags that aren't
+// semantic versions.
+func classifySync(lastSyncedTag string, latestAvmTag string, backfill bool, forced bool) string {
+	switch {
+	case backfill:
+		return SyncBackfill
+	case lastSyncedTag == "":
+		return SyncNewModule
+	}
+	if bump := semverBump(lastSyncedTag, latestAvmTag); bump != "" {
+		return bump
+	}
+	if forced {
+		return SyncForced
+	}
+	return ""
+}
+
+// classificationLabels returns the pull request labels for a sync classification, plus the
+// patch-failure label when patches failed to apply.
+func classificationLabels(classification string, patchesFailed bool) []string {
+	var labels []string
+	if classification != "" {
+		labels = append(labels, config.ClassificationLabelPrefix+classification)
+	}
+	if patchesFailed {
+		labels = append(labels, config.ClassificationLabelPrefix+SyncPatchFailure)
+	}
+	return labels
+}
+
+// isDraftSync reports whether a sync's pull request is opened as a draft: major bumps and
+// syncs whose patches failed need attention before they can be reviewed.
+func isDraftSync(classification string, patchesFailed bool) bool {
+	return classification == SyncMajor || patchesFailed
+}
@@ -0,0 +1,126 @@
+package avmmodules
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+)
+
+// SyncNameData is the data made available to the sync branch name, commit message and pull
+// request title templates. Bump is patch, minor or major, or "" when the sync isn't a version
+// bump; Classification also covers new modules, backfills and forced re-syncs. CommitType and
+// Breaking are only known once the module has been copied, so the branch name template can't
+// use them.
+type SyncNameData struct {
+	ModuleName         string
+	UpstreamModuleName string
+	Origin             string
+	ProviderNamespace  string
+	BranchPrefix       string
+	TargetBranch       string
+	PreviousTag        string
+	LatestTag          string
+	Bump               string
+	Classification     string
+	CommitType         string
+	Breaking           bool
+}
+
+// SyncTemplates holds the parsed sync branch name, commit message and pull request title
+// templates.
+type SyncTemplates struct {
+	branch        *template.Template
+	commitMessage *template.Template
+	title         *template.Template
+}
+
+// LoadSyncTemplates parses the --sync-branch-template, --commit-message-template and
+// --pr-title-template templates, falling back to the defaults for those not set. With
+// --semver-commit-types the default commit message and title carry the conventional commit type
+// of the version bump instead of chore. The templates have the same helpers as the module name
+// templates, e.g. {{ trimPrefix .ModuleName "rvm-" }}. The aggregate branch and pull request
+// title of --aggregate-pull-requests are fixed, so a custom branch or title template is rejected
+// in that mode.
+func LoadSyncTemplates() (*SyncTemplates, error) {
+	if config.AggregatePullRequests {
+		var unsupported []string
+		if config.SyncBranchTemplate != "" && config.SyncBranchTemplate != config.DefaultSyncBranchTemplate {
+			unsupported = append(unsupported, "--sync-branch-template")
+		}
+		if config.PullRequestTitleTemplate != "" && config.PullRequestTitleTemplate != config.DefaultPullRequestTitleTemplate {
+			unsupported = append(unsupported, "--pr-title-template")
+		}
+		if len(unsupported) > 0 {
+			return nil, fmt.Errorf("--aggregate-pull-requests can't be combined with %v", unsupported)
+		}
+	}
+	parse := func(name string, text string, fallback string) (*template.Template, error) {
+		if text == "" {
+			text = fallback
+		}
+		return parseNameTemplate(name, text)
+	}
+	commitMessageTemplate, titleTemplate := config.DefaultCommitMessageTemplate, config.DefaultPullRequestTitleTemplate
+	if config.SemverCommitTypes {
+		commitMessageTemplate, titleTemplate = config.DefaultSemverCommitMessageTemplate, config.DefaultSemverPullRequestTitleTemplate
+	}
+	if config.CommitMessageTemplate != config.DefaultCommitMessageTemplate {
+		commitMessageTemplate = config.CommitMessageTemplate
+	}
+	if config.PullRequestTitleTemplate != config.DefaultPullRequestTitleTemplate {
+		titleTemplate = config.PullRequestTitleTemplate
+	}
+	branch, err := parse("sync-branch", config.SyncBranchTemplate, config.DefaultSyncBranchTemplate)
+	if err != nil {
+		return nil, err
+	}
+	commitMessage, err := parse("commit-message", commitMessageTemplate, config.DefaultCommitMessageTemplate)
+	if err != nil {
+		return nil, err
+	}
+	title, err := parse("pr-title", titleTemplate, config.DefaultPullRequestTitleTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return &SyncTemplates{branch: branch, commitMessage: commitMessage, title: title}, nil
+}
+
+// renderSyncTemplate executes a sync template and returns the trimmed result, which must not be
+// empty.
+func renderSyncTemplate(tmpl *template.Template, data SyncNameData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("error rendering %s template for %s: %w", tmpl.Name(), data.ModuleName, err)
+	}
+	text := strings.TrimSpace(sb.String())
+	if text == "" {
+		return "", fmt.Errorf("%s template rendered an empty value for %s", tmpl.Name(), data.ModuleName)
+	}
+	return text, nil
+}
+
+// BranchName renders the name of a module's sync branch.
+func (t *SyncTemplates) BranchName(data SyncNameData) (string, error) {
+	name, err := renderSyncTemplate(t.branch, data)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(name, " \t\n~^:?*[\\") || strings.Contains(name, "..") {
+		return "", fmt.Errorf("%s template rendered %q for %s, which is not a valid branch name", t.branch.Name(), name, data.ModuleName)
+	}
+	return name, nil
+}
+
+// CommitMessage renders the commit message of a module sync.
+func (t *SyncTemplates) CommitMessage(data SyncNameData) (string, error) {
+	return renderSyncTemplate(t.commitMessage, data)
+}
+
+// PullRequestTitle renders the title of a module's sync pull request. The default names the
+// upstream tag so the title of a pull request refreshed by a re-sync reflects the tag it now
+// carries.
+func (t *SyncTemplates) PullRequestTitle(data SyncNameData) (string, error) {
+	return renderSyncTemplate(t.title, data)
+}
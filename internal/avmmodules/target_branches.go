@@ -0,0 +1,105 @@
+package avmmodules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"go.uber.org/zap"
+)
+
+// TargetBranchRule sends the sync pull requests of the modules matching one of Modules, glob
+// patterns on the AVM module name such as "avm-res-network-*", to TargetBranch.
+type TargetBranchRule struct {
+	Modules      []string `json:"modules"`
+	TargetBranch string   `json:"targetBranch"`
+}
+
+// TargetBranchMapping is the set of rules loaded from --target-branch-mapping-file.
+type TargetBranchMapping struct {
+	Rules []TargetBranchRule
+}
+
+// LoadTargetBranchMapping reads the optional target branch mapping file, a JSON array of rules,
+// e.g. [{"modules": ["avm-res-network-*"], "targetBranch": "release/network"}]. The first
+// matching rule wins. Returns an empty mapping when no file is configured.
+func LoadTargetBranchMapping(filePath string) (*TargetBranchMapping, error) {
+	mapping := &TargetBranchMapping{}
+	if filePath == "" {
+		return mapping, nil
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading target branch mapping file: %w", err)
+	}
+	if err := json.Unmarshal(data, &mapping.Rules); err != nil {
+		return nil, fmt.Errorf("error parsing target branch mapping file %s: %w", filePath, err)
+	}
+	for i, rule := range mapping.Rules {
+		if rule.TargetBranch == "" {
+			return nil, fmt.Errorf("target branch mapping rule %d has no targetBranch", i)
+		}
+		for _, pattern := range rule.Modules {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("target branch mapping rule %d has invalid module pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+	return mapping, nil
+}
+
+// TargetBranchFor returns the branch the sync pull request of module targets: the branch of the
+// first matching rule, or config.DefaultBranchName.
+func (m *TargetBranchMapping) TargetBranchFor(module Module) string {
+	if m != nil {
+		for _, rule := range m.Rules {
+			for _, pattern := range rule.Modules {
+				if ok, _ := path.Match(pattern, module.GetModuleName()); ok {
+					return rule.TargetBranch
+				}
+			}
+		}
+	}
+	return config.DefaultBranchName
+}
+
+// Branches returns the distinct target branches of the mapping.
+func (m *TargetBranchMapping) Branches() []string {
+	var branches []string
+	seen := map[string]bool{}
+	for _, rule := range m.Rules {
+		if !seen[rule.TargetBranch] {
+			seen[rule.TargetBranch] = true
+			branches = append(branches, rule.TargetBranch)
+		}
+	}
+	return branches
+}
+
+// FetchTargetBranches makes sure the remote-tracking ref of a --target-branch other than the
+// repository default and of every mapped target branch exists in the local repository,
+// fetching the ones a shallow or single-branch checkout left out. Sync branches are created
+// from these refs.
+func (p *ModuleProcessor) FetchTargetBranches(localRepoPath string) error {
+	branches := p.TargetBranches.Branches()
+	if config.DefaultBranchName != config.DefaultTargetBranch {
+		branches = append(branches, config.DefaultBranchName)
+	}
+	for _, branch := range branches {
+		cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+branch)
+		cmd.Dir = localRepoPath
+		if err := cmd.Run(); err == nil {
+			continue
+		}
+		p.Logger.Info("Fetching target branch", zap.String("branch", branch))
+		args := append(gitAuthArgs(), "fetch", "--quiet", "origin", "+refs/heads/"+branch+":refs/remotes/origin/"+branch)
+		if out, err := runGit(localRepoPath, p.Logger, "", args...); err != nil {
+			return fmt.Errorf("error fetching target branch %s: %w: %s", branch, err, strings.TrimSpace(out))
+		}
+	}
+	return nil
+}
@@ -1,54 +1,276 @@
 package avmmodules
 
 import (
-	"regexp"
+	"encoding/json"
+	"fmt"
+	"os"
 	"strings"
+	"text/template"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
 )
 
 // ModuleNameTransformer is a function type that transforms module names according to specific patterns.
 type ModuleNameTransformer func(string) string
 
-// resourceNameTransformer transforms resource module names from AVM format to RVM format.
-// Example: avm-res-compute-virtualmachine -> rvm-res-azurerm-compute-virtualmachine
-func resourceNameTransformer(name string) string {
-	var resourceRegex = regexp.MustCompile(`^(avm)-(res-)(.+)$`)
-	if matches := resourceRegex.FindStringSubmatch(name); len(matches) == 4 {
-		return "rvm-" + matches[2] + "azurerm-" + matches[3]
+// NameTemplateData is the data made available to the module name templates. ProviderNamespace
+// and ResourceType are only populated for resource modules.
+type NameTemplateData struct {
+	ModuleName        string
+	ProviderNamespace string
+	ResourceType      string
+}
+
+// nameTemplateFuncs are the helper functions available to the module name templates, e.g.
+// {{ trimPrefix .ModuleName "avm-res-" }} or {{ lower .ResourceType }}.
+var nameTemplateFuncs = template.FuncMap{
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"replace":    strings.ReplaceAll,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+}
+
+// ModuleNameIndex holds the transformed name of every module in the index together with the
+// reverse mapping back to the AVM module name. It is built once from the whole index so that
+// the transformation can be validated as injective before anything is cloned.
+type ModuleNameIndex struct {
+	Resource      ModuleNameTransformer
+	Pattern       ModuleNameTransformer
+	Utility       ModuleNameTransformer
+	BicepResource ModuleNameTransformer
+	BicepPattern  ModuleNameTransformer
+
+	resourceTmpl      *template.Template
+	patternTmpl       *template.Template
+	utilityTmpl       *template.Template
+	bicepResourceTmpl *template.Template
+	bicepPatternTmpl  *template.Template
+	forward           map[string]string // AVM module name -> transformed name
+	reverse           map[string]string // transformed name -> AVM module name
+}
+
+// parseNameTemplate parses a module name template, including the helper functions.
+func parseNameTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(nameTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s template %q: %w", name, text, err)
 	}
-	return name
+	return tmpl, nil
 }
 
-// patternNameTransformer transforms pattern module names from AVM format to RVM format.
-// Example: avm-ptn-network-hub -> rvm-pat-azurerm-network-hub
-func patternNameTransformer(name string) string {
-	var patternRegex = regexp.MustCompile(`^avm-(ptn)-(.*)$`)
-	if matches := patternRegex.FindStringSubmatch(name); len(matches) == 3 {
-		return "rvm-pat-azurerm-" + matches[2]
+// renderModuleName executes a module name template and returns the trimmed result.
+func renderModuleName(tmpl *template.Template, data NameTemplateData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("error rendering %s template for %s: %w", tmpl.Name(), data.ModuleName, err)
+	}
+	name := strings.TrimSpace(sb.String())
+	if name == "" {
+		return "", fmt.Errorf("%s template rendered an empty name for %s", tmpl.Name(), data.ModuleName)
 	}
-	return name
+	if strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("%s template rendered %q for %s, which is not a valid folder name", tmpl.Name(), name, data.ModuleName)
+	}
+	return name, nil
 }
 
-// utilityNameTransformer transforms utility module names from AVM format to RVM format.
-// Example: avm-utl-types -> rvm-utl-azurerm-types
-func utilityNameTransformer(name string) string {
-	var utilityRegex = regexp.MustCompile(`^avm-(utl)-(.*)$`)
-	if matches := utilityRegex.FindStringSubmatch(name); len(matches) == 3 {
-		return "rvm-" + matches[1] + "-azurerm-" + matches[2]
+// loadModuleNameMapping reads the optional name-mapping file, a JSON object of AVM module name
+// to target folder name, e.g. {"avm-res-keyvault-vault": "rvm-res-azurerm-keyvault"}. Entries
+// take precedence over the name templates, which allows a legacy folder name to be kept after
+// AVM renames a module. Returns an empty mapping when no file is configured.
+func loadModuleNameMapping(path string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if path == "" {
+		return mapping, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading module name mapping file: %w", err)
+	}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("error parsing module name mapping file %s: %w", path, err)
 	}
-	return name
+	return mapping, nil
 }
 
-// transformAvmModuleName applies the appropriate name transformer based on the AVM module's
-// type prefix (res, ptn, or utl). Names that don't match a known prefix are returned unchanged.
-func transformAvmModuleName(name string) string {
+// NewModuleNameIndex renders the name template for every module in the index, applies the
+// explicit name mapping on top, and validates that no two modules transform to the same name.
+// Modules use the name template of the index definition they were loaded from, falling back
+// to the template configured for their record type. All problems are collected and returned
+// together so a bad template or mapping can be fixed in one pass.
+func NewModuleNameIndex(modules *ModulesStruct, definitions []IndexDefinition) (*ModuleNameIndex, error) {
+	mapping, err := loadModuleNameMapping(config.ModuleNameMappingFile)
+	if err != nil {
+		return nil, err
+	}
+	idx := &ModuleNameIndex{
+		forward: map[string]string{},
+		reverse: map[string]string{},
+	}
+	if idx.resourceTmpl, err = parseNameTemplate("resource-name", config.ResourceNameTemplate); err != nil {
+		return nil, err
+	}
+	if idx.patternTmpl, err = parseNameTemplate("pattern-name", config.PatternNameTemplate); err != nil {
+		return nil, err
+	}
+	if idx.utilityTmpl, err = parseNameTemplate("utility-name", config.UtilityNameTemplate); err != nil {
+		return nil, err
+	}
+	if idx.bicepResourceTmpl, err = parseNameTemplate("bicep-resource-name", config.BicepResourceNameTemplate); err != nil {
+		return nil, err
+	}
+	if idx.bicepPatternTmpl, err = parseNameTemplate("bicep-pattern-name", config.BicepPatternNameTemplate); err != nil {
+		return nil, err
+	}
+
+	indexTmpls := map[string]*template.Template{}
+	for _, def := range definitions {
+		if def.NameTemplate == "" {
+			continue
+		}
+		if indexTmpls[def.Name], err = parseNameTemplate(def.Name+"-name", def.NameTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	var problems []string
+	problems = append(problems, addModulesToNameIndex(idx, idx.resourceTmpl, indexTmpls, mapping, modules.ResourceModules)...)
+	problems = append(problems, addModulesToNameIndex(idx, idx.patternTmpl, indexTmpls, mapping, modules.PatternModules)...)
+	problems = append(problems, addModulesToNameIndex(idx, idx.utilityTmpl, indexTmpls, mapping, modules.UtilityModules)...)
+	problems = append(problems, addModulesToNameIndex(idx, idx.bicepResourceTmpl, indexTmpls, mapping, modules.BicepResourceModules)...)
+	problems = append(problems, addModulesToNameIndex(idx, idx.bicepPatternTmpl, indexTmpls, mapping, modules.BicepPatternModules)...)
+	// Mapping entries for modules no longer in the index are kept so references to them can
+	// still be resolved, and so they continue to take part in collision detection.
+	for avmName, name := range mapping {
+		if _, ok := idx.forward[avmName]; ok {
+			continue
+		}
+		problems = append(problems, addNameToIndex(idx, avmName, name)...)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("module name transformation is not valid for the index:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	idx.Resource = idx.transformerFor(idx.resourceTmpl)
+	idx.Pattern = idx.transformerFor(idx.patternTmpl)
+	idx.Utility = idx.transformerFor(idx.utilityTmpl)
+	idx.BicepResource = idx.transformerFor(idx.bicepResourceTmpl)
+	idx.BicepPattern = idx.transformerFor(idx.bicepPatternTmpl)
+	return idx, nil
+}
+
+// addModulesToNameIndex renders the transformed name for each module, or takes it from the
+// explicit mapping, and records it in the index, returning a description of every render
+// failure or collision encountered. indexTmpls overrides tmpl for modules loaded from an index
+// definition with its own name template.
+func addModulesToNameIndex[T Module](idx *ModuleNameIndex, tmpl *template.Template, indexTmpls map[string]*template.Template, mapping map[string]string, modules []T) []string {
+	var problems []string
+	for _, module := range modules {
+		avmName := module.GetModuleName()
+		name, ok := mapping[avmName]
+		if !ok {
+			moduleTmpl := tmpl
+			if indexTmpl, ok := indexTmpls[module.GetIndexName()]; ok {
+				moduleTmpl = indexTmpl
+			}
+			var err error
+			name, err = renderModuleName(moduleTmpl, module.GetNameTemplateData())
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+		}
+		problems = append(problems, addNameToIndex(idx, avmName, name)...)
+	}
+	return problems
+}
+
+// addNameToIndex records a single AVM name to transformed name pair, returning a description
+// of the collision if the pair conflicts with one already in the index.
+func addNameToIndex(idx *ModuleNameIndex, avmName string, name string) []string {
+	if strings.TrimSpace(name) == "" || strings.ContainsAny(name, `/\`) {
+		return []string{fmt.Sprintf("%q is not a valid folder name for %s", name, avmName)}
+	}
+	if existing, ok := idx.forward[avmName]; ok && existing != name {
+		return []string{fmt.Sprintf("%s appears more than once in the index with different transformed names (%s, %s)", avmName, existing, name)}
+	}
+	if other, ok := idx.reverse[name]; ok && other != avmName {
+		return []string{fmt.Sprintf("%s and %s both transform to %s", other, avmName, name)}
+	}
+	idx.forward[avmName] = name
+	idx.reverse[name] = avmName
+	return nil
+}
+
+// transformerFor returns a ModuleNameTransformer for one module kind. Names present in the
+// index resolve through the validated mapping; anything else is rendered from the template
+// with only the module name available, falling back to the name unchanged on error.
+func (idx *ModuleNameIndex) transformerFor(tmpl *template.Template) ModuleNameTransformer {
+	return func(name string) string {
+		if transformed, ok := idx.forward[name]; ok {
+			return transformed
+		}
+		transformed, err := renderModuleName(tmpl, NameTemplateData{ModuleName: name})
+		if err != nil {
+			return name
+		}
+		return transformed
+	}
+}
+
+// Transform applies the appropriate name transformer based on the AVM module's type prefix
+// (res, ptn, or utl for Terraform; avm/res/ or avm/ptn/ for Bicep). Names that don't match a
+// known prefix are returned unchanged.
+func (idx *ModuleNameIndex) Transform(name string) string {
+	if transformed, ok := idx.forward[name]; ok {
+		return transformed
+	}
 	switch {
 	case strings.HasPrefix(name, "avm-res-"):
-		return resourceNameTransformer(name)
+		return idx.Resource(name)
 	case strings.HasPrefix(name, "avm-ptn-"):
-		return patternNameTransformer(name)
+		return idx.Pattern(name)
 	case strings.HasPrefix(name, "avm-utl-"):
-		return utilityNameTransformer(name)
+		return idx.Utility(name)
+	case strings.HasPrefix(name, "avm/res/"):
+		return idx.BicepResource(name)
+	case strings.HasPrefix(name, "avm/ptn/"):
+		return idx.BicepPattern(name)
 	default:
 		return name
 	}
 }
+
+// Lookup returns the AVM module name that transforms to the given name, if any.
+func (idx *ModuleNameIndex) Lookup(transformedName string) (string, bool) {
+	avmName, ok := idx.reverse[transformedName]
+	return avmName, ok
+}
+
+// transformModuleSet transforms the names of the whole filtered module set before anything is
+// cloned. Collisions between transformed names are already rejected by NewModuleNameIndex; this
+// fails when a transformed name matches another module's AVM name, since that is the temporary
+// clone path of the other module and renaming into it would destroy the clone.
+func transformModuleSet[T Module](modules []T, nameTransformer ModuleNameTransformer) (map[string]string, error) {
+	transformed := map[string]string{}
+	clonePaths := map[string]bool{}
+	for _, module := range modules {
+		clonePaths[module.GetModuleName()] = true
+	}
+
+	var problems []string
+	for _, module := range modules {
+		avmName := module.GetModuleName()
+		name := nameTransformer(avmName)
+		if name != avmName && clonePaths[name] {
+			problems = append(problems, fmt.Sprintf("%s transforms to %s, which is the clone path of another module", avmName, name))
+			continue
+		}
+		transformed[avmName] = name
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("transformed module names collide with clone paths:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return transformed, nil
+}
@@ -2,76 +2,221 @@ package avmmodules
 
 import (
 	"context"
+	"strings"
 	"sync"
 
 	"github.com/google/uuid"
 	"github.com/theonlyway/avm-module-sync/internal/ado"
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"github.com/theonlyway/avm-module-sync/internal/repohost"
 	"go.uber.org/zap"
 )
 
 // ModuleProcessor holds the context and clients needed for processing AVM modules.
 type ModuleProcessor struct {
-	Logger             *zap.Logger
-	SugaredLogger      *zap.SugaredLogger
-	Clients            *ado.AdoClients
-	Context            context.Context
-	Project            string
-	RepoId             *uuid.UUID
+	Logger        *zap.Logger
+	SugaredLogger *zap.SugaredLogger
+	Clients       *ado.AdoClients
+	Context       context.Context
+	Project       string
+	RepoId        *uuid.UUID
+	// Host is the pull request API of the service hosting the internal module repository.
+	// Clients, Project and RepoId are only set when that host is Azure DevOps.
+	Host               repohost.RepositoryHost
 	Modules            *ModulesStruct
 	LatestAvmTagMap    sync.Map
 	LatestAvmCommitMap sync.Map
+	// UpstreamLogMap holds the upstream commits since the previous sync of each module, read
+	// from the clone before its .git folder is removed.
+	UpstreamLogMap sync.Map
+	// PendingRenames maps the new transformed name of a renamed module to its old folder name
+	// while the migration pull request is open.
+	PendingRenames map[string]string
+	// Reviewers assigns pull request reviewers to modules from --reviewer-mapping-file.
+	Reviewers *ReviewerMapping
+	// AutoComplete is the auto-complete policy of sync pull requests by version bump.
+	AutoComplete AutoCompletePolicy
+	// Templates renders the sync branch names, commit messages and pull request titles.
+	Templates *SyncTemplates
+	// TargetBranches maps modules to the branch their sync pull requests target.
+	TargetBranches *TargetBranchMapping
+	// Pusher pushes sync branches through the host's API in --push-transport ado-api mode, and is
+	// nil when they are pushed with git.
+	Pusher repohost.TreePusher
+	Report *RunReport
+	// aggregate is the open aggregate branch in --aggregate-pull-requests mode, and
+	// aggregateParts the number of aggregate branches started this run.
+	aggregate      *aggregateBatch
+	aggregateParts int
+	// syncBranches holds the state of the sync branches of the modules seen this run, for
+	// --reconcile-sync-branches.
+	syncBranches map[string]syncBranchState
 }
 
-// ModulesStruct holds all three types of AVM modules.
+// upstreamState returns the latest upstream tag, the commit it points to and the upstream
+// commits since the previous sync that the clone phase recorded for a transformed module name.
+func (p *ModuleProcessor) upstreamState(moduleName string) (tag string, commit string, log []upstreamCommit) {
+	if v, ok := p.LatestAvmTagMap.Load(moduleName); ok {
+		tag = v.(string)
+	}
+	if v, ok := p.LatestAvmCommitMap.Load(moduleName); ok {
+		commit = v.(string)
+	}
+	if v, ok := p.UpstreamLogMap.Load(moduleName); ok {
+		log = v.([]upstreamCommit)
+	}
+	return tag, commit, log
+}
+
+// ModulesStruct holds all types of AVM modules, Terraform and Bicep.
 type ModulesStruct struct {
-	ResourceModules []ResourceModulesStruct
-	PatternModules  []PatternModulesStruct
-	UtilityModules  []UtilityModulesStruct
+	ResourceModules      []ResourceModulesStruct
+	PatternModules       []PatternModulesStruct
+	UtilityModules       []UtilityModulesStruct
+	BicepResourceModules []BicepResourceModulesStruct
+	BicepPatternModules  []BicepPatternModulesStruct
+	Names                *ModuleNameIndex `json:"-"`
+	// Issues lists the problems found while loading and validating the indexes.
+	Issues []IndexIssue `json:"-"`
 }
 
 // Module is a common interface implemented by all module types.
 type Module interface {
 	GetRepoURL() string
 	GetModuleName() string
+	GetModuleStatus() string
+	GetOwners() []string
+	GetIndexName() string
+	GetOrigin() string
+	GetSourceRepoChildPath() string
+	GetNameTemplateData() NameTemplateData
 }
 
 // ResourceModulesStruct represents an Azure Verified Module for Azure resources.
 type ResourceModulesStruct struct {
-	ProviderNamespace       string `csv:"ProviderNamespace"`
-	ResourceType            string `csv:"ResourceType"`
-	ModuleDisplayName       string `csv:"ModuleDisplayName"`
-	AlternativeNames        string `csv:"AlternativeNames"`
-	ModuleName              string `csv:"ModuleName"`
-	ParentModule            string `csv:"ParentModule"`
-	ModuleStatus            string `csv:"ModuleStatus"`
-	RepoURL                 string `csv:"RepoURL"`
-	PublicRegistryReference string `csv:"PublicRegistryReference"`
-	Description             string `csv:"Description"`
-	FirstPublishedIn        string `csv:"FirstPublishedIn"`
+	ProviderNamespace               string `csv:"ProviderNamespace"`
+	ResourceType                    string `csv:"ResourceType"`
+	ModuleDisplayName               string `csv:"ModuleDisplayName"`
+	AlternativeNames                string `csv:"AlternativeNames"`
+	ModuleName                      string `csv:"ModuleName"`
+	ParentModule                    string `csv:"ParentModule"`
+	ModuleStatus                    string `csv:"ModuleStatus"`
+	RepoURL                         string `csv:"RepoURL"`
+	PublicRegistryReference         string `csv:"PublicRegistryReference"`
+	TelemetryIdPrefix               string `csv:"TelemetryIdPrefix"`
+	PrimaryModuleOwnerGHHandle      string `csv:"PrimaryModuleOwnerGHHandle"`
+	PrimaryModuleOwnerDisplayName   string `csv:"PrimaryModuleOwnerDisplayName"`
+	SecondaryModuleOwnerGHHandle    string `csv:"SecondaryModuleOwnerGHHandle"`
+	SecondaryModuleOwnerDisplayName string `csv:"SecondaryModuleOwnerDisplayName"`
+	ModuleOwnersGHTeam              string `csv:"ModuleOwnersGHTeam"`
+	ModuleContributorsGHTeam        string `csv:"ModuleContributorsGHTeam"`
+	Description                     string `csv:"Description"`
+	Comments                        string `csv:"Comments"`
+	FirstPublishedIn                string `csv:"FirstPublishedIn"`
+	// Index is the name of the index definition the row was loaded from.
+	Index string `csv:"-"`
+	// Origin labels where the index came from: OriginAvm or OriginPrivate.
+	Origin string `csv:"-"`
 }
 
 // PatternModulesStruct represents an Azure Verified Module for architectural patterns.
 type PatternModulesStruct struct {
-	ModuleDisplayName       string `csv:"ModuleDisplayName"`
-	AlternativeNames        string `csv:"AlternativeNames"`
-	ModuleName              string `csv:"ModuleName"`
-	ModuleStatus            string `csv:"ModuleStatus"`
-	RepoURL                 string `csv:"RepoURL"`
-	PublicRegistryReference string `csv:"PublicRegistryReference"`
-	Description             string `csv:"Description"`
-	FirstPublishedIn        string `csv:"FirstPublishedIn"`
+	ModuleDisplayName               string `csv:"ModuleDisplayName"`
+	AlternativeNames                string `csv:"AlternativeNames"`
+	ModuleName                      string `csv:"ModuleName"`
+	ModuleStatus                    string `csv:"ModuleStatus"`
+	RepoURL                         string `csv:"RepoURL"`
+	PublicRegistryReference         string `csv:"PublicRegistryReference"`
+	TelemetryIdPrefix               string `csv:"TelemetryIdPrefix"`
+	PrimaryModuleOwnerGHHandle      string `csv:"PrimaryModuleOwnerGHHandle"`
+	PrimaryModuleOwnerDisplayName   string `csv:"PrimaryModuleOwnerDisplayName"`
+	SecondaryModuleOwnerGHHandle    string `csv:"SecondaryModuleOwnerGHHandle"`
+	SecondaryModuleOwnerDisplayName string `csv:"SecondaryModuleOwnerDisplayName"`
+	ModuleOwnersGHTeam              string `csv:"ModuleOwnersGHTeam"`
+	ModuleContributorsGHTeam        string `csv:"ModuleContributorsGHTeam"`
+	Description                     string `csv:"Description"`
+	Comments                        string `csv:"Comments"`
+	FirstPublishedIn                string `csv:"FirstPublishedIn"`
+	// Index is the name of the index definition the row was loaded from.
+	Index string `csv:"-"`
+	// Origin labels where the index came from: OriginAvm or OriginPrivate.
+	Origin string `csv:"-"`
 }
 
 // UtilityModulesStruct represents an Azure Verified Module for utility functions.
 type UtilityModulesStruct struct {
-	ModuleDisplayName       string `csv:"ModuleDisplayName"`
-	AlternativeNames        string `csv:"AlternativeNames"`
-	ModuleName              string `csv:"ModuleName"`
-	ModuleStatus            string `csv:"ModuleStatus"`
-	RepoURL                 string `csv:"RepoURL"`
-	PublicRegistryReference string `csv:"PublicRegistryReference"`
-	Description             string `csv:"Description"`
-	FirstPublishedIn        string `csv:"FirstPublishedIn"`
+	ModuleDisplayName               string `csv:"ModuleDisplayName"`
+	AlternativeNames                string `csv:"AlternativeNames"`
+	ModuleName                      string `csv:"ModuleName"`
+	ModuleStatus                    string `csv:"ModuleStatus"`
+	RepoURL                         string `csv:"RepoURL"`
+	PublicRegistryReference         string `csv:"PublicRegistryReference"`
+	TelemetryIdPrefix               string `csv:"TelemetryIdPrefix"`
+	PrimaryModuleOwnerGHHandle      string `csv:"PrimaryModuleOwnerGHHandle"`
+	PrimaryModuleOwnerDisplayName   string `csv:"PrimaryModuleOwnerDisplayName"`
+	SecondaryModuleOwnerGHHandle    string `csv:"SecondaryModuleOwnerGHHandle"`
+	SecondaryModuleOwnerDisplayName string `csv:"SecondaryModuleOwnerDisplayName"`
+	ModuleOwnersGHTeam              string `csv:"ModuleOwnersGHTeam"`
+	ModuleContributorsGHTeam        string `csv:"ModuleContributorsGHTeam"`
+	Description                     string `csv:"Description"`
+	Comments                        string `csv:"Comments"`
+	FirstPublishedIn                string `csv:"FirstPublishedIn"`
+	// Index is the name of the index definition the row was loaded from.
+	Index string `csv:"-"`
+	// Origin labels where the index came from: OriginAvm or OriginPrivate.
+	Origin string `csv:"-"`
+}
+
+// BicepResourceModulesStruct represents a Bicep Azure Verified Module for Azure resources.
+// ModuleName is the registry path (e.g. avm/res/storage/storage-account) and RepoURL points at
+// the module folder inside the bicep-registry-modules monorepo.
+type BicepResourceModulesStruct struct {
+	ProviderNamespace               string `csv:"ProviderNamespace"`
+	ResourceType                    string `csv:"ResourceType"`
+	ModuleDisplayName               string `csv:"ModuleDisplayName"`
+	AlternativeNames                string `csv:"AlternativeNames"`
+	ModuleName                      string `csv:"ModuleName"`
+	ModuleStatus                    string `csv:"ModuleStatus"`
+	RepoURL                         string `csv:"RepoURL"`
+	PublicRegistryReference         string `csv:"PublicRegistryReference"`
+	TelemetryIdPrefix               string `csv:"TelemetryIdPrefix"`
+	PrimaryModuleOwnerGHHandle      string `csv:"PrimaryModuleOwnerGHHandle"`
+	PrimaryModuleOwnerDisplayName   string `csv:"PrimaryModuleOwnerDisplayName"`
+	SecondaryModuleOwnerGHHandle    string `csv:"SecondaryModuleOwnerGHHandle"`
+	SecondaryModuleOwnerDisplayName string `csv:"SecondaryModuleOwnerDisplayName"`
+	ModuleOwnersGHTeam              string `csv:"ModuleOwnersGHTeam"`
+	ModuleContributorsGHTeam        string `csv:"ModuleContributorsGHTeam"`
+	Description                     string `csv:"Description"`
+	Comments                        string `csv:"Comments"`
+	FirstPublishedIn                string `csv:"FirstPublishedIn"`
+	// Index is the name of the index definition the row was loaded from.
+	Index string `csv:"-"`
+	// Origin labels where the index came from: OriginAvm or OriginPrivate.
+	Origin string `csv:"-"`
+}
+
+// BicepPatternModulesStruct represents a Bicep Azure Verified Module for architectural patterns.
+type BicepPatternModulesStruct struct {
+	ModuleDisplayName               string `csv:"ModuleDisplayName"`
+	AlternativeNames                string `csv:"AlternativeNames"`
+	ModuleName                      string `csv:"ModuleName"`
+	ModuleStatus                    string `csv:"ModuleStatus"`
+	RepoURL                         string `csv:"RepoURL"`
+	PublicRegistryReference         string `csv:"PublicRegistryReference"`
+	TelemetryIdPrefix               string `csv:"TelemetryIdPrefix"`
+	PrimaryModuleOwnerGHHandle      string `csv:"PrimaryModuleOwnerGHHandle"`
+	PrimaryModuleOwnerDisplayName   string `csv:"PrimaryModuleOwnerDisplayName"`
+	SecondaryModuleOwnerGHHandle    string `csv:"SecondaryModuleOwnerGHHandle"`
+	SecondaryModuleOwnerDisplayName string `csv:"SecondaryModuleOwnerDisplayName"`
+	ModuleOwnersGHTeam              string `csv:"ModuleOwnersGHTeam"`
+	ModuleContributorsGHTeam        string `csv:"ModuleContributorsGHTeam"`
+	Description                     string `csv:"Description"`
+	Comments                        string `csv:"Comments"`
+	FirstPublishedIn                string `csv:"FirstPublishedIn"`
+	// Index is the name of the index definition the row was loaded from.
+	Index string `csv:"-"`
+	// Origin labels where the index came from: OriginAvm or OriginPrivate.
+	Origin string `csv:"-"`
 }
 
 // GetRepoURL returns the repository URL for a resource module.
@@ -100,3 +245,162 @@ func (m PatternModulesStruct) GetModuleStatus() string { return m.ModuleStatus }
 
 // GetModuleStatus returns the status of a utility module.
 func (m UtilityModulesStruct) GetModuleStatus() string { return m.ModuleStatus }
+
+// GetNameTemplateData returns the fields of a resource module available to the name template.
+func (m ResourceModulesStruct) GetNameTemplateData() NameTemplateData {
+	return NameTemplateData{ModuleName: m.ModuleName, ProviderNamespace: m.ProviderNamespace, ResourceType: m.ResourceType}
+}
+
+// GetNameTemplateData returns the fields of a pattern module available to the name template.
+func (m PatternModulesStruct) GetNameTemplateData() NameTemplateData {
+	return NameTemplateData{ModuleName: m.ModuleName}
+}
+
+// GetNameTemplateData returns the fields of a utility module available to the name template.
+func (m UtilityModulesStruct) GetNameTemplateData() NameTemplateData {
+	return NameTemplateData{ModuleName: m.ModuleName}
+}
+
+// GetIndexName returns the name of the index a resource module was loaded from.
+func (m ResourceModulesStruct) GetIndexName() string { return m.Index }
+
+// GetOrigin returns the origin label of the index a resource module was loaded from.
+func (m ResourceModulesStruct) GetOrigin() string { return m.Origin }
+
+// setIndexSource records the index a resource module row was loaded from and its origin.
+func (m *ResourceModulesStruct) setIndexSource(index string, origin string) {
+	m.Index, m.Origin = index, origin
+}
+
+// GetIndexName returns the name of the index a pattern module was loaded from.
+func (m PatternModulesStruct) GetIndexName() string { return m.Index }
+
+// GetOrigin returns the origin label of the index a pattern module was loaded from.
+func (m PatternModulesStruct) GetOrigin() string { return m.Origin }
+
+// setIndexSource records the index a pattern module row was loaded from and its origin.
+func (m *PatternModulesStruct) setIndexSource(index string, origin string) {
+	m.Index, m.Origin = index, origin
+}
+
+// GetIndexName returns the name of the index a utility module was loaded from.
+func (m UtilityModulesStruct) GetIndexName() string { return m.Index }
+
+// GetOrigin returns the origin label of the index a utility module was loaded from.
+func (m UtilityModulesStruct) GetOrigin() string { return m.Origin }
+
+// setIndexSource records the index a utility module row was loaded from and its origin.
+func (m *UtilityModulesStruct) setIndexSource(index string, origin string) {
+	m.Index, m.Origin = index, origin
+}
+
+// GetOwners returns the owning team and owner handles of a resource module.
+func (m ResourceModulesStruct) GetOwners() []string {
+	return nonEmpty(m.ModuleOwnersGHTeam, m.PrimaryModuleOwnerGHHandle, m.SecondaryModuleOwnerGHHandle)
+}
+
+// GetOwners returns the owning team and owner handles of a pattern module.
+func (m PatternModulesStruct) GetOwners() []string {
+	return nonEmpty(m.ModuleOwnersGHTeam, m.PrimaryModuleOwnerGHHandle, m.SecondaryModuleOwnerGHHandle)
+}
+
+// GetOwners returns the owning team and owner handles of a utility module.
+func (m UtilityModulesStruct) GetOwners() []string {
+	return nonEmpty(m.ModuleOwnersGHTeam, m.PrimaryModuleOwnerGHHandle, m.SecondaryModuleOwnerGHHandle)
+}
+
+// nonEmpty returns the given values with blank entries removed.
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// GetSourceRepoChildPath returns the child path of the source repo that resource modules are synced into.
+func (m ResourceModulesStruct) GetSourceRepoChildPath() string {
+	return config.ModuleSyncSourceRepoChildPath
+}
+
+// GetSourceRepoChildPath returns the child path of the source repo that pattern modules are synced into.
+func (m PatternModulesStruct) GetSourceRepoChildPath() string {
+	return config.ModuleSyncSourceRepoChildPath
+}
+
+// GetSourceRepoChildPath returns the child path of the source repo that utility modules are synced into.
+func (m UtilityModulesStruct) GetSourceRepoChildPath() string {
+	return config.ModuleSyncSourceRepoChildPath
+}
+
+// GetRepoURL returns the repository URL for a Bicep resource module.
+func (m BicepResourceModulesStruct) GetRepoURL() string { return m.RepoURL }
+
+// GetRepoURL returns the repository URL for a Bicep pattern module.
+func (m BicepPatternModulesStruct) GetRepoURL() string { return m.RepoURL }
+
+// GetModuleName returns the module name for a Bicep resource module.
+func (m BicepResourceModulesStruct) GetModuleName() string { return m.ModuleName }
+
+// GetModuleName returns the module name for a Bicep pattern module.
+func (m BicepPatternModulesStruct) GetModuleName() string { return m.ModuleName }
+
+// GetModuleStatus returns the status of a Bicep resource module.
+func (m BicepResourceModulesStruct) GetModuleStatus() string { return m.ModuleStatus }
+
+// GetModuleStatus returns the status of a Bicep pattern module.
+func (m BicepPatternModulesStruct) GetModuleStatus() string { return m.ModuleStatus }
+
+// GetNameTemplateData returns the fields of a Bicep resource module available to the name template.
+func (m BicepResourceModulesStruct) GetNameTemplateData() NameTemplateData {
+	return NameTemplateData{ModuleName: m.ModuleName, ProviderNamespace: m.ProviderNamespace, ResourceType: m.ResourceType}
+}
+
+// GetNameTemplateData returns the fields of a Bicep pattern module available to the name template.
+func (m BicepPatternModulesStruct) GetNameTemplateData() NameTemplateData {
+	return NameTemplateData{ModuleName: m.ModuleName}
+}
+
+// GetIndexName returns the name of the index a Bicep resource module was loaded from.
+func (m BicepResourceModulesStruct) GetIndexName() string { return m.Index }
+
+// GetOrigin returns the origin label of the index a Bicep resource module was loaded from.
+func (m BicepResourceModulesStruct) GetOrigin() string { return m.Origin }
+
+// setIndexSource records the index a Bicep resource module row was loaded from and its origin.
+func (m *BicepResourceModulesStruct) setIndexSource(index string, origin string) {
+	m.Index, m.Origin = index, origin
+}
+
+// GetIndexName returns the name of the index a Bicep pattern module was loaded from.
+func (m BicepPatternModulesStruct) GetIndexName() string { return m.Index }
+
+// GetOrigin returns the origin label of the index a Bicep pattern module was loaded from.
+func (m BicepPatternModulesStruct) GetOrigin() string { return m.Origin }
+
+// setIndexSource records the index a Bicep pattern module row was loaded from and its origin.
+func (m *BicepPatternModulesStruct) setIndexSource(index string, origin string) {
+	m.Index, m.Origin = index, origin
+}
+
+// GetOwners returns the owning team and owner handles of a Bicep resource module.
+func (m BicepResourceModulesStruct) GetOwners() []string {
+	return nonEmpty(m.ModuleOwnersGHTeam, m.PrimaryModuleOwnerGHHandle, m.SecondaryModuleOwnerGHHandle)
+}
+
+// GetOwners returns the owning team and owner handles of a Bicep pattern module.
+func (m BicepPatternModulesStruct) GetOwners() []string {
+	return nonEmpty(m.ModuleOwnersGHTeam, m.PrimaryModuleOwnerGHHandle, m.SecondaryModuleOwnerGHHandle)
+}
+
+// GetSourceRepoChildPath returns the child path of the source repo that Bicep resource modules are synced into.
+func (m BicepResourceModulesStruct) GetSourceRepoChildPath() string {
+	return config.BicepSourceRepoChildPath
+}
+
+// GetSourceRepoChildPath returns the child path of the source repo that Bicep pattern modules are synced into.
+func (m BicepPatternModulesStruct) GetSourceRepoChildPath() string {
+	return config.BicepSourceRepoChildPath
+}
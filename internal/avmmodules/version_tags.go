@@ -0,0 +1,171 @@
+package avmmodules
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"go.uber.org/zap"
+	"golang.org/x/mod/semver"
+)
+
+// VersionTag is a tag marking the default branch commit that merged a module version.
+type VersionTag struct {
+	Name    string `json:"name"`
+	Module  string `json:"module"`
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	// UpstreamModule and UpstreamCommit are the AVM module and commit the version was synced
+	// from, empty for older version files that don't record them.
+	UpstreamModule string `json:"upstreamModule,omitempty"`
+	UpstreamCommit string `json:"upstreamCommit,omitempty"`
+}
+
+// TagMergedVersions creates an annotated <module>/v<version> tag for every module version
+// merged into the default branch, pointing at the commit that brought the module's
+// .avm-version file to that version, e.g. the merge commit of its sync pull request. Tags that
+// already exist on origin are skipped, so the phase can run after every sync; when a version
+// was merged more than once the first merge is tagged. Tags are signed when commit signing is
+// configured. The default branch history must be complete, as tags are only found for the
+// commits a shallow clone has. Does nothing unless --tag-merged-versions is set.
+func (p *ModuleProcessor) TagMergedVersions(localRepoPath string) error {
+	if !config.TagMergedVersions {
+		return nil
+	}
+	logger := p.Logger
+	branch := config.DefaultBranchName
+	args := append(gitAuthArgs(), "fetch", "--quiet", "origin", "+refs/heads/"+branch+":refs/remotes/origin/"+branch)
+	if _, err := runGit(localRepoPath, logger, "", args...); err != nil {
+		return fmt.Errorf("error fetching %s: %w", branch, err)
+	}
+	existing, err := listRemoteTags(localRepoPath, logger)
+	if err != nil {
+		return err
+	}
+	tags, err := mergedVersionTags(localRepoPath, "origin/"+branch, logger)
+	if err != nil {
+		return err
+	}
+
+	created, skipped := 0, 0
+	for _, tag := range tags {
+		if existing[tag.Name] {
+			skipped++
+			continue
+		}
+		if err := createVersionTag(localRepoPath, tag, p.Report.CommitSigning != nil, logger); err != nil {
+			continue
+		}
+		created++
+		p.Report.AddVersionTag(tag)
+		logger.Info("Tagged merged module version", zap.String("module", tag.Module), zap.String("tag", tag.Name), zap.String("commit", tag.Commit))
+	}
+	logger.Info("Tagged merged module versions", zap.Int("created", created), zap.Int("existing", skipped))
+	return nil
+}
+
+// mergedVersionTags returns the version tags for the .avm-version changes in the first-parent
+// history of ref, oldest first and one per tag name. Changes are diffed against the first
+// parent so a merged pull request is attributed to its merge commit, and without rename
+// detection so a version file moved by a module rename still counts as added.
+func mergedVersionTags(localRepoPath string, ref string, logger *zap.Logger) ([]VersionTag, error) {
+	out, err := runGit(localRepoPath, logger, "", "log", "--reverse", "--first-parent", "-m", "--no-renames", "--diff-filter=AM",
+		"--name-only", "--format=%x00%H", ref, "--", ":(glob)**/"+config.AvmVersionFileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s history: %w", config.AvmVersionFileName, err)
+	}
+	var tags []VersionTag
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(out, "\x00") {
+		lines := strings.Split(strings.TrimSpace(entry), "\n")
+		commit := strings.TrimSpace(lines[0])
+		if commit == "" {
+			continue
+		}
+		for _, file := range lines[1:] {
+			file = strings.TrimSpace(file)
+			if path.Base(file) != config.AvmVersionFileName {
+				continue
+			}
+			content, err := runGit(localRepoPath, logger, "", "show", commit+":"+file)
+			if err != nil {
+				continue
+			}
+			tag, ok := versionTagFor(path.Base(path.Dir(file)), commit, content)
+			if !ok {
+				logger.Debug("Version file has no semantic version, not tagging it", zap.String("file", file), zap.String("commit", commit))
+				continue
+			}
+			if !seen[tag.Name] {
+				seen[tag.Name] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// versionTagFor returns the tag of the module version recorded by a .avm-version file, named
+// after the module folder and the upstream version. Bicep tags, which are recorded without
+// their module path, get a v prefix. Returns false when the version isn't semantic.
+func versionTagFor(module string, commit string, content string) (VersionTag, bool) {
+	version := ensureSemverPrefix(parseAvmVersionTag(content))
+	if module == "." || module == "/" || !semver.IsValid(version) {
+		return VersionTag{}, false
+	}
+	return VersionTag{
+		Name:           module + "/" + version,
+		Module:         module,
+		Version:        version,
+		Commit:         commit,
+		UpstreamModule: parseAvmVersionValue(content, "module"),
+		UpstreamCommit: parseAvmVersionCommit(content),
+	}, true
+}
+
+// listRemoteTags returns the names of the tags on origin.
+func listRemoteTags(localRepoPath string, logger *zap.Logger) (map[string]bool, error) {
+	args := append(gitAuthArgs(), "ls-remote", "--tags", "--refs", "origin")
+	out, err := runGit(localRepoPath, logger, "", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tags: %w", err)
+	}
+	tags := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		if _, ref, ok := strings.Cut(strings.TrimSpace(line), "\t"); ok {
+			tags[strings.TrimPrefix(ref, "refs/tags/")] = true
+		}
+	}
+	return tags, nil
+}
+
+// createVersionTag creates an annotated, or signed, tag as the sync identity and pushes it to
+// origin. A tag left locally by an earlier run whose push failed is pushed as is.
+func createVersionTag(localRepoPath string, tag VersionTag, sign bool, logger *zap.Logger) error {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "refs/tags/"+tag.Name)
+	cmd.Dir = localRepoPath
+	if err := cmd.Run(); err != nil {
+		message := tag.Module + " " + tag.Version
+		if tag.UpstreamModule != "" {
+			message += "\n\nSynced from AVM module " + tag.UpstreamModule
+			if tag.UpstreamCommit != "" {
+				message += " at " + tag.UpstreamCommit
+			}
+			message += "."
+		}
+		mode := "-a"
+		if sign {
+			mode = "-s"
+		}
+		if _, err := runGit(localRepoPath, logger, tag.Module,
+			"-c", "user.name="+config.ModuleSyncAuthorName, "-c", "user.email="+config.ModuleSyncAuthorEmail,
+			"tag", mode, "-m", message, tag.Name, tag.Commit); err != nil {
+			return err
+		}
+	}
+	args := append(gitAuthArgs(), "push", "origin", "refs/tags/"+tag.Name)
+	_, err := runGit(localRepoPath, logger, tag.Module, args...)
+	return err
+}
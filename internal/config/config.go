@@ -1,26 +1,135 @@
 package config
 
+import "time"
+
 const (
-	ResourceModulesUrl    string = "https://raw.githubusercontent.com/Azure/Azure-Verified-Modules/refs/heads/main/docs/static/module-indexes/TerraformResourceModules.csv"
-	PatternModulesUrl     string = "https://raw.githubusercontent.com/Azure/Azure-Verified-Modules/refs/heads/main/docs/static/module-indexes/TerraformPatternModules.csv"
-	UtilityModulesUrl     string = "https://raw.githubusercontent.com/Azure/Azure-Verified-Modules/refs/heads/main/docs/static/module-indexes/TerraformUtilityModules.csv"
-	AdoEnterpriseAppId    string = "499b84ac-1321-427f-aa17-267ca6975798"
-	AdoEnterpriseAppScope string = AdoEnterpriseAppId + "/.default"
-	LocalCsvPath          string = "./third_party/module-indexes"
-	BatchSize             int    = 10
-	DefaultBranchName     string = "main"
-	PatchesFolderName     string = "patches"
-	AvmVersionFileName    string = ".avm-version"
-	ExamplesFolderName    string = "examples"
+	ResourceModulesUrl         string = "https://raw.githubusercontent.com/Azure/Azure-Verified-Modules/refs/heads/main/docs/static/module-indexes/TerraformResourceModules.csv"
+	PatternModulesUrl          string = "https://raw.githubusercontent.com/Azure/Azure-Verified-Modules/refs/heads/main/docs/static/module-indexes/TerraformPatternModules.csv"
+	UtilityModulesUrl          string = "https://raw.githubusercontent.com/Azure/Azure-Verified-Modules/refs/heads/main/docs/static/module-indexes/TerraformUtilityModules.csv"
+	BicepResourceModulesUrl    string = "https://raw.githubusercontent.com/Azure/Azure-Verified-Modules/refs/heads/main/docs/static/module-indexes/BicepResourceModules.csv"
+	BicepPatternModulesUrl     string = "https://raw.githubusercontent.com/Azure/Azure-Verified-Modules/refs/heads/main/docs/static/module-indexes/BicepPatternModules.csv"
+	BicepRegistryRepoUrl       string = "https://github.com/Azure/bicep-registry-modules"
+	BicepTestsFolderName       string = "tests"
+	AdoEnterpriseAppId         string = "499b84ac-1321-427f-aa17-267ca6975798"
+	AdoEnterpriseAppScope      string = AdoEnterpriseAppId + "/.default"
+	LocalCsvPath               string = "./third_party/module-indexes"
+	BatchSize                  int    = 10
+	DefaultTargetBranch        string = "main"
+	DefaultBranchPrefix        string = "feat/avm-module-sync/"
+	PatchesFolderName          string = "patches"
+	AvmVersionFileName         string = ".avm-version"
+	ExamplesFolderName         string = "examples"
+	BreakingChangeLabel        string = "breaking"
+	ClassificationLabelPrefix  string = "avm-sync:"
+	AggregateBranchName        string = "aggregate-"
+	DefaultAggregateMaxModules int    = 20
+
+	DefaultResourceNameTemplate string = `rvm-res-azurerm-{{ trimPrefix .ModuleName "avm-res-" }}`
+	DefaultPatternNameTemplate  string = `rvm-pat-azurerm-{{ trimPrefix .ModuleName "avm-ptn-" }}`
+	DefaultUtilityNameTemplate  string = `rvm-utl-azurerm-{{ trimPrefix .ModuleName "avm-utl-" }}`
+
+	DefaultBicepResourceNameTemplate string = `rvm-res-bicep-{{ replace (trimPrefix .ModuleName "avm/res/") "/" "-" }}`
+	DefaultBicepPatternNameTemplate  string = `rvm-pat-bicep-{{ replace (trimPrefix .ModuleName "avm/ptn/") "/" "-" }}`
+
+	// The innersource version is kept in lock-step with the upstream tag rather than being
+	// derived from the commit type, so the default commit message uses a fixed "chore" type; it
+	// only needs to satisfy the pipeline's conventional-commit validation.
+	DefaultSyncBranchTemplate       string = `{{ .BranchPrefix }}{{ .ModuleName }}`
+	DefaultCommitMessageTemplate    string = `chore(module): Synced AVM module {{ .ModuleName }}`
+	DefaultPullRequestTitleTemplate string = `chore(module): Synced AVM module {{ .ModuleName }}{{ if .LatestTag }} to {{ .LatestTag }}{{ end }}`
+
+	// With --semver-commit-types the title follows the commit message, as squash merges commit
+	// with the pull request title.
+	DefaultSemverCommitMessageTemplate    string = `{{ .CommitType }}({{ .ModuleName }}){{ if .Breaking }}!{{ end }}: Synced AVM module {{ .ModuleName }}{{ if .LatestTag }} to {{ .LatestTag }}{{ end }}`
+	DefaultSemverPullRequestTitleTemplate string = DefaultSemverCommitMessageTemplate
 )
 
+// DefaultPullRequestDescriptionTemplate is the Go template for sync pull request descriptions,
+// used unless --pr-description-template points at a replacement.
+const DefaultPullRequestDescriptionTemplate string = `This is an automated pull request to sync the {{ .ModuleName }} module from the {{ if eq .Origin "avm" }}source AVM repository{{ else }}{{ .Origin }} module repository{{ end }} {{ .RepoURL }}
+{{ if or .UpstreamOwners .Reviewers }}
+## Ownership
+{{ if .UpstreamOwners }}
+Upstream owners: {{ range $i, $o := .UpstreamOwners }}{{ if $i }}, {{ end }}` + "`{{ $o }}`" + `{{ end }}
+{{ end }}{{ if .Reviewers }}
+Reviewers: {{ range $i, $r := .Reviewers }}{{ if $i }}, {{ end }}{{ $r.Identity }}{{ if $r.Required }} (required){{ end }}{{ end }}
+{{ end }}{{ end }}{{ if .LatestTag }}
+## Version
+
+{{ if .Classification }}Sync type: **{{ .Classification }}**
+
+{{ end }}{{ if .PreviousTag }}{{ link .PreviousTag .PreviousTagURL }}{{ if .PreviousCommit }} ({{ link (short .PreviousCommit) .PreviousCommitURL }}){{ end }} → {{ end }}{{ link .LatestTag .LatestTagURL }}{{ if .LatestCommit }} ({{ link (short .LatestCommit) .LatestCommitURL }}){{ end }}{{ if .CompareURL }}
+
+[Compare upstream changes]({{ .CompareURL }}){{ end }}
+{{ end }}{{ if .UpstreamLog }}
+## Upstream commits
+{{ range .UpstreamLog }}
+- ` + "`{{ .Commit }}`" + ` {{ .Subject }}{{ end }}{{ if .UpstreamLogTruncated }}
+- …{{ end }}
+{{ end }}{{ if .Changelog }}
+## Changelog
+
+{{ .Changelog }}
+{{ end }}{{ if .InterfaceDiff }}
+## Interface changes{{ if .InterfaceDiff.IsBreaking }} (breaking){{ end }}
+
+{{ .InterfaceDiff.Markdown }}{{ end }}
+## Changes
+
+| Added | Changed | Deleted |
+|---|---|---|
+| {{ .FilesAdded }} | {{ .FilesChanged }} | {{ .FilesDeleted }} |
+{{ if or .PatchesApplied .PatchesFailed }}
+## Patches
+{{ range .PatchesApplied }}
+- ✅ ` + "`{{ . }}`" + `{{ end }}{{ range .PatchesFailed }}
+- ❌ ` + "`{{ . }}`" + ` failed to apply{{ end }}
+{{ end }}{{ if .SourceRewrites }}
+## Rewritten sources
+{{ range .SourceRewrites }}
+- ` + "`{{ .File }}`: `{{ .From }}` → `{{ .To }}`" + `{{ end }}
+{{ end }}`
+
+// AggregatePullRequestDescriptionTemplate is the Go template for the description of an
+// aggregate pull request syncing several modules, one commit per module.
+const AggregatePullRequestDescriptionTemplate string = `This is an automated pull request to sync {{ len .Modules }} modules, one commit per module.{{ if gt .Part 1 }} It is part {{ .Part }} of this run's sync.{{ end }}
+
+| Module | Sync type | Version | Files (+/~/-) | Notes |
+|---|---|---|---|---|
+{{ range .Modules }}| ` + "`{{ .ModuleName }}`" + ` | {{ or .Classification "re-sync" }} | {{ if .PreviousTag }}{{ link .PreviousTag .PreviousTagURL }} → {{ end }}{{ if .LatestTag }}{{ link .LatestTag .LatestTagURL }}{{ else }}_unknown_{{ end }}{{ if .CompareURL }} ([compare]({{ .CompareURL }})){{ end }} | {{ .FilesAdded }}/{{ .FilesChanged }}/{{ .FilesDeleted }} | {{ if .InterfaceDiff }}{{ if .InterfaceDiff.IsBreaking }}⚠️ breaking interface changes {{ end }}{{ end }}{{ if .PatchesFailed }}❌ {{ len .PatchesFailed }} patch(es) failed{{ end }} |
+{{ end }}{{ if .Reviewers }}
+Reviewers: {{ range $i, $r := .Reviewers }}{{ if $i }}, {{ end }}{{ $r.Identity }}{{ if $r.Required }} (required){{ end }}{{ end }}
+{{ end }}{{ range .Modules }}{{ if .InterfaceDiff }}{{ if .InterfaceDiff.IsBreaking }}
+## Interface changes in ` + "`{{ .ModuleName }}`" + `
+
+{{ .InterfaceDiff.Markdown }}{{ end }}{{ end }}{{ end }}`
+
 var ProcessResourceModules bool
 var ProcessPatternModules bool
 var ProcessUtilityModules bool
+var ProcessBicepResourceModules bool
+var ProcessBicepPatternModules bool
 var DebugMode bool
 var CleanTempDirs bool
 var UseLocalIdentity bool
 var ReadLocalCsvFile bool
+var IndexConfigFile string
+var PrivateIndexFile string
+var StrictIndex bool
+var FollowParentModules bool
+
+var RepositoryHost string
+var GitHubApiUrl string
+var GitHubOwner string
+var GitHubRepo string
+var GitHubToken string
+var PrivateIndexNameTemplate string
+var PullRequestDescriptionTemplateFile string
+var IndexCacheDir string
+var HttpTimeout time.Duration
+var HttpRetries int
+var HttpRetryDelay time.Duration
+var HttpProxy string
 var PullRemoteTerraformRepository bool
 
 var AdoOrganizationUrl string = "https://dev.azure.com/"
@@ -35,6 +144,41 @@ var ModuleSyncAuthorEmail string
 var ModuleSyncSourceRepoChildPath string
 var ArtifactorySourceTemplate string
 
+var ResourceNameTemplate string
+var PatternNameTemplate string
+var UtilityNameTemplate string
+var BicepResourceNameTemplate string
+var BicepPatternNameTemplate string
+var BicepSourceRepoChildPath string
+var BicepRegistrySourceTemplate string
+var ModuleNameMappingFile string
+var ReviewerMappingFile string
+var AutoComplete bool
+var AutoCompletePolicyFile string
+var AggregatePullRequests bool
+var DefaultBranchName string
+var TargetBranchMappingFile string
+var BranchPrefix string
+var SyncBranchTemplate string
+var CommitMessageTemplate string
+var SemverCommitTypes bool
+var CommitSigningFormat string
+var CommitSigningKeyFile string
+var CommitSigningKeyEnv string
+var PushTransport string
+var PullRequestTitleTemplate string
+var AggregateMaxModules int
+var MigrateRenamedModules bool
+var ProcessLifecycle bool
+var ReconcileSyncBranches bool
+var TagMergedVersions bool
+var LifecycleStatuses []string
+var LifecycleReviewers []string
+
+var ReportPath string
+var IndexSnapshotPath string
+var IndexSnapshotPullRequest bool
+
 var TempAvmModuleRepoPath string
 var SourceRepoPath string
 
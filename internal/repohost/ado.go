@@ -0,0 +1,426 @@
+package repohost
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/core"
+	adogit "github.com/microsoft/azure-devops-go-api/azuredevops/git"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/identity"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/webapi"
+	"go.uber.org/zap"
+)
+
+// AdoHost implements RepositoryHost on the Azure DevOps Git pull request API.
+type AdoHost struct {
+	client         adogit.Client
+	identityClient identity.Client
+	project        string
+	repoId         string
+	logger         *zap.Logger
+
+	mu sync.Mutex
+	// identities caches reviewer names resolved to identity IDs, "" for names that didn't resolve.
+	identities map[string]string
+}
+
+// NewAdoHost returns a RepositoryHost for the ADO repository repoId in project. identityClient
+// resolves reviewer names to identity IDs and may be nil when reviewers are always given as IDs.
+func NewAdoHost(client adogit.Client, identityClient identity.Client, project string, repoId uuid.UUID, logger *zap.Logger) *AdoHost {
+	return &AdoHost{
+		client:         client,
+		identityClient: identityClient,
+		project:        project,
+		repoId:         repoId.String(),
+		logger:         logger,
+		identities:     map[string]string{},
+	}
+}
+
+// resolveIdentity returns the identity ID of a reviewer. IDs are used as is; other names are
+// looked up with the identity API's general search, which matches unique names such as email
+// addresses and "[Project]\Team" as well as display names. Returns "" unless exactly one identity
+// matches, so an ambiguous display name or handle never requests review from an arbitrary person.
+func (h *AdoHost) resolveIdentity(ctx context.Context, name string) (string, error) {
+	if _, err := uuid.Parse(name); err == nil {
+		return name, nil
+	}
+	h.mu.Lock()
+	id, ok := h.identities[name]
+	h.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	if h.identityClient == nil {
+		return "", fmt.Errorf("cannot resolve reviewer %s without an identity client", name)
+	}
+	searchFilter := "General"
+	filterValue := name
+	identities, err := h.identityClient.ReadIdentities(ctx, identity.ReadIdentitiesArgs{
+		SearchFilter: &searchFilter,
+		FilterValue:  &filterValue,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error resolving reviewer %s: %w", name, err)
+	}
+	if identities != nil && len(*identities) > 1 {
+		h.logger.Warn("Reviewer matches more than one ADO identity, use a unique name or identity ID",
+			zap.String("reviewer", name),
+			zap.Int("matches", len(*identities)))
+	} else if identities != nil && len(*identities) == 1 && (*identities)[0].Id != nil {
+		id = (*identities)[0].Id.String()
+	}
+	h.mu.Lock()
+	h.identities[name] = id
+	h.mu.Unlock()
+	return id, nil
+}
+
+// resolveReviewers resolves reviewers to identity references. Reviewers that can't be resolved
+// are logged and skipped so they don't block the pull request.
+func (h *AdoHost) resolveReviewers(ctx context.Context, reviewers []Reviewer) []adogit.IdentityRefWithVote {
+	resolved := make([]adogit.IdentityRefWithVote, 0, len(reviewers))
+	for _, reviewer := range reviewers {
+		id, err := h.resolveIdentity(ctx, reviewer.Identity)
+		if err != nil || id == "" {
+			h.logger.Warn("Skipping pull request reviewer that could not be resolved to an ADO identity", zap.String("reviewer", reviewer.Identity), zap.Error(err))
+			continue
+		}
+		required := reviewer.Required
+		resolved = append(resolved, adogit.IdentityRefWithVote{Id: &id, IsRequired: &required})
+	}
+	return resolved
+}
+
+// adoMaxDescriptionLength is the longest pull request description ADO accepts.
+const adoMaxDescriptionLength = 4000
+
+// truncateDescription shortens a description to the ADO limit, cutting at a line boundary
+// where possible and noting that it was truncated.
+func truncateDescription(description string) string {
+	if len(description) <= adoMaxDescriptionLength {
+		return description
+	}
+	const note = "\n\n_Description truncated._"
+	cut := description[:adoMaxDescriptionLength-len(note)]
+	if i := strings.LastIndex(cut, "\n"); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.ToValidUTF8(cut, "") + note
+}
+
+// branchRef returns the full ref name of a branch.
+func branchRef(branch string) string {
+	if strings.HasPrefix(branch, "refs/") {
+		return branch
+	}
+	return "refs/heads/" + branch
+}
+
+// fromAdoPullRequest converts an ADO pull request into a PullRequest.
+func fromAdoPullRequest(pr *adogit.GitPullRequest) *PullRequest {
+	result := &PullRequest{}
+	if pr.PullRequestId != nil {
+		result.ID = *pr.PullRequestId
+	}
+	if pr.Url != nil {
+		result.URL = *pr.Url
+	}
+	if pr.Status != nil {
+		result.Status = string(*pr.Status)
+	}
+	if pr.Title != nil {
+		result.Title = *pr.Title
+	}
+	if pr.Description != nil {
+		result.Description = *pr.Description
+	}
+	if pr.SourceRefName != nil {
+		result.SourceBranch = strings.TrimPrefix(*pr.SourceRefName, "refs/heads/")
+	}
+	if pr.TargetRefName != nil {
+		result.TargetBranch = strings.TrimPrefix(*pr.TargetRefName, "refs/heads/")
+	}
+	if pr.IsDraft != nil {
+		result.IsDraft = *pr.IsDraft
+	}
+	return result
+}
+
+// isActivePullRequestError reports whether a pull request creation failed because an active
+// pull request already exists for the source branch.
+func isActivePullRequestError(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "active pull request")
+}
+
+// CreatePullRequest opens a pull request with the requested reviewers, then adds its labels.
+// Descriptions over the ADO limit are truncated.
+func (h *AdoHost) CreatePullRequest(ctx context.Context, opts PullRequestOptions) (*PullRequest, error) {
+	source := branchRef(opts.SourceBranch)
+	target := branchRef(opts.TargetBranch)
+	description := truncateDescription(opts.Description)
+	pr := adogit.GitPullRequest{
+		Title:         &opts.Title,
+		Description:   &description,
+		SourceRefName: &source,
+		TargetRefName: &target,
+		IsDraft:       &opts.Draft,
+	}
+	if reviewers := h.resolveReviewers(ctx, opts.Reviewers); len(reviewers) > 0 {
+		pr.Reviewers = &reviewers
+	}
+	created, err := h.client.CreatePullRequest(ctx, adogit.CreatePullRequestArgs{
+		GitPullRequestToCreate: &pr,
+		RepositoryId:           &h.repoId,
+		Project:                &h.project,
+	})
+	if err != nil {
+		if isActivePullRequestError(err) {
+			return nil, fmt.Errorf("%w: %v", ErrPullRequestExists, err)
+		}
+		return nil, err
+	}
+	result := fromAdoPullRequest(created)
+	if err := h.SetLabels(ctx, result.ID, opts.Labels, ""); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// UpdatePullRequest replaces the title and description of a pull request. Descriptions over the
+// ADO limit are truncated.
+func (h *AdoHost) UpdatePullRequest(ctx context.Context, id int, title string, description string) (*PullRequest, error) {
+	truncated := truncateDescription(description)
+	updated, err := h.client.UpdatePullRequest(ctx, adogit.UpdatePullRequestArgs{
+		GitPullRequestToUpdate: &adogit.GitPullRequest{Title: &title, Description: &truncated},
+		RepositoryId:           &h.repoId,
+		PullRequestId:          &id,
+		Project:                &h.project,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromAdoPullRequest(updated), nil
+}
+
+// FindPullRequest returns the active pull request from sourceBranch into targetBranch.
+func (h *AdoHost) FindPullRequest(ctx context.Context, sourceBranch string, targetBranch string) (*PullRequest, error) {
+	source := branchRef(sourceBranch)
+	target := branchRef(targetBranch)
+	status := adogit.PullRequestStatusValues.Active
+	prs, err := h.client.GetPullRequests(ctx, adogit.GetPullRequestsArgs{
+		RepositoryId: &h.repoId,
+		Project:      &h.project,
+		SearchCriteria: &adogit.GitPullRequestSearchCriteria{
+			SourceRefName: &source,
+			TargetRefName: &target,
+			Status:        &status,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if prs == nil || len(*prs) == 0 {
+		return nil, nil
+	}
+	return fromAdoPullRequest(&(*prs)[0]), nil
+}
+
+// AddReviewers adds the given reviewers one at a time, since only the single-reviewer API can
+// mark a reviewer as required. Existing reviewers keep their votes.
+func (h *AdoHost) AddReviewers(ctx context.Context, id int, reviewers []Reviewer) error {
+	for _, reviewer := range h.resolveReviewers(ctx, reviewers) {
+		if _, err := h.client.CreatePullRequestReviewer(ctx, adogit.CreatePullRequestReviewerArgs{
+			Reviewer:      &reviewer,
+			RepositoryId:  &h.repoId,
+			PullRequestId: &id,
+			ReviewerId:    reviewer.Id,
+			Project:       &h.project,
+		}); err != nil {
+			return fmt.Errorf("error adding reviewer %s to pull request %d: %w", *reviewer.Id, id, err)
+		}
+	}
+	return nil
+}
+
+// SetLabels adds the given labels to a pull request, after removing the labels starting with
+// replacePrefix that aren't among them. ADO ignores labels that are already set.
+func (h *AdoHost) SetLabels(ctx context.Context, id int, labels []string, replacePrefix string) error {
+	if replacePrefix != "" {
+		existing, err := h.client.GetPullRequestLabels(ctx, adogit.GetPullRequestLabelsArgs{
+			RepositoryId:  &h.repoId,
+			PullRequestId: &id,
+			Project:       &h.project,
+		})
+		if err != nil {
+			return fmt.Errorf("error reading labels of pull request %d: %w", id, err)
+		}
+		if existing != nil {
+			for _, label := range *existing {
+				if label.Name == nil || !strings.HasPrefix(*label.Name, replacePrefix) || slices.Contains(labels, *label.Name) {
+					continue
+				}
+				if err := h.client.DeletePullRequestLabels(ctx, adogit.DeletePullRequestLabelsArgs{
+					RepositoryId:  &h.repoId,
+					PullRequestId: &id,
+					LabelIdOrName: label.Name,
+					Project:       &h.project,
+				}); err != nil {
+					return fmt.Errorf("error removing label %s from pull request %d: %w", *label.Name, id, err)
+				}
+			}
+		}
+	}
+	for _, label := range labels {
+		name := label
+		if _, err := h.client.CreatePullRequestLabel(ctx, adogit.CreatePullRequestLabelArgs{
+			Label:         &core.WebApiCreateTagRequestData{Name: &name},
+			RepositoryId:  &h.repoId,
+			PullRequestId: &id,
+			Project:       &h.project,
+		}); err != nil {
+			return fmt.Errorf("error adding label %s to pull request %d: %w", label, id, err)
+		}
+	}
+	return nil
+}
+
+// SetDraft marks a pull request as a draft, or publishes it for review.
+func (h *AdoHost) SetDraft(ctx context.Context, id int, draft bool) error {
+	_, err := h.client.UpdatePullRequest(ctx, adogit.UpdatePullRequestArgs{
+		GitPullRequestToUpdate: &adogit.GitPullRequest{IsDraft: &draft},
+		RepositoryId:           &h.repoId,
+		PullRequestId:          &id,
+		Project:                &h.project,
+	})
+	return err
+}
+
+// AddComment posts a comment as a closed thread so it informs reviewers without blocking
+// completion on a comment-resolution policy.
+func (h *AdoHost) AddComment(ctx context.Context, id int, text string) error {
+	status := adogit.CommentThreadStatusValues.Closed
+	comments := []adogit.Comment{{Content: &text}}
+	_, err := h.client.CreateThread(ctx, adogit.CreateThreadArgs{
+		CommentThread: &adogit.GitPullRequestCommentThread{Comments: &comments, Status: &status},
+		RepositoryId:  &h.repoId,
+		PullRequestId: &id,
+		Project:       &h.project,
+	})
+	return err
+}
+
+// SetAutoComplete sets auto-complete on a pull request on behalf of its creator, which is the
+// identity ADO requires to match the caller.
+func (h *AdoHost) SetAutoComplete(ctx context.Context, id int, opts AutoCompleteOptions) error {
+	pr, err := h.client.GetPullRequestById(ctx, adogit.GetPullRequestByIdArgs{PullRequestId: &id, Project: &h.project})
+	if err != nil {
+		return fmt.Errorf("error reading pull request %d: %w", id, err)
+	}
+	if pr.CreatedBy == nil || pr.CreatedBy.Id == nil {
+		return fmt.Errorf("pull request %d has no creator to set auto-complete for", id)
+	}
+	strategy := adogit.GitPullRequestMergeStrategy(opts.MergeStrategy)
+	_, err = h.client.UpdatePullRequest(ctx, adogit.UpdatePullRequestArgs{
+		GitPullRequestToUpdate: &adogit.GitPullRequest{
+			AutoCompleteSetBy: &webapi.IdentityRef{Id: pr.CreatedBy.Id},
+			CompletionOptions: &adogit.GitPullRequestCompletionOptions{
+				MergeStrategy:       &strategy,
+				DeleteSourceBranch:  &opts.DeleteSourceBranch,
+				TransitionWorkItems: &opts.TransitionWorkItems,
+			},
+		},
+		RepositoryId:  &h.repoId,
+		PullRequestId: &id,
+		Project:       &h.project,
+	})
+	return err
+}
+
+// CancelAutoComplete clears auto-complete from a pull request by setting it on behalf of the
+// empty identity, which is how ADO represents a pull request without auto-complete.
+func (h *AdoHost) CancelAutoComplete(ctx context.Context, id int) error {
+	empty := uuid.Nil.String()
+	_, err := h.client.UpdatePullRequest(ctx, adogit.UpdatePullRequestArgs{
+		GitPullRequestToUpdate: &adogit.GitPullRequest{AutoCompleteSetBy: &webapi.IdentityRef{Id: &empty}},
+		RepositoryId:           &h.repoId,
+		PullRequestId:          &id,
+		Project:                &h.project,
+	})
+	return err
+}
+
+// adoPageSize is the number of pull requests requested per page when listing.
+const adoPageSize = 100
+
+// ListPullRequests returns the active pull requests created by the authenticated identity whose
+// source branch starts with sourceBranchPrefix. The API can't filter on a branch prefix, so the
+// caller's pull requests are paged through and filtered here.
+func (h *AdoHost) ListPullRequests(ctx context.Context, sourceBranchPrefix string) ([]PullRequest, error) {
+	if h.identityClient == nil {
+		return nil, fmt.Errorf("cannot list the caller's pull requests without an identity client")
+	}
+	self, err := h.identityClient.GetSelf(ctx, identity.GetSelfArgs{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading the authenticated identity: %w", err)
+	}
+	if self.Id == nil {
+		return nil, fmt.Errorf("the authenticated identity has no ID")
+	}
+	status := adogit.PullRequestStatusValues.Active
+	prefix := branchRef(sourceBranchPrefix)
+	var result []PullRequest
+	for skip := 0; ; skip += adoPageSize {
+		top, offset := adoPageSize, skip
+		prs, err := h.client.GetPullRequests(ctx, adogit.GetPullRequestsArgs{
+			RepositoryId: &h.repoId,
+			Project:      &h.project,
+			SearchCriteria: &adogit.GitPullRequestSearchCriteria{
+				CreatorId: self.Id,
+				Status:    &status,
+			},
+			Top:  &top,
+			Skip: &offset,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if prs == nil {
+			return result, nil
+		}
+		for i := range *prs {
+			pr := &(*prs)[i]
+			if pr.SourceRefName != nil && strings.HasPrefix(*pr.SourceRefName, prefix) {
+				result = append(result, *fromAdoPullRequest(pr))
+			}
+		}
+		if len(*prs) < adoPageSize {
+			return result, nil
+		}
+	}
+}
+
+// AbandonPullRequest sets a pull request's status to abandoned.
+func (h *AdoHost) AbandonPullRequest(ctx context.Context, id int) error {
+	status := adogit.PullRequestStatusValues.Abandoned
+	_, err := h.client.UpdatePullRequest(ctx, adogit.UpdatePullRequestArgs{
+		GitPullRequestToUpdate: &adogit.GitPullRequest{Status: &status},
+		RepositoryId:           &h.repoId,
+		PullRequestId:          &id,
+		Project:                &h.project,
+	})
+	return err
+}
+
+// GetPullRequestStatus returns the status of a pull request.
+func (h *AdoHost) GetPullRequestStatus(ctx context.Context, id int) (string, error) {
+	pr, err := h.client.GetPullRequestById(ctx, adogit.GetPullRequestByIdArgs{PullRequestId: &id, Project: &h.project})
+	if err != nil {
+		return "", err
+	}
+	return fromAdoPullRequest(pr).Status, nil
+}
@@ -0,0 +1,210 @@
+package repohost
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+	adogit "github.com/microsoft/azure-devops-go-api/azuredevops/git"
+)
+
+// File change types of a TreeCommit.
+const (
+	ChangeAdd    = "add"
+	ChangeEdit   = "edit"
+	ChangeDelete = "delete"
+)
+
+// FileChange is a change to one file of a TreeCommit. Path is the repository path with a
+// leading slash; Content is unused for deletions.
+type FileChange struct {
+	Path       string
+	ChangeType string
+	Content    []byte
+}
+
+// TreeCommit is a commit built from file changes rather than a local working tree. The author
+// defaults to the identity the push is made with when AuthorName is empty.
+type TreeCommit struct {
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	Changes     []FileChange
+}
+
+// TreePusher pushes commits built from file contents, so branches can be updated without a
+// local git checkout of the repository. Implemented by AdoHost on the Git Pushes API.
+type TreePusher interface {
+	// BranchHead returns the commit a branch points to, or "" when the branch doesn't exist.
+	BranchHead(ctx context.Context, branch string) (string, error)
+	// ListBlobs returns the blob ID of every file under path as of commit, keyed by repository
+	// path. Returns an empty map when path doesn't exist at commit.
+	ListBlobs(ctx context.Context, commit string, path string) (map[string]string, error)
+	// ReadFile returns the content of the file at path as of commit, or nil when path doesn't
+	// exist at commit.
+	ReadFile(ctx context.Context, commit string, path string) ([]byte, error)
+	// PushCommits creates commits on top of base and moves branch to the last one in a single
+	// push, creating branch when it doesn't exist. base must be the current head of an existing
+	// branch. Returns the ID of the last commit.
+	PushCommits(ctx context.Context, branch string, base string, commits []TreeCommit) (string, error)
+}
+
+// zeroObjectId is the object ID of a ref that doesn't exist.
+const zeroObjectId = "0000000000000000000000000000000000000000"
+
+// BranchHead returns the commit a branch points to, or "" when the branch doesn't exist.
+func (h *AdoHost) BranchHead(ctx context.Context, branch string) (string, error) {
+	refName := "refs/heads/" + branch
+	filter := "heads/" + branch
+	refs, err := h.client.GetRefs(ctx, adogit.GetRefsArgs{
+		RepositoryId: &h.repoId,
+		Project:      &h.project,
+		Filter:       &filter,
+	})
+	if err != nil {
+		return "", err
+	}
+	// The filter is a prefix match, so feature/x also returns feature/x-y.
+	for _, ref := range refs.Value {
+		if ref.Name != nil && *ref.Name == refName && ref.ObjectId != nil {
+			return *ref.ObjectId, nil
+		}
+	}
+	return "", nil
+}
+
+// ListBlobs returns the blob ID of every file under path as of commit, keyed by repository path.
+func (h *AdoHost) ListBlobs(ctx context.Context, commit string, path string) (map[string]string, error) {
+	recursion := adogit.VersionControlRecursionTypeValues.Full
+	versionType := adogit.GitVersionTypeValues.Commit
+	items, err := h.client.GetItems(ctx, adogit.GetItemsArgs{
+		RepositoryId:   &h.repoId,
+		Project:        &h.project,
+		ScopePath:      &path,
+		RecursionLevel: &recursion,
+		VersionDescriptor: &adogit.GitVersionDescriptor{
+			Version:     &commit,
+			VersionType: &versionType,
+		},
+	})
+	blobs := map[string]string{}
+	if err != nil {
+		if adoStatusCode(err) == http.StatusNotFound {
+			return blobs, nil
+		}
+		return nil, err
+	}
+	for _, item := range *items {
+		if item.GitObjectType == nil || *item.GitObjectType != adogit.GitObjectTypeValues.Blob || item.Path == nil || item.ObjectId == nil {
+			continue
+		}
+		blobs[*item.Path] = *item.ObjectId
+	}
+	return blobs, nil
+}
+
+// ReadFile returns the content of the file at path as of commit, or nil when path doesn't exist
+// at commit.
+func (h *AdoHost) ReadFile(ctx context.Context, commit string, path string) ([]byte, error) {
+	versionType := adogit.GitVersionTypeValues.Commit
+	content, err := h.client.GetItemContent(ctx, adogit.GetItemContentArgs{
+		RepositoryId: &h.repoId,
+		Project:      &h.project,
+		Path:         &path,
+		VersionDescriptor: &adogit.GitVersionDescriptor{
+			Version:     &commit,
+			VersionType: &versionType,
+		},
+	})
+	if err != nil {
+		if adoStatusCode(err) == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer content.Close()
+	return io.ReadAll(content)
+}
+
+// PushCommits creates commits on top of base and moves branch to the last one in a single push.
+// The Pushes API builds each commit on the previous one, starting from the ref update's old
+// object ID, which for a new branch is the commit it is created from.
+func (h *AdoHost) PushCommits(ctx context.Context, branch string, base string, commits []TreeCommit) (string, error) {
+	if base == "" || base == zeroObjectId {
+		return "", errors.New("a push needs a base commit")
+	}
+	refName := "refs/heads/" + branch
+	push := adogit.GitPush{
+		RefUpdates: &[]adogit.GitRefUpdate{{Name: &refName, OldObjectId: &base}},
+		Commits:    &[]adogit.GitCommitRef{},
+	}
+	for _, commit := range commits {
+		message := commit.Message
+		var changes []interface{}
+		for _, change := range commit.Changes {
+			changes = append(changes, toAdoChange(change))
+		}
+		ref := adogit.GitCommitRef{Comment: &message, Changes: &changes}
+		if commit.AuthorName != "" {
+			author := adogit.GitUserDate{Name: &commit.AuthorName, Email: &commit.AuthorEmail}
+			ref.Author = &author
+		}
+		*push.Commits = append(*push.Commits, ref)
+	}
+	pushed, err := h.client.CreatePush(ctx, adogit.CreatePushArgs{
+		Push:         &push,
+		RepositoryId: &h.repoId,
+		Project:      &h.project,
+	})
+	if err != nil {
+		return "", err
+	}
+	if pushed.Commits == nil || len(*pushed.Commits) == 0 || (*pushed.Commits)[len(*pushed.Commits)-1].CommitId == nil {
+		return "", fmt.Errorf("push to %s returned no commits", branch)
+	}
+	return *(*pushed.Commits)[len(*pushed.Commits)-1].CommitId, nil
+}
+
+// toAdoChange converts a FileChange to the change model of the Pushes API. Content is sent
+// base64 encoded so binary files survive.
+func toAdoChange(change FileChange) adogit.GitChange {
+	item := map[string]string{"path": change.Path}
+	var changeType adogit.VersionControlChangeType
+	switch change.ChangeType {
+	case ChangeAdd:
+		changeType = adogit.VersionControlChangeTypeValues.Add
+	case ChangeEdit:
+		changeType = adogit.VersionControlChangeTypeValues.Edit
+	default:
+		changeType = adogit.VersionControlChangeTypeValues.Delete
+	}
+	result := adogit.GitChange{ChangeType: &changeType, Item: item}
+	if changeType != adogit.VersionControlChangeTypeValues.Delete {
+		content := base64.StdEncoding.EncodeToString(change.Content)
+		contentType := adogit.ItemContentTypeValues.Base64Encoded
+		result.NewContent = &adogit.ItemContent{Content: &content, ContentType: &contentType}
+	}
+	return result
+}
+
+// adoStatusCode returns the HTTP status code of an ADO API error, or 0 when it has none.
+func adoStatusCode(err error) int {
+	var wrapped azuredevops.WrappedError
+	if errors.As(err, &wrapped) && wrapped.StatusCode != nil {
+		return *wrapped.StatusCode
+	}
+	var wrappedPtr *azuredevops.WrappedError
+	if errors.As(err, &wrappedPtr) && wrappedPtr.StatusCode != nil {
+		return *wrappedPtr.StatusCode
+	}
+	if strings.Contains(err.Error(), "TF401174") {
+		// The item could not be found, reported by some servers without a status code.
+		return http.StatusNotFound
+	}
+	return 0
+}
@@ -0,0 +1,397 @@
+package repohost
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+	adogit "github.com/microsoft/azure-devops-go-api/azuredevops/git"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/identity"
+	"go.uber.org/zap"
+)
+
+var (
+	adoTestRepoId = uuid.MustParse("6f1c1f2e-3f4a-4c8e-9a55-0d2b7a6c9e01")
+	adoTestSelfId = uuid.MustParse("1d6b9f0e-8c1f-4f3a-b6a7-3e2d5c4b1a00")
+	adoTestJaneId = uuid.MustParse("9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d")
+)
+
+// adoTestRepoPath is the path of the test repository's pull requests on the fake ADO API.
+var adoTestRepoPath = "/proj/_apis/git/repositories/" + adoTestRepoId.String() + "/pullRequests"
+
+// adoTestLocations are the API resource locations the fake ADO organization serves, as listed
+// by OPTIONS /_apis, with routes modelled on the real ones.
+var adoTestLocations = []map[string]any{
+	adoLocation("e81700f7-3be2-46de-8624-2eb35882fcaa", "Location", "ResourceAreas", "_apis/{resource}/{areaId}"),
+	adoLocation("9946fd70-0d40-406e-b686-b4744cbbcc37", "git", "pullRequests", "{project}/_apis/git/repositories/{repositoryId}/pullRequests/{pullRequestId}"),
+	adoLocation("01a46dea-7d46-4d40-bc84-319e7c260d99", "git", "pullRequests", "{project}/_apis/git/pullRequests/{pullRequestId}"),
+	adoLocation("4b6702c7-aa35-4b89-9c96-b9abf6d3e540", "git", "pullRequestReviewers", "{project}/_apis/git/repositories/{repositoryId}/pullRequests/{pullRequestId}/reviewers/{reviewerId}"),
+	adoLocation("f22387e3-984e-4c52-9c6d-fbb8f14c812d", "git", "pullRequestLabels", "{project}/_apis/git/repositories/{repositoryId}/pullRequests/{pullRequestId}/labels/{labelIdOrName}"),
+	adoLocation("ab6e2e5d-a0b7-4153-b64a-a4efe0d49449", "git", "pullRequestThreads", "{project}/_apis/git/repositories/{repositoryId}/pullRequests/{pullRequestId}/threads/{threadId}"),
+	adoLocation("28010c54-d0c0-4c89-a5b0-1c9e188b9fb7", "IMS", "Identities", "_apis/identities/{identityId}"),
+	adoLocation("4bb02b5b-c120-4be2-b68e-21f7c50a4b82", "IMS", "identitySelf", "_apis/identities/self"),
+}
+
+// adoLocation returns an API resource location supporting versions up to 7.1.
+func adoLocation(id string, area string, resource string, route string) map[string]any {
+	return map[string]any{
+		"id":              id,
+		"area":            area,
+		"resourceName":    resource,
+		"routeTemplate":   route,
+		"minVersion":      "1.0",
+		"maxVersion":      "7.1",
+		"releasedVersion": "7.1",
+		"resourceVersion": 1,
+	}
+}
+
+// adoCollection wraps values in the collection envelope of the ADO REST API.
+func adoCollection(values ...any) map[string]any {
+	if values == nil {
+		values = []any{}
+	}
+	return map[string]any{"count": len(values), "value": values}
+}
+
+// newFakeAdo starts a fake ADO organization with an identity search that resolves
+// jane@example.com and finds two identities named Jane, and returns it with a host for the test
+// repository connected to it through the azure-devops-go-api clients.
+func newFakeAdo(t *testing.T) (*fakeAPI, *AdoHost) {
+	fake, server := newFakeAPI(t, azuredevops.CreateBasicAuthHeaderValue("", "pat"))
+	locations := make([]any, 0, len(adoTestLocations))
+	for _, location := range adoTestLocations {
+		locations = append(locations, location)
+	}
+	fake.handle("OPTIONS", "/_apis", func(req apiRequest) (int, any) {
+		return http.StatusOK, adoCollection(locations...)
+	})
+	// An empty resource area list makes the clients use the organization URL, as on a server.
+	fake.handle("GET", "/_apis/ResourceAreas", func(req apiRequest) (int, any) {
+		return http.StatusOK, adoCollection()
+	})
+	fake.handle("GET", "/_apis/identities", func(req apiRequest) (int, any) {
+		if req.Query["searchFilter"] != "General" {
+			t.Errorf("identity search filter = %q, want General", req.Query["searchFilter"])
+		}
+		switch req.Query["filterValue"] {
+		case "jane@example.com":
+			return http.StatusOK, adoCollection(map[string]any{"id": adoTestJaneId.String()})
+		case "Jane":
+			return http.StatusOK, adoCollection(map[string]any{"id": adoTestJaneId.String()}, map[string]any{"id": uuid.NewString()})
+		}
+		return http.StatusOK, adoCollection()
+	})
+
+	ctx := context.Background()
+	connection := azuredevops.NewPatConnection(server.URL, "pat")
+	gitClient, err := adogit.NewClient(ctx, connection)
+	if err != nil {
+		t.Fatalf("creating git client: %v", err)
+	}
+	identityClient, err := identity.NewClient(ctx, connection)
+	if err != nil {
+		t.Fatalf("creating identity client: %v", err)
+	}
+	return fake, NewAdoHost(gitClient, identityClient, "proj", adoTestRepoId, zap.NewNop())
+}
+
+// adoPull returns a pull request resource as returned by the ADO API.
+func adoPull(id int, status string, source string) map[string]any {
+	return map[string]any{
+		"pullRequestId": id,
+		"url":           "https://dev.azure.com/org/proj/_apis/git/pullRequests/" + strconv.Itoa(id),
+		"status":        status,
+		"title":         "Synced " + source,
+		"description":   "description",
+		"sourceRefName": "refs/heads/" + source,
+		"targetRefName": "refs/heads/main",
+		"createdBy":     map[string]any{"id": adoTestSelfId.String()},
+	}
+}
+
+func TestAdoCreatePullRequest(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("POST", adoTestRepoPath, func(req apiRequest) (int, any) {
+		return http.StatusCreated, adoPull(12, "active", "feature/avm-sync/x")
+	})
+	fake.handle("POST", adoTestRepoPath+"/12/labels", func(req apiRequest) (int, any) {
+		return http.StatusOK, map[string]any{"name": req.Body["name"]}
+	})
+
+	requiredId := "3c2b1a09-8f7e-4d6c-9b5a-4e3d2c1b0a99"
+	pr, err := host.CreatePullRequest(context.Background(), PullRequestOptions{
+		SourceBranch: "feature/avm-sync/x",
+		TargetBranch: "main",
+		Title:        "Synced x",
+		Description:  "description",
+		Labels:       []string{"avm-sync", "minor"},
+		Reviewers: []Reviewer{
+			{Identity: requiredId, Required: true},
+			{Identity: "jane@example.com"},
+			{Identity: "nobody@example.com"},
+		},
+		Draft: true,
+	})
+	if err != nil {
+		t.Fatalf("CreatePullRequest: %v", err)
+	}
+	if pr.ID != 12 || pr.Status != StatusActive || pr.SourceBranch != "feature/avm-sync/x" || pr.TargetBranch != "main" {
+		t.Errorf("CreatePullRequest returned %+v", pr)
+	}
+
+	wantBody := map[string]any{
+		"title":         "Synced x",
+		"description":   "description",
+		"sourceRefName": "refs/heads/feature/avm-sync/x",
+		"targetRefName": "refs/heads/main",
+		"isDraft":       true,
+		"reviewers": []any{
+			map[string]any{"id": requiredId, "isRequired": true},
+			map[string]any{"id": adoTestJaneId.String(), "isRequired": false},
+		},
+	}
+	if got := fake.request("POST", adoTestRepoPath).Body; !reflect.DeepEqual(got, wantBody) {
+		t.Errorf("create body = %v, want %v", got, wantBody)
+	}
+	wantLabels := []map[string]any{{"name": "avm-sync"}, {"name": "minor"}}
+	if got := fake.bodies("POST", adoTestRepoPath+"/12/labels"); !reflect.DeepEqual(got, wantLabels) {
+		t.Errorf("label bodies = %v, want %v", got, wantLabels)
+	}
+}
+
+func TestAdoCreatePullRequestExists(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("POST", adoTestRepoPath, func(req apiRequest) (int, any) {
+		return http.StatusConflict, map[string]any{
+			"message": "TF401179: An active pull request for the source and target branch already exists.",
+			"typeKey": "GitPullRequestExistsException",
+		}
+	})
+
+	_, err := host.CreatePullRequest(context.Background(), PullRequestOptions{
+		SourceBranch: "feature/avm-sync/x",
+		TargetBranch: "main",
+		Labels:       []string{"avm-sync"},
+	})
+	if !errors.Is(err, ErrPullRequestExists) {
+		t.Fatalf("CreatePullRequest error = %v, want ErrPullRequestExists", err)
+	}
+	for _, req := range fake.requests {
+		if strings.HasSuffix(req.Path, "/labels") {
+			t.Errorf("added a label with %s %s after the create failed", req.Method, req.Path)
+		}
+	}
+}
+
+func TestAdoUpdatePullRequest(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("PATCH", adoTestRepoPath+"/12", func(req apiRequest) (int, any) {
+		return http.StatusOK, adoPull(12, "active", "feature/avm-sync/x")
+	})
+
+	if _, err := host.UpdatePullRequest(context.Background(), 12, "New title", strings.Repeat("line\n", 1000)); err != nil {
+		t.Fatalf("UpdatePullRequest: %v", err)
+	}
+	body := fake.request("PATCH", adoTestRepoPath+"/12").Body
+	if body["title"] != "New title" {
+		t.Errorf("update title = %v, want New title", body["title"])
+	}
+	description, _ := body["description"].(string)
+	if len(description) > adoMaxDescriptionLength || !strings.HasSuffix(description, "_Description truncated._") {
+		t.Errorf("update description of %d characters wasn't truncated to the ADO limit", len(description))
+	}
+}
+
+func TestAdoReplaceLabels(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("GET", adoTestRepoPath+"/12/labels", func(req apiRequest) (int, any) {
+		return http.StatusOK, adoCollection(map[string]any{"name": "avm-sync:patch"}, map[string]any{"name": "avm-sync:major"}, map[string]any{"name": "keep-me"})
+	})
+	fake.handle("DELETE", adoTestRepoPath+"/12/labels/avm-sync:patch", func(req apiRequest) (int, any) {
+		return http.StatusNoContent, nil
+	})
+	fake.handle("POST", adoTestRepoPath+"/12/labels", func(req apiRequest) (int, any) {
+		return http.StatusOK, map[string]any{"name": req.Body["name"]}
+	})
+
+	if err := host.SetLabels(context.Background(), 12, []string{"avm-sync:major"}, "avm-sync:"); err != nil {
+		t.Fatalf("SetLabels: %v", err)
+	}
+	if n := fake.count("DELETE", adoTestRepoPath+"/12/labels/avm-sync:patch"); n != 1 {
+		t.Errorf("removed the stale label %d times, want once", n)
+	}
+	if got, want := fake.bodies("POST", adoTestRepoPath+"/12/labels"), []map[string]any{{"name": "avm-sync:major"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("label bodies = %v, want %v", got, want)
+	}
+}
+
+func TestAdoSetDraft(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("PATCH", adoTestRepoPath+"/12", func(req apiRequest) (int, any) {
+		return http.StatusOK, adoPull(12, "active", "feature/avm-sync/x")
+	})
+
+	if err := host.SetDraft(context.Background(), 12, true); err != nil {
+		t.Fatalf("SetDraft: %v", err)
+	}
+	if got, want := fake.request("PATCH", adoTestRepoPath+"/12").Body, map[string]any{"isDraft": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("draft body = %v, want %v", got, want)
+	}
+}
+
+func TestAdoFindPullRequest(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("GET", adoTestRepoPath, func(req apiRequest) (int, any) {
+		return http.StatusOK, adoCollection(adoPull(12, "active", "feature/avm-sync/x"))
+	})
+
+	pr, err := host.FindPullRequest(context.Background(), "feature/avm-sync/x", "main")
+	if err != nil {
+		t.Fatalf("FindPullRequest: %v", err)
+	}
+	if pr == nil || pr.ID != 12 {
+		t.Errorf("FindPullRequest returned %+v", pr)
+	}
+	wantQuery := map[string]string{
+		"searchCriteria.sourceRefName": "refs/heads/feature/avm-sync/x",
+		"searchCriteria.targetRefName": "refs/heads/main",
+		"searchCriteria.status":        "active",
+	}
+	if got := fake.request("GET", adoTestRepoPath).Query; !reflect.DeepEqual(got, wantQuery) {
+		t.Errorf("find query = %v, want %v", got, wantQuery)
+	}
+}
+
+func TestAdoAddReviewers(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	reviewerPath := adoTestRepoPath + "/12/reviewers/" + adoTestJaneId.String()
+	fake.handle("PUT", reviewerPath, func(req apiRequest) (int, any) {
+		return http.StatusOK, req.Body
+	})
+
+	for _, required := range []bool{true, false} {
+		reviewers := []Reviewer{{Identity: "jane@example.com", Required: required}, {Identity: "nobody@example.com"}, {Identity: "Jane"}}
+		if err := host.AddReviewers(context.Background(), 12, reviewers); err != nil {
+			t.Fatalf("AddReviewers: %v", err)
+		}
+	}
+	wantBodies := []map[string]any{
+		{"id": adoTestJaneId.String(), "isRequired": true},
+		{"id": adoTestJaneId.String(), "isRequired": false},
+	}
+	if got := fake.bodies("PUT", reviewerPath); !reflect.DeepEqual(got, wantBodies) {
+		t.Errorf("reviewer bodies = %v, want %v", got, wantBodies)
+	}
+	// The names are cached after the first lookup, including the ones that didn't resolve.
+	if n := fake.count("GET", "/_apis/identities"); n != 3 {
+		t.Errorf("made %d identity searches, want 3", n)
+	}
+}
+
+func TestAdoAddComment(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("POST", adoTestRepoPath+"/12/threads", func(req apiRequest) (int, any) {
+		return http.StatusOK, map[string]any{"id": 1}
+	})
+
+	if err := host.AddComment(context.Background(), 12, "Re-synced"); err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	want := map[string]any{"status": "closed", "comments": []any{map[string]any{"content": "Re-synced"}}}
+	if got := fake.request("POST", adoTestRepoPath+"/12/threads").Body; !reflect.DeepEqual(got, want) {
+		t.Errorf("thread body = %v, want %v", got, want)
+	}
+}
+
+func TestAdoSetAutoComplete(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("GET", "/proj/_apis/git/pullRequests/12", func(req apiRequest) (int, any) {
+		return http.StatusOK, adoPull(12, "active", "feature/avm-sync/x")
+	})
+	fake.handle("PATCH", adoTestRepoPath+"/12", func(req apiRequest) (int, any) {
+		return http.StatusOK, adoPull(12, "active", "feature/avm-sync/x")
+	})
+
+	if err := host.SetAutoComplete(context.Background(), 12, AutoCompleteOptions{MergeStrategy: "squash", DeleteSourceBranch: true}); err != nil {
+		t.Fatalf("SetAutoComplete: %v", err)
+	}
+	want := map[string]any{
+		"autoCompleteSetBy": map[string]any{"id": adoTestSelfId.String()},
+		"completionOptions": map[string]any{"mergeStrategy": "squash", "deleteSourceBranch": true, "transitionWorkItems": false},
+	}
+	if got := fake.request("PATCH", adoTestRepoPath+"/12").Body; !reflect.DeepEqual(got, want) {
+		t.Errorf("auto-complete body = %v, want %v", got, want)
+	}
+}
+
+func TestAdoCancelAutoComplete(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("PATCH", adoTestRepoPath+"/12", func(req apiRequest) (int, any) {
+		return http.StatusOK, adoPull(12, "active", "feature/avm-sync/x")
+	})
+
+	if err := host.CancelAutoComplete(context.Background(), 12); err != nil {
+		t.Fatalf("CancelAutoComplete: %v", err)
+	}
+	want := map[string]any{"autoCompleteSetBy": map[string]any{"id": uuid.Nil.String()}}
+	if got := fake.request("PATCH", adoTestRepoPath+"/12").Body; !reflect.DeepEqual(got, want) {
+		t.Errorf("cancel auto-complete body = %v, want %v", got, want)
+	}
+}
+
+func TestAdoListAndAbandonPullRequests(t *testing.T) {
+	fake, host := newFakeAdo(t)
+	fake.handle("GET", "/_apis/identities/self", func(req apiRequest) (int, any) {
+		return http.StatusOK, map[string]any{"id": adoTestSelfId.String()}
+	})
+	fake.handle("GET", adoTestRepoPath, func(req apiRequest) (int, any) {
+		if req.Query["searchCriteria.creatorId"] != adoTestSelfId.String() || req.Query["searchCriteria.status"] != "active" {
+			t.Errorf("list query = %v", req.Query)
+		}
+		var prs []any
+		switch req.Query["$skip"] {
+		case "0":
+			for i := 1; i <= adoPageSize; i++ {
+				source := "topic/" + strconv.Itoa(i)
+				if i == 1 {
+					source = "feature/avm-sync/a"
+				}
+				prs = append(prs, adoPull(i, "active", source))
+			}
+		case strconv.Itoa(adoPageSize):
+			prs = append(prs, adoPull(101, "active", "feature/avm-sync/b"))
+		default:
+			t.Errorf("requested $skip=%s after a short page", req.Query["$skip"])
+		}
+		return http.StatusOK, adoCollection(prs...)
+	})
+	fake.handle("PATCH", adoTestRepoPath+"/101", func(req apiRequest) (int, any) {
+		return http.StatusOK, adoPull(101, "abandoned", "feature/avm-sync/b")
+	})
+
+	prs, err := host.ListPullRequests(context.Background(), "feature/avm-sync/")
+	if err != nil {
+		t.Fatalf("ListPullRequests: %v", err)
+	}
+	var got []string
+	for _, pr := range prs {
+		got = append(got, pr.SourceBranch)
+	}
+	if want := []string{"feature/avm-sync/a", "feature/avm-sync/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListPullRequests returned %v, want %v", got, want)
+	}
+
+	if err := host.AbandonPullRequest(context.Background(), 101); err != nil {
+		t.Fatalf("AbandonPullRequest: %v", err)
+	}
+	if got, want := fake.request("PATCH", adoTestRepoPath+"/101").Body, map[string]any{"status": "abandoned"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("abandon body = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,310 @@
+package repohost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultGitHubApiUrl is the REST API root of github.com. GitHub Enterprise Server serves the
+// API under https://<host>/api/v3.
+const DefaultGitHubApiUrl = "https://api.github.com"
+
+// GitHubHost implements RepositoryHost on the GitHub REST API.
+type GitHubHost struct {
+	baseURL string
+	owner   string
+	repo    string
+	token   string
+	client  *http.Client
+}
+
+// NewGitHubHost returns a RepositoryHost for owner/repo served by the REST API at baseURL. A
+// nil client uses http.DefaultClient.
+func NewGitHubHost(baseURL string, owner string, repo string, token string, client *http.Client) *GitHubHost {
+	if baseURL == "" {
+		baseURL = DefaultGitHubApiUrl
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &GitHubHost{baseURL: strings.TrimSuffix(baseURL, "/"), owner: owner, repo: repo, token: token, client: client}
+}
+
+// gitHubPullRequest is the subset of the GitHub pull request resource used by the sync.
+type gitHubPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	State   string `json:"state"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	Draft   bool   `json:"draft"`
+	Merged  bool   `json:"merged"`
+	// MergedAt is set on list responses, which don't include Merged.
+	MergedAt *string `json:"merged_at"`
+	User     struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Head struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+// gitHubError is the error body returned by the GitHub REST API.
+type gitHubError struct {
+	Message string `json:"message"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// toPullRequest converts a GitHub pull request into a PullRequest, mapping open to active and
+// closed to completed or abandoned depending on whether it was merged.
+func (pr *gitHubPullRequest) toPullRequest() *PullRequest {
+	status := StatusActive
+	if pr.State == "closed" {
+		status = StatusAbandoned
+		if pr.Merged || pr.MergedAt != nil {
+			status = StatusCompleted
+		}
+	}
+	return &PullRequest{
+		ID:           pr.Number,
+		URL:          pr.HTMLURL,
+		Status:       status,
+		Title:        pr.Title,
+		Description:  pr.Body,
+		SourceBranch: pr.Head.Ref,
+		TargetBranch: pr.Base.Ref,
+		IsDraft:      pr.Draft,
+	}
+}
+
+// do sends a request to the GitHub API path below the repository and decodes a JSON response
+// into out when out is non-nil. Non-2xx responses are returned as errors carrying the API
+// message.
+func (h *GitHubHost) do(ctx context.Context, method string, path string, body any, out any) error {
+	return h.doURL(ctx, method, "/repos/"+url.PathEscape(h.owner)+"/"+url.PathEscape(h.repo)+path, body, out)
+}
+
+// doURL is do for an API path relative to the API root rather than the repository.
+func (h *GitHubHost) doURL(ctx context.Context, method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	endpoint := h.baseURL + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr gitHubError
+		_ = json.Unmarshal(data, &apiErr)
+		messages := []string{apiErr.Message}
+		for _, e := range apiErr.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("GitHub API %s %s returned %d: %s", method, path, resp.StatusCode, strings.Join(messages, "; "))
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// CreatePullRequest opens a pull request, then requests reviewers and adds labels.
+func (h *GitHubHost) CreatePullRequest(ctx context.Context, opts PullRequestOptions) (*PullRequest, error) {
+	body := map[string]any{
+		"title": opts.Title,
+		"body":  opts.Description,
+		"head":  opts.SourceBranch,
+		"base":  opts.TargetBranch,
+		"draft": opts.Draft,
+	}
+	var created gitHubPullRequest
+	if err := h.do(ctx, http.MethodPost, "/pulls", body, &created); err != nil {
+		if strings.Contains(err.Error(), "A pull request already exists") {
+			return nil, fmt.Errorf("%w: %v", ErrPullRequestExists, err)
+		}
+		return nil, err
+	}
+	result := created.toPullRequest()
+	if err := h.AddReviewers(ctx, result.ID, opts.Reviewers); err != nil {
+		return result, err
+	}
+	if err := h.SetLabels(ctx, result.ID, opts.Labels, ""); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// UpdatePullRequest replaces the title and description of a pull request.
+func (h *GitHubHost) UpdatePullRequest(ctx context.Context, id int, title string, description string) (*PullRequest, error) {
+	var updated gitHubPullRequest
+	body := map[string]any{"title": title, "body": description}
+	if err := h.do(ctx, http.MethodPatch, "/pulls/"+strconv.Itoa(id), body, &updated); err != nil {
+		return nil, err
+	}
+	return updated.toPullRequest(), nil
+}
+
+// FindPullRequest returns the open pull request from sourceBranch into targetBranch.
+func (h *GitHubHost) FindPullRequest(ctx context.Context, sourceBranch string, targetBranch string) (*PullRequest, error) {
+	query := url.Values{}
+	query.Set("state", "open")
+	query.Set("head", h.owner+":"+sourceBranch)
+	query.Set("base", targetBranch)
+	var prs []gitHubPullRequest
+	if err := h.do(ctx, http.MethodGet, "/pulls?"+query.Encode(), nil, &prs); err != nil {
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return prs[0].toPullRequest(), nil
+}
+
+// AddReviewers requests reviews from users, or from teams when given as org/team slugs.
+// GitHub has no required reviewers per pull request, so Required is ignored.
+func (h *GitHubHost) AddReviewers(ctx context.Context, id int, reviewers []Reviewer) error {
+	if len(reviewers) == 0 {
+		return nil
+	}
+	users := []string{}
+	teams := []string{}
+	for _, reviewer := range reviewers {
+		if _, team, ok := strings.Cut(reviewer.Identity, "/"); ok {
+			teams = append(teams, team)
+		} else {
+			users = append(users, reviewer.Identity)
+		}
+	}
+	body := map[string]any{"reviewers": users, "team_reviewers": teams}
+	return h.do(ctx, http.MethodPost, "/pulls/"+strconv.Itoa(id)+"/requested_reviewers", body, nil)
+}
+
+// SetLabels adds the given labels to a pull request, creating them if needed, after removing
+// the labels starting with replacePrefix that aren't among them.
+func (h *GitHubHost) SetLabels(ctx context.Context, id int, labels []string, replacePrefix string) error {
+	if replacePrefix != "" {
+		var existing []struct {
+			Name string `json:"name"`
+		}
+		if err := h.do(ctx, http.MethodGet, "/issues/"+strconv.Itoa(id)+"/labels?per_page=100", nil, &existing); err != nil {
+			return fmt.Errorf("error reading labels of pull request %d: %w", id, err)
+		}
+		for _, label := range existing {
+			if !strings.HasPrefix(label.Name, replacePrefix) || slices.Contains(labels, label.Name) {
+				continue
+			}
+			if err := h.do(ctx, http.MethodDelete, "/issues/"+strconv.Itoa(id)+"/labels/"+url.PathEscape(label.Name), nil, nil); err != nil {
+				return fmt.Errorf("error removing label %s from pull request %d: %w", label.Name, id, err)
+			}
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return h.do(ctx, http.MethodPost, "/issues/"+strconv.Itoa(id)+"/labels", map[string]any{"labels": labels}, nil)
+}
+
+// SetDraft is not supported: converting a pull request to or from a draft is only available
+// through the GraphQL API.
+func (h *GitHubHost) SetDraft(ctx context.Context, id int, draft bool) error {
+	return ErrNotSupported
+}
+
+// AddComment posts a comment on a pull request's conversation.
+func (h *GitHubHost) AddComment(ctx context.Context, id int, text string) error {
+	return h.do(ctx, http.MethodPost, "/issues/"+strconv.Itoa(id)+"/comments", map[string]any{"body": text}, nil)
+}
+
+// SetAutoComplete is not supported: GitHub auto-merge is only available through the GraphQL
+// API and branch deletion is a repository setting.
+func (h *GitHubHost) SetAutoComplete(ctx context.Context, id int, opts AutoCompleteOptions) error {
+	return ErrNotSupported
+}
+
+// CancelAutoComplete does nothing, since SetAutoComplete never enables auto-merge on GitHub.
+func (h *GitHubHost) CancelAutoComplete(ctx context.Context, id int) error {
+	return nil
+}
+
+// gitHubPageSize is the number of pull requests requested per page when listing.
+const gitHubPageSize = 100
+
+// ListPullRequests returns the open pull requests opened by the token's user whose head branch
+// in this repository starts with sourceBranchPrefix.
+func (h *GitHubHost) ListPullRequests(ctx context.Context, sourceBranchPrefix string) ([]PullRequest, error) {
+	var self struct {
+		Login string `json:"login"`
+	}
+	if err := h.doURL(ctx, http.MethodGet, "/user", nil, &self); err != nil {
+		return nil, fmt.Errorf("error reading the authenticated user: %w", err)
+	}
+	var result []PullRequest
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("state", "open")
+		query.Set("per_page", strconv.Itoa(gitHubPageSize))
+		query.Set("page", strconv.Itoa(page))
+		var prs []gitHubPullRequest
+		if err := h.do(ctx, http.MethodGet, "/pulls?"+query.Encode(), nil, &prs); err != nil {
+			return nil, err
+		}
+		for i := range prs {
+			if strings.EqualFold(prs[i].User.Login, self.Login) && strings.HasPrefix(prs[i].Head.Ref, sourceBranchPrefix) {
+				result = append(result, *prs[i].toPullRequest())
+			}
+		}
+		if len(prs) < gitHubPageSize {
+			return result, nil
+		}
+	}
+}
+
+// AbandonPullRequest closes a pull request without merging it.
+func (h *GitHubHost) AbandonPullRequest(ctx context.Context, id int) error {
+	return h.do(ctx, http.MethodPatch, "/pulls/"+strconv.Itoa(id), map[string]any{"state": "closed"}, nil)
+}
+
+// GetPullRequestStatus returns the status of a pull request.
+func (h *GitHubHost) GetPullRequestStatus(ctx context.Context, id int) (string, error) {
+	var pr gitHubPullRequest
+	if err := h.do(ctx, http.MethodGet, "/pulls/"+strconv.Itoa(id), nil, &pr); err != nil {
+		return "", err
+	}
+	return pr.toPullRequest().Status, nil
+}
@@ -0,0 +1,284 @@
+package repohost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// newFakeGitHub starts a fake GitHub API and returns it with a host for the owner/repo
+// repository on it.
+func newFakeGitHub(t *testing.T) (*fakeAPI, *GitHubHost) {
+	fake, server := newFakeAPI(t, "Bearer token")
+	return fake, NewGitHubHost(server.URL, "owner", "repo", "token", server.Client())
+}
+
+// gitHubPull returns a pull request resource as returned by the GitHub API.
+func gitHubPull(number int, state string, login string, head string, mergedAt any) map[string]any {
+	return map[string]any{
+		"number":    number,
+		"html_url":  fmt.Sprintf("https://github.com/owner/repo/pull/%d", number),
+		"state":     state,
+		"title":     "Synced " + head,
+		"body":      "description",
+		"merged_at": mergedAt,
+		"user":      map[string]any{"login": login},
+		"head":      map[string]any{"ref": head},
+		"base":      map[string]any{"ref": "main"},
+	}
+}
+
+func TestGitHubCreatePullRequest(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+	fake.handle("POST", "/repos/owner/repo/pulls", func(req apiRequest) (int, any) {
+		return http.StatusCreated, gitHubPull(7, "open", "sync-bot", "feature/avm-sync/x", nil)
+	})
+	fake.handle("POST", "/repos/owner/repo/pulls/7/requested_reviewers", func(req apiRequest) (int, any) {
+		return http.StatusCreated, map[string]any{}
+	})
+	fake.handle("POST", "/repos/owner/repo/issues/7/labels", func(req apiRequest) (int, any) {
+		return http.StatusOK, []any{}
+	})
+
+	pr, err := host.CreatePullRequest(context.Background(), PullRequestOptions{
+		SourceBranch: "feature/avm-sync/x",
+		TargetBranch: "main",
+		Title:        "Synced x",
+		Description:  "description",
+		Labels:       []string{"avm-sync", "minor"},
+		Reviewers:    []Reviewer{{Identity: "jane", Required: true}, {Identity: "org/platform"}},
+		Draft:        true,
+	})
+	if err != nil {
+		t.Fatalf("CreatePullRequest: %v", err)
+	}
+	if pr.ID != 7 || pr.Status != StatusActive || pr.URL != "https://github.com/owner/repo/pull/7" {
+		t.Errorf("CreatePullRequest returned %+v", pr)
+	}
+
+	wantBody := map[string]any{"title": "Synced x", "body": "description", "head": "feature/avm-sync/x", "base": "main", "draft": true}
+	if got := fake.request("POST", "/repos/owner/repo/pulls").Body; !reflect.DeepEqual(got, wantBody) {
+		t.Errorf("create body = %v, want %v", got, wantBody)
+	}
+	wantReviewers := map[string]any{"reviewers": []any{"jane"}, "team_reviewers": []any{"platform"}}
+	if got := fake.request("POST", "/repos/owner/repo/pulls/7/requested_reviewers").Body; !reflect.DeepEqual(got, wantReviewers) {
+		t.Errorf("requested_reviewers body = %v, want %v", got, wantReviewers)
+	}
+	wantLabels := map[string]any{"labels": []any{"avm-sync", "minor"}}
+	if got := fake.request("POST", "/repos/owner/repo/issues/7/labels").Body; !reflect.DeepEqual(got, wantLabels) {
+		t.Errorf("labels body = %v, want %v", got, wantLabels)
+	}
+}
+
+func TestGitHubCreatePullRequestExists(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+	fake.handle("POST", "/repos/owner/repo/pulls", func(req apiRequest) (int, any) {
+		return http.StatusUnprocessableEntity, map[string]any{
+			"message": "Validation Failed",
+			"errors":  []any{map[string]any{"message": "A pull request already exists for owner:feature/avm-sync/x."}},
+		}
+	})
+
+	_, err := host.CreatePullRequest(context.Background(), PullRequestOptions{SourceBranch: "feature/avm-sync/x", TargetBranch: "main"})
+	if !errors.Is(err, ErrPullRequestExists) {
+		t.Fatalf("CreatePullRequest error = %v, want ErrPullRequestExists", err)
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("made %d requests after the create failed, want none", len(fake.requests)-1)
+	}
+}
+
+func TestGitHubFindPullRequest(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+	fake.handle("GET", "/repos/owner/repo/pulls", func(req apiRequest) (int, any) {
+		return http.StatusOK, []any{gitHubPull(3, "open", "sync-bot", "feature/avm-sync/x", nil)}
+	})
+
+	pr, err := host.FindPullRequest(context.Background(), "feature/avm-sync/x", "main")
+	if err != nil {
+		t.Fatalf("FindPullRequest: %v", err)
+	}
+	if pr == nil || pr.ID != 3 || pr.SourceBranch != "feature/avm-sync/x" || pr.TargetBranch != "main" {
+		t.Errorf("FindPullRequest returned %+v", pr)
+	}
+	wantQuery := map[string]string{"state": "open", "head": "owner:feature/avm-sync/x", "base": "main"}
+	if got := fake.request("GET", "/repos/owner/repo/pulls").Query; !reflect.DeepEqual(got, wantQuery) {
+		t.Errorf("find query = %v, want %v", got, wantQuery)
+	}
+}
+
+func TestGitHubFindPullRequestNone(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+	fake.handle("GET", "/repos/owner/repo/pulls", func(req apiRequest) (int, any) {
+		return http.StatusOK, []any{}
+	})
+
+	pr, err := host.FindPullRequest(context.Background(), "feature/avm-sync/x", "main")
+	if err != nil || pr != nil {
+		t.Errorf("FindPullRequest = %+v, %v, want nil, nil", pr, err)
+	}
+}
+
+func TestGitHubUpdatePullRequestAndComment(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+	fake.handle("PATCH", "/repos/owner/repo/pulls/5", func(req apiRequest) (int, any) {
+		return http.StatusOK, gitHubPull(5, "open", "sync-bot", "feature/avm-sync/x", nil)
+	})
+	fake.handle("POST", "/repos/owner/repo/issues/5/comments", func(req apiRequest) (int, any) {
+		return http.StatusCreated, map[string]any{"id": 1}
+	})
+
+	if _, err := host.UpdatePullRequest(context.Background(), 5, "New title", "New description"); err != nil {
+		t.Fatalf("UpdatePullRequest: %v", err)
+	}
+	if err := host.AddComment(context.Background(), 5, "Re-synced"); err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+
+	wantUpdate := map[string]any{"title": "New title", "body": "New description"}
+	if got := fake.request("PATCH", "/repos/owner/repo/pulls/5").Body; !reflect.DeepEqual(got, wantUpdate) {
+		t.Errorf("update body = %v, want %v", got, wantUpdate)
+	}
+	wantComment := map[string]any{"body": "Re-synced"}
+	if got := fake.request("POST", "/repos/owner/repo/issues/5/comments").Body; !reflect.DeepEqual(got, wantComment) {
+		t.Errorf("comment body = %v, want %v", got, wantComment)
+	}
+}
+
+func TestGitHubNoReviewersOrLabels(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+
+	if err := host.AddReviewers(context.Background(), 5, nil); err != nil {
+		t.Errorf("AddReviewers: %v", err)
+	}
+	if err := host.SetLabels(context.Background(), 5, nil, ""); err != nil {
+		t.Errorf("SetLabels: %v", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("made %d requests, want none", len(fake.requests))
+	}
+}
+
+func TestGitHubReplaceLabels(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+	fake.handle("GET", "/repos/owner/repo/issues/5/labels", func(req apiRequest) (int, any) {
+		return http.StatusOK, []any{map[string]any{"name": "avm-sync:patch"}, map[string]any{"name": "avm-sync:major"}, map[string]any{"name": "keep-me"}}
+	})
+	fake.handle("DELETE", "/repos/owner/repo/issues/5/labels/avm-sync:patch", func(req apiRequest) (int, any) {
+		return http.StatusOK, []any{}
+	})
+	fake.handle("POST", "/repos/owner/repo/issues/5/labels", func(req apiRequest) (int, any) {
+		return http.StatusOK, []any{}
+	})
+
+	if err := host.SetLabels(context.Background(), 5, []string{"avm-sync:major"}, "avm-sync:"); err != nil {
+		t.Fatalf("SetLabels: %v", err)
+	}
+	if n := fake.count("DELETE", "/repos/owner/repo/issues/5/labels/avm-sync:patch"); n != 1 {
+		t.Errorf("removed the stale label %d times, want once", n)
+	}
+	if got, want := fake.request("POST", "/repos/owner/repo/issues/5/labels").Body, map[string]any{"labels": []any{"avm-sync:major"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("labels body = %v, want %v", got, want)
+	}
+}
+
+func TestGitHubSetDraftNotSupported(t *testing.T) {
+	_, host := newFakeGitHub(t)
+	if err := host.SetDraft(context.Background(), 5, true); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetDraft error = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestGitHubListPullRequests(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+	fake.handle("GET", "/user", func(req apiRequest) (int, any) {
+		return http.StatusOK, map[string]any{"login": "Sync-Bot"}
+	})
+	fake.handle("GET", "/repos/owner/repo/pulls", func(req apiRequest) (int, any) {
+		if req.Query["state"] != "open" || req.Query["per_page"] != strconv.Itoa(gitHubPageSize) {
+			t.Errorf("list query = %v", req.Query)
+		}
+		var prs []any
+		switch req.Query["page"] {
+		case "1":
+			for i := 1; i <= gitHubPageSize; i++ {
+				login, head := "someone", "topic/"+strconv.Itoa(i)
+				switch i {
+				case 1:
+					login, head = "sync-bot", "feature/avm-sync/a"
+				case 2:
+					head = "feature/avm-sync/b"
+				case 3:
+					login = "sync-bot"
+				}
+				prs = append(prs, gitHubPull(i, "open", login, head, nil))
+			}
+		case "2":
+			prs = append(prs, gitHubPull(101, "open", "sync-bot", "feature/avm-sync/c", nil))
+		default:
+			t.Errorf("requested page %s after a short page", req.Query["page"])
+		}
+		return http.StatusOK, prs
+	})
+
+	prs, err := host.ListPullRequests(context.Background(), "feature/avm-sync/")
+	if err != nil {
+		t.Fatalf("ListPullRequests: %v", err)
+	}
+	var got []string
+	for _, pr := range prs {
+		got = append(got, pr.SourceBranch)
+	}
+	if want := []string{"feature/avm-sync/a", "feature/avm-sync/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListPullRequests returned %v, want %v", got, want)
+	}
+}
+
+func TestGitHubAbandonAndStatus(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+	fake.handle("PATCH", "/repos/owner/repo/pulls/9", func(req apiRequest) (int, any) {
+		return http.StatusOK, gitHubPull(9, "closed", "sync-bot", "feature/avm-sync/x", nil)
+	})
+	for number, pr := range map[int]map[string]any{
+		1: gitHubPull(1, "open", "sync-bot", "feature/avm-sync/a", nil),
+		2: gitHubPull(2, "closed", "sync-bot", "feature/avm-sync/b", "2026-01-02T03:04:05Z"),
+		3: gitHubPull(3, "closed", "sync-bot", "feature/avm-sync/c", nil),
+	} {
+		fake.handle("GET", "/repos/owner/repo/pulls/"+strconv.Itoa(number), func(req apiRequest) (int, any) {
+			return http.StatusOK, pr
+		})
+	}
+
+	if err := host.AbandonPullRequest(context.Background(), 9); err != nil {
+		t.Fatalf("AbandonPullRequest: %v", err)
+	}
+	if got, want := fake.request("PATCH", "/repos/owner/repo/pulls/9").Body, map[string]any{"state": "closed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("abandon body = %v, want %v", got, want)
+	}
+	for id, want := range map[int]string{1: StatusActive, 2: StatusCompleted, 3: StatusAbandoned} {
+		status, err := host.GetPullRequestStatus(context.Background(), id)
+		if err != nil {
+			t.Fatalf("GetPullRequestStatus(%d): %v", id, err)
+		}
+		if status != want {
+			t.Errorf("GetPullRequestStatus(%d) = %s, want %s", id, status, want)
+		}
+	}
+}
+
+func TestGitHubSetAutoCompleteNotSupported(t *testing.T) {
+	fake, host := newFakeGitHub(t)
+	if err := host.SetAutoComplete(context.Background(), 1, AutoCompleteOptions{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetAutoComplete error = %v, want ErrNotSupported", err)
+	}
+	if err := host.CancelAutoComplete(context.Background(), 1); err != nil {
+		t.Errorf("CancelAutoComplete: %v", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("made %d requests, want none", len(fake.requests))
+	}
+}
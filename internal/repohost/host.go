@@ -0,0 +1,143 @@
+package repohost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/theonlyway/avm-module-sync/internal/ado"
+	"github.com/theonlyway/avm-module-sync/internal/config"
+	"go.uber.org/zap"
+)
+
+// Supported repository hosts, selected with --repository-host.
+const (
+	HostAzureDevOps = "ado"
+	HostGitHub      = "github"
+)
+
+// Pull request statuses, normalised across hosts.
+const (
+	StatusActive    = "active"
+	StatusCompleted = "completed"
+	StatusAbandoned = "abandoned"
+)
+
+// Merge strategies used when a pull request is completed.
+const (
+	MergeSquash        = "squash"
+	MergeRebase        = "rebase"
+	MergeNoFastForward = "noFastForward"
+)
+
+// ErrNotSupported is returned by operations the repository host has no equivalent for.
+var ErrNotSupported = errors.New("not supported by the repository host")
+
+// ErrPullRequestExists is returned by CreatePullRequest when an active pull request already
+// exists for the source branch, e.g. on a re-run.
+var ErrPullRequestExists = errors.New("an active pull request already exists for the source branch")
+
+// PullRequest is a pull request as seen by the sync, independent of the hosting service.
+type PullRequest struct {
+	ID           int
+	URL          string
+	Status       string
+	Title        string
+	Description  string
+	SourceBranch string
+	TargetBranch string
+	IsDraft      bool
+}
+
+// Reviewer is a pull request reviewer. Identity is an ADO identity ID, unique name (e.g. an
+// email address or "[Project]\Team") or display name, or a GitHub user login or org/team slug.
+// Required reviewers must approve on ADO; GitHub has no per pull request equivalent so they are
+// requested like any other reviewer there.
+type Reviewer struct {
+	Identity string
+	Required bool
+}
+
+// PullRequestOptions describes a pull request to create. Branches are short branch names
+// without the refs/heads/ prefix.
+type PullRequestOptions struct {
+	SourceBranch string
+	TargetBranch string
+	Title        string
+	Description  string
+	Reviewers    []Reviewer
+	Labels       []string
+	Draft        bool
+}
+
+// AutoCompleteOptions are the completion settings of a pull request set to complete
+// automatically once its policies pass.
+type AutoCompleteOptions struct {
+	MergeStrategy       string
+	DeleteSourceBranch  bool
+	TransitionWorkItems bool
+}
+
+// RepositoryHost is the pull request API of the service hosting the internal module
+// repository. Git itself is always driven through the git CLI against origin.
+type RepositoryHost interface {
+	// CreatePullRequest opens a pull request, returning ErrPullRequestExists when one is
+	// already active for the source branch.
+	CreatePullRequest(ctx context.Context, opts PullRequestOptions) (*PullRequest, error)
+	// UpdatePullRequest replaces the title and description of a pull request.
+	UpdatePullRequest(ctx context.Context, id int, title string, description string) (*PullRequest, error)
+	// FindPullRequest returns the active pull request from sourceBranch into targetBranch, or
+	// nil when there is none.
+	FindPullRequest(ctx context.Context, sourceBranch string, targetBranch string) (*PullRequest, error)
+	// AddReviewers requests reviews from the given reviewers.
+	AddReviewers(ctx context.Context, id int, reviewers []Reviewer) error
+	// SetLabels adds the given labels to a pull request and removes the labels starting with
+	// replacePrefix that aren't among them. An empty replacePrefix removes nothing.
+	SetLabels(ctx context.Context, id int, labels []string, replacePrefix string) error
+	// SetDraft marks a pull request as a draft, or as ready for review.
+	SetDraft(ctx context.Context, id int, draft bool) error
+	// AddComment posts an informational comment on a pull request.
+	AddComment(ctx context.Context, id int, text string) error
+	// SetAutoComplete makes a pull request complete automatically with the given options once
+	// its policies pass.
+	SetAutoComplete(ctx context.Context, id int, opts AutoCompleteOptions) error
+	// CancelAutoComplete clears auto-complete from a pull request so it waits for a human.
+	CancelAutoComplete(ctx context.Context, id int) error
+	// GetPullRequestStatus returns StatusActive, StatusCompleted or StatusAbandoned.
+	GetPullRequestStatus(ctx context.Context, id int) (string, error)
+	// ListPullRequests returns the active pull requests created by the authenticated identity
+	// whose source branch starts with sourceBranchPrefix.
+	ListPullRequests(ctx context.Context, sourceBranchPrefix string) ([]PullRequest, error)
+	// AbandonPullRequest abandons an active pull request without merging it.
+	AbandonPullRequest(ctx context.Context, id int) error
+}
+
+// NewFromConfig returns the repository host selected by config.RepositoryHost. The ADO
+// clients are returned alongside so ADO-only features can use them; they are nil for other
+// hosts.
+func NewFromConfig(ctx context.Context, logger *zap.Logger) (RepositoryHost, *ado.AdoClients, error) {
+	switch config.RepositoryHost {
+	case HostAzureDevOps, "":
+		clients := ado.NewAdoClients(logger, ctx)
+		var repoId uuid.UUID
+		if config.AdoRepoId != "" {
+			var err error
+			if repoId, err = uuid.Parse(config.AdoRepoId); err != nil {
+				return nil, nil, fmt.Errorf("error parsing ADO repository ID: %w", err)
+			}
+		}
+		return NewAdoHost(clients.GitClient, clients.IdentityClient, config.AdoProject, repoId, logger), clients, nil
+	case HostGitHub:
+		if config.GitHubOwner == "" || config.GitHubRepo == "" {
+			return nil, nil, fmt.Errorf("--github-owner and --github-repo are required for the %s repository host", HostGitHub)
+		}
+		logger.Info("Using GitHub repository host",
+			zap.String("apiUrl", config.GitHubApiUrl),
+			zap.String("owner", config.GitHubOwner),
+			zap.String("repo", config.GitHubRepo))
+		return NewGitHubHost(config.GitHubApiUrl, config.GitHubOwner, config.GitHubRepo, config.GitHubToken, nil), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown repository host %q, expected %s or %s", config.RepositoryHost, HostAzureDevOps, HostGitHub)
+	}
+}
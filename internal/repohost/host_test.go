@@ -0,0 +1,100 @@
+package repohost
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// apiRequest is a request received by a fake API.
+type apiRequest struct {
+	Method string
+	Path   string
+	Query  map[string]string
+	Body   map[string]any
+}
+
+// fakeAPI is a REST API served by httptest that records every request and answers from the
+// handlers registered for "METHOD /path".
+type fakeAPI struct {
+	t             *testing.T
+	authorization string
+	requests      []apiRequest
+	handlers      map[string]func(req apiRequest) (int, any)
+}
+
+// newFakeAPI starts a fake API that expects every request to carry authorization.
+func newFakeAPI(t *testing.T, authorization string) (*fakeAPI, *httptest.Server) {
+	fake := &fakeAPI{t: t, authorization: authorization, handlers: map[string]func(req apiRequest) (int, any){}}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+// handle registers the response to requests for method and path.
+func (f *fakeAPI) handle(method string, path string, handler func(req apiRequest) (int, any)) {
+	f.handlers[method+" "+path] = handler
+}
+
+func (f *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	if got := r.Header.Get("Authorization"); got != f.authorization {
+		f.t.Errorf("%s %s sent Authorization %q", r.Method, r.URL.Path, got)
+	}
+	req := apiRequest{Method: r.Method, Path: r.URL.Path, Query: map[string]string{}}
+	for key := range r.URL.Query() {
+		req.Query[key] = r.URL.Query().Get(key)
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req.Body); err != nil {
+			f.t.Errorf("%s %s sent a body that isn't a JSON object: %v", r.Method, r.URL.Path, err)
+		}
+	}
+	f.requests = append(f.requests, req)
+	handler, ok := f.handlers[r.Method+" "+r.URL.Path]
+	if !ok {
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	status, body := handler(req)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+// request returns the recorded request for method and path, failing the test when there is none.
+func (f *fakeAPI) request(method string, path string) apiRequest {
+	f.t.Helper()
+	for _, req := range f.requests {
+		if req.Method == method && req.Path == path {
+			return req
+		}
+	}
+	f.t.Fatalf("no %s %s request was made", method, path)
+	return apiRequest{}
+}
+
+// count returns the number of recorded requests for method and path.
+func (f *fakeAPI) count(method string, path string) int {
+	n := 0
+	for _, req := range f.requests {
+		if req.Method == method && req.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+// bodies returns the bodies of the recorded requests for method and path, in order.
+func (f *fakeAPI) bodies(method string, path string) []map[string]any {
+	var bodies []map[string]any
+	for _, req := range f.requests {
+		if req.Method == method && req.Path == path {
+			bodies = append(bodies, req.Body)
+		}
+	}
+	return bodies
+}